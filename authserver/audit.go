@@ -0,0 +1,41 @@
+package authserver
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// auditLog writes security relevant events as JSON lines, so they can be alerted on
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type auditEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	User   string    `json:"user,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{w: w}
+}
+
+func (al *auditLog) record(event string, user string, detail string) {
+	line, err := json.Marshal(auditEvent{Time: time.Now().UTC(), Event: event, User: user, Detail: detail})
+	if err != nil {
+		log.Printf("could not encode audit event '%s': %v", event, err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if _, err := al.w.Write(append(line, '\n')); err != nil {
+		log.Printf("could not write audit event '%s': %v", event, err)
+	}
+}
@@ -0,0 +1,112 @@
+package authserver
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	zkpautils "github.com/mischat/zkp_auth/utils"
+)
+
+// verifyBatcher collects the proofs that arrive within a small window of each other
+// and checks them together with zkpautils.BatchVerify
+// A login waits for at most the window before its proof is checked
+type verifyBatcher struct {
+	params   *zkpautils.Params
+	window   time.Duration
+	maxSize  int
+	requests chan batchRequest
+	// How many proofs the combined check has accepted
+	combined atomic.Int64
+	// closed by stop
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type batchRequest struct {
+	proof  zkpautils.BatchProof
+	result chan error
+}
+
+func newVerifyBatcher(params *zkpautils.Params, window time.Duration, maxSize int) *verifyBatcher {
+	b := &verifyBatcher{
+		params:   params,
+		window:   window,
+		maxSize:  maxSize,
+		requests: make(chan batchRequest, maxSize),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// verify hands the proof to the current batch and waits for its result
+func (b *verifyBatcher) verify(ctx context.Context, proof zkpautils.BatchProof) error {
+	req := batchRequest{proof: proof, result: make(chan error, 1)}
+
+	select {
+	case b.requests <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return errBatcherStopped
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return errBatcherStopped
+	}
+}
+
+var errBatcherStopped = errors.New("the server is shutting down")
+
+// stop ends run, and fails the proofs still waiting for it
+func (b *verifyBatcher) stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+}
+
+// The window starts with the first proof of a batch, and the batch is checked
+// when the window closes or the batch is full, whichever is first
+func (b *verifyBatcher) run() {
+	for {
+		var batch []batchRequest
+		select {
+		case req := <-b.requests:
+			batch = append(batch, req)
+		case <-b.done:
+			return
+		}
+		timer := time.NewTimer(b.window)
+
+	collect:
+		for len(batch) < b.maxSize {
+			select {
+			case req := <-b.requests:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		proofs := make([]zkpautils.BatchProof, len(batch))
+		for i, req := range batch {
+			proofs[i] = req.proof
+		}
+
+		errs, combined := zkpautils.BatchVerify(b.params, proofs, rand.Reader)
+		if combined {
+			b.combined.Add(int64(len(proofs)))
+		}
+		for i, req := range batch {
+			req.result <- errs[i]
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package authserver
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"time"
+)
+
+// A repeated (r1, r2) from the same user means either the client's RNG is broken,
+// so k was reused and x may already be lost, or someone is replaying an old login
+type commitmentHistory struct {
+	// Both bound how much we remember, the oldest commitments go first
+	maxPerUser int
+	retention  time.Duration
+
+	users map[string][]commitment
+	// When the users who stopped logging in were last dropped
+	lastSweep time.Time
+}
+
+type commitment struct {
+	digest [sha256.Size]byte
+	seenAt time.Time
+}
+
+func newCommitmentHistory(maxPerUser int, retention time.Duration, now time.Time) *commitmentHistory {
+	return &commitmentHistory{
+		maxPerUser: maxPerUser,
+		retention:  retention,
+		users:      make(map[string][]commitment),
+		lastSweep:  now,
+	}
+}
+
+// seen reports whether the user sent this commitment before, and remembers it if not
+// Only a digest of r1 and r2 is kept, which is enough to spot a repeat
+func (ch *commitmentHistory) seen(user string, r1 *big.Int, r2 *big.Int, now time.Time) bool {
+	ch.sweep(now)
+	digest := sha256.Sum256([]byte(r1.String() + ":" + r2.String()))
+
+	history := ch.prune(ch.users[user], now)
+	for _, c := range history {
+		if c.digest == digest {
+			ch.users[user] = history
+			return true
+		}
+	}
+
+	history = append(history, commitment{digest: digest, seenAt: now})
+	if len(history) > ch.maxPerUser {
+		history = history[len(history)-ch.maxPerUser:]
+	}
+	ch.users[user] = history
+	return false
+}
+
+// This drops the commitments that are older than the retention period
+// they are in the order we saw them, so the expired ones are at the front
+func (ch *commitmentHistory) prune(history []commitment, now time.Time) []commitment {
+	i := 0
+	for i < len(history) && now.Sub(history[i].seenAt) > ch.retention {
+		i++
+	}
+	return history[i:]
+}
+
+// This prunes every user at most once per retention period, and forgets the users
+// with nothing left, so that users who no longer log in don't stay in the map
+func (ch *commitmentHistory) sweep(now time.Time) {
+	if now.Sub(ch.lastSweep) < ch.retention {
+		return
+	}
+	for user, history := range ch.users {
+		if history = ch.prune(history, now); len(history) == 0 {
+			delete(ch.users, user)
+		} else {
+			ch.users[user] = history
+		}
+	}
+	ch.lastSweep = now
+}
@@ -0,0 +1,669 @@
+// Package authserver implements the Auth gRPC service: registration, the Chaum-Pedersen login
+// and sessions, all kept in memory. server/ runs it over TCP, the tests over bufconn.
+package authserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	zkpautils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The defaults of the optional Config fields
+const (
+	DefaultChallengeTTL        = time.Minute
+	DefaultCommitmentHistory   = 1000
+	DefaultCommitmentRetention = 24 * time.Hour
+	DefaultBatchSize           = 64
+)
+
+// The shortest KdfSecret we accept
+const minKdfSecretLen = 32
+
+// Config is how a Server is set up, the optional fields use the defaults above when they are 0
+type Config struct {
+	// The public variables, which the caller has already validated
+	Params *zkpautils.Params
+	// The size of the challenges in bits, 0 uses the whole of [1, q)
+	ChallengeBits int
+	// How long a client has to answer a challenge
+	ChallengeTTL time.Duration
+	// How many of each user's commitments we remember, and for how long, to spot replays
+	CommitmentHistory   int
+	CommitmentRetention time.Duration
+	// Proofs arriving within the window are checked together, 0 verifies each proof on its own
+	BatchWindow time.Duration
+	BatchSize   int
+	// Where audit events are written, stderr when nil
+	AuditLog io.Writer
+	// The key the KDF parameters of users without any are made up with, random when nil
+	// Set it for the made up parameters to stay the same across restarts, as real ones do
+	KdfSecret []byte
+	// The clock, time.Now when nil, tests move it on to expire challenges
+	Now func() time.Time
+}
+
+// Server implements pb.AuthServer
+type Server struct {
+	pb.UnimplementedAuthServer
+	// gRPC serves each call on its own goroutine, so the maps below
+	// are guarded by this mutex
+	mu sync.Mutex
+
+	userRegData map[string]UserRegistration
+	// Challenges that haven't been answered, they expire after challengeTTL
+	authenticationData map[string]*Authentication
+
+	sessionData map[string]Session
+
+	commitments *commitmentHistory
+	audit       *auditLog
+	// The powers of g and h, built once so that each proof needs fewer multiplications
+	precomputed *zkpautils.Precomputed
+	// This is nil when proofs are verified one at a time
+	batcher *verifyBatcher
+	// The challenges c are picked from
+	challenges *zkpautils.ChallengeSpace
+	// The key the made up KDF parameters are derived with
+	kdfSecret []byte
+
+	challengeTTL time.Duration
+	// When the expired challenges were last dropped
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// New returns a Server for the config, Close stops it
+func New(cfg Config) (*Server, error) {
+	if cfg.Params == nil {
+		return nil, fmt.Errorf("the server needs public variables")
+	}
+	if cfg.ChallengeTTL < 0 || cfg.CommitmentHistory < 0 || cfg.CommitmentRetention < 0 || cfg.BatchWindow < 0 || cfg.BatchSize < 0 {
+		return nil, fmt.Errorf("the durations and sizes of the server config must not be negative")
+	}
+	if cfg.ChallengeTTL == 0 {
+		cfg.ChallengeTTL = DefaultChallengeTTL
+	}
+	if cfg.CommitmentHistory == 0 {
+		cfg.CommitmentHistory = DefaultCommitmentHistory
+	}
+	if cfg.CommitmentRetention == 0 {
+		cfg.CommitmentRetention = DefaultCommitmentRetention
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.AuditLog == nil {
+		cfg.AuditLog = os.Stderr
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.KdfSecret == nil {
+		cfg.KdfSecret = make([]byte, minKdfSecretLen)
+		if _, err := rand.Read(cfg.KdfSecret); err != nil {
+			return nil, fmt.Errorf("could not generate the kdf secret: %v", err)
+		}
+	}
+	if len(cfg.KdfSecret) < minKdfSecretLen {
+		return nil, fmt.Errorf("the kdf secret must be at least %d bytes, got %d", minKdfSecretLen, len(cfg.KdfSecret))
+	}
+
+	challenges, err := zkpautils.NewChallengeSpace(cfg.Params, cfg.ChallengeBits)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	precomputed := zkpautils.Precompute(cfg.Params)
+	log.Printf("precomputed the powers of g and h in %v", time.Since(start).Round(time.Millisecond))
+
+	srv := &Server{
+		userRegData:        make(map[string]UserRegistration),
+		authenticationData: make(map[string]*Authentication),
+		sessionData:        make(map[string]Session),
+		commitments:        newCommitmentHistory(cfg.CommitmentHistory, cfg.CommitmentRetention, cfg.Now()),
+		audit:              newAuditLog(cfg.AuditLog),
+		precomputed:        precomputed,
+		challenges:         challenges,
+		kdfSecret:          cfg.KdfSecret,
+		challengeTTL:       cfg.ChallengeTTL,
+		lastPrune:          cfg.Now(),
+		now:                cfg.Now,
+	}
+	// Outside of safe-prime groups BatchVerify checks the proofs one by one anyway,
+	// so a batch would only make every login wait for the window
+	if cfg.BatchWindow > 0 && !zkpautils.CanBatch(cfg.Params) {
+		log.Printf("WARNING: proofs can only be batched in a safe-prime group with q over 128 bits, verifying each proof on its own")
+	} else if cfg.BatchWindow > 0 {
+		srv.batcher = newVerifyBatcher(cfg.Params, cfg.BatchWindow, cfg.BatchSize)
+	}
+	return srv, nil
+}
+
+// BatchedProofs returns how many proofs the batcher has accepted with one combined check
+// rather than one by one, 0 when proofs are not batched
+func (srv *Server) BatchedProofs() int64 {
+	if srv.batcher == nil {
+		return 0
+	}
+	return srv.batcher.combined.Load()
+}
+
+// Close stops the batcher, if there is one
+// Logins still waiting on a batch get an error
+func (srv *Server) Close() {
+	if srv.batcher != nil {
+		srv.batcher.stop()
+	}
+}
+
+// Challenges returns the space the challenges are picked from
+func (srv *Server) Challenges() *zkpautils.ChallengeSpace {
+	return srv.challenges
+}
+
+// This stores the user registration data against the user ID
+type UserRegistration struct {
+	y1 *big.Int
+	y2 *big.Int
+	// This is nil when the client manages x itself
+	kdf *pb.KdfParameters
+}
+
+// This stores the authentication data against the auth ID
+type Authentication struct {
+	id        string
+	user      string
+	r1        *big.Int
+	r2        *big.Int
+	c         *big.Int
+	createdAt time.Time
+	// Set once the challenge has been answered, guarded by the server's mutex
+	answered bool
+}
+
+// C returns the challenge the client has to answer
+func (auth *Authentication) C() *big.Int {
+	return new(big.Int).Set(auth.c)
+}
+
+// This stores the session data against the session ID
+type Session struct {
+	user      string
+	createdAt time.Time
+}
+
+// Generate a random string of length n
+// We will use these for string IDs
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(b)[:n]
+}
+
+// This implements the Register gRPC call
+// Note that this implementation does not support updating a user's registration info
+func (srv *Server) Register(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	log.Printf("Received UserID: %v", in.GetUser())
+	log.Printf("Received Y1: %v", in.GetY1())
+	log.Printf("Received Y2: %v", in.GetY2())
+
+	y1, err := srv.parseNumber("y1", in.GetY1())
+	if err != nil {
+		return &pb.RegisterResponse{}, err
+	}
+	y2, err := srv.parseNumber("y2", in.GetY2())
+	if err != nil {
+		return &pb.RegisterResponse{}, err
+	}
+
+	if err := validateKdf(in.GetKdf()); err != nil {
+		return &pb.RegisterResponse{}, err
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	// Retrieve User from the map
+	_, exists := srv.userRegData[in.GetUser()]
+	if exists {
+		return &pb.RegisterResponse{}, status.Errorf(codes.AlreadyExists, "user '%v' already exists", in.GetUser())
+	}
+
+	// y1 and y2 must be in the subgroup, or the proofs about them mean nothing
+	if err := zkpautils.ValidateStatement(srv.precomputed.Params, y1, y2); err != nil {
+		return &pb.RegisterResponse{}, status.Errorf(codes.InvalidArgument, "invalid registration: %v", err)
+	}
+
+	// Store Y1 and Y2 in the userRegData map
+	srv.userRegData[in.GetUser()] = UserRegistration{
+		y1:  y1,
+		y2:  y2,
+		kdf: in.GetKdf(),
+	}
+
+	log.Printf("Stored UserID: %v", in.GetUser())
+
+	return &pb.RegisterResponse{}, nil
+}
+
+// This is the second step in the authentication process
+// The client will send the r1 and r2 values based on a random value k
+func (srv *Server) CreateAuthenticationChallenge(ctx context.Context, in *pb.AuthenticationChallengeRequest) (*pb.AuthenticationChallengeResponse, error) {
+	log.Printf("Received UserID: %v", in.GetUser())
+	log.Printf("Received R1: %v", in.GetR1())
+	log.Printf("Received R2: %v", in.GetR2())
+
+	auth, err := srv.Challenge(in.GetUser(), in.GetR1(), in.GetR2())
+	if err != nil {
+		return &pb.AuthenticationChallengeResponse{}, err
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	// Store c in the authenticationmap, until it is answered or expires
+	srv.pruneChallenges(auth.createdAt)
+	srv.authenticationData[auth.id] = auth
+
+	return &pb.AuthenticationChallengeResponse{AuthId: auth.id, C: auth.c.String()}, nil
+
+}
+
+// Challenge checks the commitment of a login and picks its challenge, without storing it
+// The unary RPCs keep the challenge under its auth ID, logins bound to a connection hold it
+// themselves so that it goes when the connection does
+func (srv *Server) Challenge(user string, r1Value string, r2Value string) (*Authentication, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	now := srv.now()
+
+	// Retrieve User from the map
+	_, exists := srv.userRegData[user]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "user doesn't exists")
+	}
+
+	r1, err := srv.parseNumber("r1", r1Value)
+	if err != nil {
+		return nil, err
+	}
+	r2, err := srv.parseNumber("r2", r2Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Junk is refused now, rather than stored until the answer comes in
+	if err := zkpautils.ValidateCommitment(srv.precomputed.Params, r1, r2); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid commitment: %v", err)
+	}
+
+	// An honest client picks a fresh k for every login, so (r1, r2) should never repeat
+	if srv.commitments.seen(user, r1, r2, now) {
+		srv.audit.record("commitment_reused", user, fmt.Sprintf("r1:'%d' r2:'%d'", r1, r2))
+		return nil, status.Errorf(codes.InvalidArgument, "commitment has been used before")
+	}
+
+	// Now the challenger picks a random value c, uniformly from [1, q) or the smaller space set with -challenge-bits
+	// It has to be unpredictable, a prover who knows c before committing can answer it without x
+	c, err := srv.challenges.Sample(rand.Reader)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not pick a challenge: %v", err)
+	}
+	log.Printf("Generated random c: %d", c)
+
+	return &Authentication{
+		id:        randomString(20),
+		user:      user,
+		r1:        r1,
+		r2:        r2,
+		c:         c,
+		createdAt: now,
+	}, nil
+}
+
+// This is the third step in the authentication process
+// This is where the verifier proofs authentication with no knowledge of the secret x
+func (srv *Server) VerifyAuthentication(ctx context.Context, in *pb.AuthenticationAnswerRequest) (*pb.AuthenticationAnswerResponse, error) {
+	log.Printf("Received AuthID: %v", in.GetAuthId())
+	log.Printf("Received S: %v", in.GetS())
+
+	s, err := srv.parseNumber("s", in.GetS())
+	if err != nil {
+		return &pb.AuthenticationAnswerResponse{}, err
+	}
+
+	srv.mu.Lock()
+
+	// Retrieve Auth object from map
+	auth, exists := srv.authenticationData[in.GetAuthId()]
+	if !exists {
+		srv.mu.Unlock()
+		return &pb.AuthenticationAnswerResponse{}, status.Errorf(codes.NotFound, "authId doesn't exists: %v", in.GetAuthId())
+	}
+
+	// This deletes the old authentication data object
+	// as we don't want to use it again, not even to retry with another s.
+	// Doing it now also means that nobody else can answer the same challenge while we verify
+	delete(srv.authenticationData, in.GetAuthId())
+	srv.mu.Unlock()
+
+	sessionId, err := srv.answer(ctx, auth, s)
+	if err != nil {
+		return &pb.AuthenticationAnswerResponse{}, err
+	}
+	return &pb.AuthenticationAnswerResponse{SessionId: sessionId}, nil
+}
+
+// Answer checks s against a challenge from Challenge, and returns a new session ID when the proof verifies
+// A challenge can only be answered once, whether or not the answer was right
+func (srv *Server) Answer(ctx context.Context, auth *Authentication, sValue string) (string, error) {
+	s, err := srv.parseNumber("s", sValue)
+	if err != nil {
+		return "", err
+	}
+	return srv.answer(ctx, auth, s)
+}
+
+func (srv *Server) answer(ctx context.Context, auth *Authentication, s *big.Int) (string, error) {
+	if _, err := srv.checkAnswer(ctx, auth, s); err != nil {
+		return "", err
+	}
+
+	log.Println("Proof verified!")
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	// Now we mint a sessionID
+	sessionId := randomString(20)
+
+	// Now we store the sessionID against the user, with a createdAt timestamp
+	// for the future
+	srv.sessionData[sessionId] = Session{
+		user:      auth.user,
+		createdAt: srv.now(),
+	}
+
+	return sessionId, nil
+}
+
+// This checks s against the challenge, and returns the registration the proof was checked against
+// It marks the challenge as answered, whether or not the proof verifies
+func (srv *Server) checkAnswer(ctx context.Context, auth *Authentication, s *big.Int) (UserRegistration, error) {
+	srv.mu.Lock()
+
+	if auth.answered {
+		srv.mu.Unlock()
+		return UserRegistration{}, status.Errorf(codes.FailedPrecondition, "the challenge has been answered already")
+	}
+	auth.answered = true
+
+	// Retrieve User from the map
+	user, exists := srv.userRegData[auth.user]
+	if !exists {
+		srv.mu.Unlock()
+		return UserRegistration{}, status.Errorf(codes.NotFound, "user doesn't exists: %v", auth.user)
+	}
+
+	// A challenge that has been around for too long was probably left behind, or is being worked on offline
+	if srv.now().Sub(auth.createdAt) > srv.challengeTTL {
+		srv.mu.Unlock()
+		srv.audit.record("challenge_expired", auth.user, fmt.Sprintf("authId:'%s'", auth.id))
+		return UserRegistration{}, status.Errorf(codes.DeadlineExceeded, "the challenge has expired, it has to be answered within %v", srv.challengeTTL)
+	}
+
+	// The proof is checked without holding the lock, so that concurrent logins can be batched
+	srv.mu.Unlock()
+
+	// Now we have all the data we need to validate the proof
+	// Now the verifier needs to verify the proof
+	if err := srv.verifyProof(ctx, auth, user, s); err != nil {
+		return UserRegistration{}, status.Errorf(proofCode(err), "could not verify the proof: %v", err)
+	}
+	return user, nil
+}
+
+// This drops the challenges nobody answered in time, at most once per challengeTTL
+// so that challenges don't pile up, without going through all of them on every login
+func (srv *Server) pruneChallenges(now time.Time) {
+	if now.Sub(srv.lastPrune) < srv.challengeTTL {
+		return
+	}
+	for authId, auth := range srv.authenticationData {
+		if now.Sub(auth.createdAt) > srv.challengeTTL {
+			delete(srv.authenticationData, authId)
+		}
+	}
+	srv.lastPrune = now
+}
+
+// This checks s against the challenge, on its own or as part of a batch
+func (srv *Server) verifyProof(ctx context.Context, auth *Authentication, user UserRegistration, s *big.Int) error {
+	if srv.batcher != nil {
+		return srv.batcher.verify(ctx, zkpautils.BatchProof{Y1: user.y1, Y2: user.y2, R1: auth.r1, R2: auth.r2, C: auth.c, S: s})
+	}
+
+	// r1 = g^s . y1^c mod p
+	// r2 = h^s . y2^c mod p
+	return srv.precomputed.VerifyChaumPedersen(user.y1, user.y2, auth.r1, auth.r2, auth.c, s)
+}
+
+// A proof that was checked and refused is Unauthenticated, one that couldn't be checked is not
+func proofCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, errBatcherStopped):
+		return codes.Unavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Code()
+	default:
+		return codes.Unauthenticated
+	}
+}
+
+// This returns the public variables the server was started with
+// so that clients can check they are using the same group
+func (srv *Server) GetPublicParameters(ctx context.Context, in *pb.PublicParametersRequest) (*pb.PublicParametersResponse, error) {
+	params := srv.precomputed.Params
+	return &pb.PublicParametersResponse{P: params.P.String(), Q: params.Q.String(), G: params.G.String(), H: params.H.String()}, nil
+}
+
+// This returns the user that a session belongs to
+func (srv *Server) WhoAmI(ctx context.Context, in *pb.WhoAmIRequest) (*pb.WhoAmIResponse, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	session, exists := srv.sessionData[in.GetSessionId()]
+	if !exists {
+		return &pb.WhoAmIResponse{}, status.Errorf(codes.Unauthenticated, "session doesn't exists")
+	}
+
+	return &pb.WhoAmIResponse{User: session.user, CreatedAt: session.createdAt.Unix()}, nil
+}
+
+// This ends a session, logging out an unknown session is not an error
+func (srv *Server) Logout(ctx context.Context, in *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delete(srv.sessionData, in.GetSessionId())
+
+	return &pb.LogoutResponse{}, nil
+}
+
+// This replaces the registration data of the logged in user
+// A session on its own could have been stolen, so the client also has to answer a challenge
+// with the old secret. The user's other sessions end, the one the rotation was made with stays
+func (srv *Server) Rotate(ctx context.Context, in *pb.RotateRequest) (*pb.RotateResponse, error) {
+	log.Printf("Received Y1: %v", in.GetY1())
+	log.Printf("Received Y2: %v", in.GetY2())
+	log.Printf("Received AuthID: %v", in.GetAuthId())
+
+	y1, err := srv.parseNumber("y1", in.GetY1())
+	if err != nil {
+		return &pb.RotateResponse{}, err
+	}
+	y2, err := srv.parseNumber("y2", in.GetY2())
+	if err != nil {
+		return &pb.RotateResponse{}, err
+	}
+	s, err := srv.parseNumber("s", in.GetS())
+	if err != nil {
+		return &pb.RotateResponse{}, err
+	}
+
+	if err := zkpautils.ValidateStatement(srv.precomputed.Params, y1, y2); err != nil {
+		return &pb.RotateResponse{}, status.Errorf(codes.InvalidArgument, "invalid rotation: %v", err)
+	}
+
+	if err := validateKdf(in.GetKdf()); err != nil {
+		return &pb.RotateResponse{}, err
+	}
+
+	srv.mu.Lock()
+	session, exists := srv.sessionData[in.GetSessionId()]
+	if !exists {
+		srv.mu.Unlock()
+		return &pb.RotateResponse{}, status.Errorf(codes.Unauthenticated, "session doesn't exists")
+	}
+	// The challenge goes whatever happens next, as it does for a login
+	auth, exists := srv.authenticationData[in.GetAuthId()]
+	if !exists {
+		srv.mu.Unlock()
+		return &pb.RotateResponse{}, status.Errorf(codes.NotFound, "authId doesn't exists: %v", in.GetAuthId())
+	}
+	delete(srv.authenticationData, in.GetAuthId())
+	srv.mu.Unlock()
+
+	if auth.user != session.user {
+		srv.audit.record("rotate_refused", session.user, fmt.Sprintf("authId:'%s' is for user '%s'", auth.id, auth.user))
+		return &pb.RotateResponse{}, status.Errorf(codes.PermissionDenied, "the challenge is for another user")
+	}
+	old, err := srv.checkAnswer(ctx, auth, s)
+	if err != nil {
+		srv.audit.record("rotate_refused", session.user, fmt.Sprintf("authId:'%s'", auth.id))
+		return &pb.RotateResponse{}, err
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	// Another rotation may have got in while the proof was checked, the proof is then for a secret that is gone
+	if current := srv.userRegData[session.user]; current.y1 != old.y1 || current.y2 != old.y2 {
+		return &pb.RotateResponse{}, status.Errorf(codes.Aborted, "the secret was rotated while the proof was checked")
+	}
+
+	srv.userRegData[session.user] = UserRegistration{
+		y1:  y1,
+		y2:  y2,
+		kdf: in.GetKdf(),
+	}
+
+	// Whoever else held a session only knew the old secret, or stole a session
+	for sessionId, other := range srv.sessionData {
+		if other.user == session.user && sessionId != in.GetSessionId() {
+			delete(srv.sessionData, sessionId)
+		}
+	}
+
+	log.Printf("Rotated secret for UserID: %v", session.user)
+
+	return &pb.RotateResponse{}, nil
+}
+
+// This returns the salt and KDF parameters a user registered with
+// so that they can derive x from their passphrase on any device
+// Anyone can ask, so a user that doesn't exist, or registered without a passphrase, gets
+// made up parameters rather than an error, or the answer would tell which accounts exist
+func (srv *Server) GetKdfParameters(ctx context.Context, in *pb.KdfParametersRequest) (*pb.KdfParametersResponse, error) {
+	log.Printf("Received UserID: %v", in.GetUser())
+
+	srv.mu.Lock()
+	user, exists := srv.userRegData[in.GetUser()]
+	srv.mu.Unlock()
+
+	if exists && user.kdf != nil {
+		return &pb.KdfParametersResponse{Kdf: user.kdf}, nil
+	}
+	kdf, err := srv.fakeKdf(in.GetUser())
+	if err != nil {
+		return &pb.KdfParametersResponse{}, status.Errorf(codes.Internal, "could not make up kdf parameters: %v", err)
+	}
+	return &pb.KdfParametersResponse{Kdf: kdf}, nil
+}
+
+// This makes up KDF parameters for a user, they have the defaults a client registers with
+// and a salt from an HMAC of the user ID, so that asking twice gets the same answer
+func (srv *Server) fakeKdf(user string) (*pb.KdfParameters, error) {
+	params, err := zkpautils.NewKDFParams(zkpautils.KDFArgon2id)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, srv.kdfSecret)
+	mac.Write([]byte(user))
+	salt := mac.Sum(nil)[:len(params.Salt)]
+
+	return &pb.KdfParameters{
+		Algorithm: params.Algorithm,
+		Salt:      salt,
+		Time:      params.Time,
+		Memory:    params.Memory,
+		Threads:   uint32(params.Threads),
+	}, nil
+}
+
+// This parses a number the client sent in decimal
+// Every number in the protocol is below p, so anything with more digits is refused before
+// it is parsed, SetString takes more than linear time and a message can be a few MB
+func (srv *Server) parseNumber(name string, value string) (*big.Int, error) {
+	if maxDigits := len(srv.precomputed.Params.P.String()) + 1; len(value) > maxDigits {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is too long: %d characters, the most is %d", name, len(value), maxDigits)
+	}
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a number: '%v'", name, value)
+	}
+	return n, nil
+}
+
+// This makes sure that we only store KDF parameters that a client can use later
+// no KDF parameters is fine, the client is then using x directly
+func validateKdf(kdf *pb.KdfParameters) error {
+	if kdf == nil {
+		return nil
+	}
+	if kdf.GetThreads() > 255 {
+		return status.Errorf(codes.InvalidArgument, "kdf threads:'%d' is over the maximum of 255", kdf.GetThreads())
+	}
+
+	params := zkpautils.KDFParams{
+		Algorithm: kdf.GetAlgorithm(),
+		Salt:      kdf.GetSalt(),
+		Time:      kdf.GetTime(),
+		Memory:    kdf.GetMemory(),
+		Threads:   uint8(kdf.GetThreads()),
+	}
+	if err := params.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid kdf parameters: %v", err)
+	}
+	return nil
+}
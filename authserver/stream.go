@@ -0,0 +1,75 @@
+package authserver
+
+import (
+	"log"
+	"time"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// This runs one login on one stream: the commitment, the challenge, the answer and the session
+// The challenge is only held by this call, so it never goes into authenticationData and is gone
+// with the stream, whether the client answered or not
+func (srv *Server) Authenticate(stream pb.Auth_AuthenticateServer) error {
+	first, err := srv.receive(stream)
+	if err != nil {
+		return err
+	}
+	commit := first.GetCommit()
+	if commit == nil {
+		return status.Errorf(codes.InvalidArgument, "the first message must be the commitment")
+	}
+	log.Printf("Received UserID: %v", commit.GetUser())
+	log.Printf("Received R1: %v", commit.GetR1())
+	log.Printf("Received R2: %v", commit.GetR2())
+
+	auth, err := srv.Challenge(commit.GetUser(), commit.GetR1(), commit.GetR2())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.AuthenticateResponse{Step: &pb.AuthenticateResponse_Challenge{Challenge: &pb.AuthenticateChallenge{C: auth.c.String()}}}); err != nil {
+		return err
+	}
+
+	second, err := srv.receive(stream)
+	if err != nil {
+		return err
+	}
+	answer := second.GetAnswer()
+	if answer == nil {
+		return status.Errorf(codes.InvalidArgument, "the second message must be the answer")
+	}
+	log.Printf("Received S: %v", answer.GetS())
+
+	sessionId, err := srv.Answer(stream.Context(), auth, answer.GetS())
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pb.AuthenticateResponse{Step: &pb.AuthenticateResponse_Session{Session: &pb.AuthenticationAnswerResponse{SessionId: sessionId}}})
+}
+
+// This waits for the next message for at most challengeTTL, a client that goes quiet
+// would otherwise keep its stream, and the goroutine serving it, forever
+func (srv *Server) receive(stream pb.Auth_AuthenticateServer) (*pb.AuthenticateRequest, error) {
+	type received struct {
+		req *pb.AuthenticateRequest
+		err error
+	}
+	// buffered, so that the goroutine can finish when nobody is waiting any more
+	done := make(chan received, 1)
+	go func() {
+		req, err := stream.Recv()
+		done <- received{req, err}
+	}()
+
+	timer := time.NewTimer(srv.challengeTTL)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.req, r.err
+	case <-timer.C:
+		return nil, status.Errorf(codes.DeadlineExceeded, "no message within %v, the login has to be finished within the challenge TTL", srv.challengeTTL)
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	zkpautils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func runRegister(env *env, args []string) error {
+	fs := newFlagSet("register")
+	uFlag := fs.String("u", "", "the client id")
+	xFlag := fs.String("x", "", "the client secret, when it is not set x is derived from a passphrase")
+	kdfFlag := fs.String("kdf", zkpautils.KDFArgon2id, "the KDF used to derive x from the passphrase, either 'argon2id' or 'pbkdf2-sha256'")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *uFlag == "" {
+		return usageError{"-u is required"}
+	}
+	user := *uFlag
+
+	if err := env.checkServerParams(); err != nil {
+		return err
+	}
+
+	x, kdf, err := newSecret(*xFlag, *kdfFlag, env.params.Q, readPassphrase)
+	if err != nil {
+		return err
+	}
+
+	// Now to calculate y1 and y2
+	y1, y2 := commitToSecret(env.arith, env.params, x)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	_, err = pb.NewAuthClient(env.conn).Register(ctx, &pb.RegisterRequest{User: user, Y1: y1.String(), Y2: y2.String(), Kdf: kdf})
+	if err != nil {
+		return rpcError(ctx, "could not register", err)
+	}
+	log.Printf("Registered user %s with Y1=%d and Y2=%d", user, y1, y2)
+
+	return env.out.print(fmt.Sprintf("Registered user '%s'", user), struct {
+		User string `json:"user"`
+	}{user})
+}
+
+func runLogin(env *env, args []string) error {
+	fs := newFlagSet("login")
+	uFlag := fs.String("u", "", "the client id")
+	xFlag := fs.String("x", "", "the client secret, when it is not set x comes from the keystore or a passphrase")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *uFlag == "" {
+		return usageError{"-u is required"}
+	}
+	user := *uFlag
+
+	if err := env.checkServerParams(); err != nil {
+		return err
+	}
+
+	client := pb.NewAuthClient(env.conn)
+
+	// This may ask for a passphrase, so the timeout only starts afterwards
+	x, err := loginSecret(env, client, user, *xFlag)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	sessionId, err := authenticate(ctx, client, env.arith, env.params, env.nonces, user, x)
+	if err != nil {
+		return err
+	}
+
+	err = env.sessions.save(*addrFlag, session{User: user, SessionId: sessionId})
+	if err != nil {
+		return err
+	}
+
+	return env.out.print(fmt.Sprintf("Logged in as '%s', session ID: '%s'", user, sessionId), struct {
+		User      string `json:"user"`
+		SessionId string `json:"session_id"`
+	}{user, sessionId})
+}
+
+func runLogout(env *env, args []string) error {
+	if err := parseFlags(newFlagSet("logout"), args); err != nil {
+		return err
+	}
+
+	sess, err := env.sessions.load(*addrFlag)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	_, err = pb.NewAuthClient(env.conn).Logout(ctx, &pb.LogoutRequest{SessionId: sess.SessionId})
+	if err != nil {
+		return rpcError(ctx, "could not logout", err)
+	}
+
+	err = env.sessions.remove(*addrFlag)
+	if err != nil {
+		return err
+	}
+
+	return env.out.print(fmt.Sprintf("Logged out '%s'", sess.User), struct {
+		User string `json:"user"`
+	}{sess.User})
+}
+
+func runWhoAmI(env *env, args []string) error {
+	if err := parseFlags(newFlagSet("whoami"), args); err != nil {
+		return err
+	}
+
+	sess, err := env.sessions.load(*addrFlag)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	resp, err := pb.NewAuthClient(env.conn).WhoAmI(ctx, &pb.WhoAmIRequest{SessionId: sess.SessionId})
+	if err != nil {
+		return rpcError(ctx, "could not look up session", err)
+	}
+
+	createdAt := time.Unix(resp.GetCreatedAt(), 0).UTC()
+
+	return env.out.print(fmt.Sprintf("Logged in as '%s' since %s", resp.GetUser(), createdAt.Format(time.RFC3339)), struct {
+		User      string    `json:"user"`
+		SessionId string    `json:"session_id"`
+		CreatedAt time.Time `json:"created_at"`
+	}{resp.GetUser(), sess.SessionId, createdAt})
+}
+
+func runParams(env *env, args []string) error {
+	if len(args) == 0 || args[0] != "fetch" {
+		return usageError{"params needs a subcommand"}
+	}
+	if err := parseFlags(newFlagSet("params fetch"), args[1:]); err != nil {
+		return err
+	}
+
+	remote, err := env.serverParams()
+	if err != nil {
+		return err
+	}
+	matches := remote.Equal(env.params)
+
+	text := fmt.Sprintf("p: %v\nq: %v\ng: %v\nh: %v\nfingerprint: %s\nmatches local: %t", remote.P, remote.Q, remote.G, remote.H, remote.Fingerprint(), matches)
+	return env.out.print(text, struct {
+		P           string `json:"p"`
+		Q           string `json:"q"`
+		G           string `json:"g"`
+		H           string `json:"h"`
+		Fingerprint string `json:"fingerprint"`
+		Matches     bool   `json:"matches_local"`
+	}{remote.P.String(), remote.Q.String(), remote.G.String(), remote.H.String(), remote.Fingerprint(), matches})
+}
+
+// This fetches the public variables the server uses
+func (e *env) serverParams() (*zkpautils.Params, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	resp, err := pb.NewAuthClient(e.conn).GetPublicParameters(ctx, &pb.PublicParametersRequest{})
+	if err != nil {
+		return nil, rpcError(ctx, "could not fetch public variables", err)
+	}
+	return zkpautils.ParseParams(resp.GetP(), resp.GetQ(), resp.GetG(), resp.GetH())
+}
+
+// A proof made with different public variables to the server's can never verify,
+// so we compare fingerprints before starting, rather than fail later with "r1 does not match"
+func (e *env) checkServerParams() error {
+	remote, err := e.serverParams()
+	if err != nil {
+		return err
+	}
+	if !remote.Equal(e.params) {
+		return fmt.Errorf("the server's public variables have fingerprint %s, but ours have %s", remote.Fingerprint(), e.params.Fingerprint())
+	}
+	return nil
+}
+
+func runRotate(env *env, args []string) error {
+	fs := newFlagSet("rotate")
+	oldXFlag := fs.String("old-x", "", "the current client secret, when it is not set x comes from the keystore or a passphrase")
+	xFlag := fs.String("x", "", "the new client secret, when it is not set x is derived from a new passphrase")
+	kdfFlag := fs.String("kdf", zkpautils.KDFArgon2id, "the KDF used to derive x from the passphrase, either 'argon2id' or 'pbkdf2-sha256'")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	sess, err := env.sessions.load(*addrFlag)
+	if err != nil {
+		return err
+	}
+
+	if err := env.checkServerParams(); err != nil {
+		return err
+	}
+
+	client := pb.NewAuthClient(env.conn)
+
+	// The server wants a proof with the old secret as well as the session
+	oldX, err := loginSecret(env, client, sess.User, *oldXFlag)
+	if err != nil {
+		return err
+	}
+
+	x, kdf, err := newSecret(*xFlag, *kdfFlag, env.params.Q, readNewPassphrase)
+	if err != nil {
+		return err
+	}
+
+	y1, y2 := commitToSecret(env.arith, env.params, x)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	k, err := pickK(env.params, env.nonces, sess.User, oldX)
+	if err != nil {
+		return err
+	}
+	authId, s, err := answerChallenge(ctx, client, env.arith, env.params, sess.User, k, oldX)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Rotate(ctx, &pb.RotateRequest{SessionId: sess.SessionId, Y1: y1.String(), Y2: y2.String(), Kdf: kdf, AuthId: authId, S: s.String()})
+	if err != nil {
+		return rpcError(ctx, "could not rotate secret", err)
+	}
+
+	updated, err := updateKeystore(env.identity(sess.User), x)
+	if err != nil {
+		return fmt.Errorf("rotated secret, but could not update the keystore: %v", err)
+	}
+
+	return env.out.print(fmt.Sprintf("Rotated secret for '%s'", sess.User), struct {
+		User     string `json:"user"`
+		Keystore bool   `json:"keystore_updated"`
+	}{sess.User, updated})
+}
+
+// This finds x for a login, in order: the command line, the keystore, the passphrase
+func loginSecret(env *env, client pb.AuthClient, user string, secret string) (*big.Int, error) {
+	if secret != "" {
+		x, ok := new(big.Int).SetString(secret, 10)
+		if !ok {
+			return nil, usageError{"-x must be a number"}
+		}
+		return x, nil
+	}
+
+	x, err := secretFromKeystore(env.identity(user))
+	if err != nil || x != nil {
+		return x, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	defer cancel()
+
+	resp, err := client.GetKdfParameters(ctx, &pb.KdfParametersRequest{User: user})
+	if err != nil {
+		return nil, rpcError(ctx, "could not fetch kdf parameters", err)
+	}
+	return secretFromPassphrase(resp.GetKdf(), env.params.Q)
+}
+
+// This runs the Chaum-Pedersen authentication dance and returns the session ID
+func authenticate(ctx context.Context, c pb.AuthClient, arith zkpautils.Arithmetic, params *zkpautils.Params, nonces *nonceStore, user string, x *big.Int) (string, error) {
+	k, err := pickK(params, nonces, user, x)
+	if err != nil {
+		return "", err
+	}
+
+	if *streamFlag {
+		// Now to calculate (r1, r2) = g^k, h^k
+		r1, r2 := commitToSecret(arith, params, k)
+		return authenticateOverStream(ctx, c, user, r1, r2, func(chal *big.Int) *big.Int { return arith.CalculateS(k, chal, x) })
+	}
+
+	authId, s, err := answerChallenge(ctx, c, arith, params, user, k, x)
+	if err != nil {
+		return "", err
+	}
+
+	verResp, err := c.VerifyAuthentication(ctx, &pb.AuthenticationAnswerRequest{AuthId: authId, S: s.String()})
+	if err != nil {
+		return "", rpcError(ctx, "failed to auth", err)
+	}
+
+	log.Printf("Success, this is our session ID: '%s'", verResp.SessionId)
+	return verResp.SessionId, nil
+}
+
+// This picks the k of one proof, and remembers it so that it is never picked again
+func pickK(params *zkpautils.Params, nonces *nonceStore, user string, x *big.Int) (*big.Int, error) {
+	// Reusing k for two different challenges reveals x, so k must never repeat.
+	// A contiguous nonce doesn't work either, two ks with a known difference leak x just the same.
+	// By default k is derived from x and the login as in RFC 6979, hedged with fresh randomness,
+	// so that a broken RNG on its own can't cause a repeat.
+	// The server, user and group are the same for every login, so the time goes in the transcript too,
+	// without it the randomness would be all that tells two logins apart.
+	var k *big.Int
+	if *nonceFlag == "hedged" {
+		now := strconv.FormatInt(time.Now().UnixNano(), 10)
+		transcript, err := json.Marshal([]string{"zkp_auth login", *addrFlag, user, params.Fingerprint(), now})
+		if err != nil {
+			return nil, err
+		}
+		k, err = zkpautils.DeterministicK(x, params.Q, transcript, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		k, err = zkpautils.RandomScalar(params.Q, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Generated k: %d", k)
+
+	// Every k we have used is remembered, so that we never answer two challenges with the same one
+	if err := nonces.use(k); err != nil {
+		return nil, fmt.Errorf("refusing to log in: %v", err)
+	}
+	return k, nil
+}
+
+// This commits to k, asks for a challenge and answers it with x
+// It returns the auth ID and s, for VerifyAuthentication or Rotate to check
+func answerChallenge(ctx context.Context, c pb.AuthClient, arith zkpautils.Arithmetic, params *zkpautils.Params, user string, k *big.Int, x *big.Int) (string, *big.Int, error) {
+	// Now to calculate (r1, r2) = g^k, h^k
+	r1, r2 := commitToSecret(arith, params, k)
+
+	resp, err := c.CreateAuthenticationChallenge(ctx, &pb.AuthenticationChallengeRequest{User: user, R1: r1.String(), R2: r2.String()})
+	if err != nil {
+		return "", nil, rpcError(ctx, "failed to create auth challenge", err)
+	}
+
+	authId := resp.AuthId
+	chal, ok := new(big.Int).SetString(resp.C, 10)
+	if !ok {
+		return "", nil, fmt.Errorf("server sent a challenge that is not a number: '%s'", resp.C)
+	}
+	log.Printf("authId: %s c: %d", authId, chal)
+
+	// Not to calculate s = (k - c .x) mod q
+	return authId, arith.CalculateS(k, chal, x), nil
+}
+
+// This runs the same dance over the streaming Authenticate call, answering the challenge with respond
+// The server keeps no state for the login once the stream is closed
+func authenticateOverStream(ctx context.Context, c pb.AuthClient, user string, r1, r2 *big.Int, respond func(chal *big.Int) *big.Int) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.Authenticate(ctx)
+	if err != nil {
+		return "", rpcError(ctx, "failed to start the login", err)
+	}
+	commit := &pb.AuthenticationChallengeRequest{User: user, R1: r1.String(), R2: r2.String()}
+	if err := stream.Send(&pb.AuthenticateRequest{Step: &pb.AuthenticateRequest_Commit{Commit: commit}}); err != nil {
+		return "", streamError(ctx, "failed to send the commitment", stream, err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return "", rpcError(ctx, "failed to create auth challenge", err)
+	}
+	chal, ok := new(big.Int).SetString(resp.GetChallenge().GetC(), 10)
+	if !ok {
+		return "", fmt.Errorf("server sent a challenge that is not a number: '%s'", resp.GetChallenge().GetC())
+	}
+	log.Printf("c: %d", chal)
+
+	s := respond(chal)
+	if err := stream.Send(&pb.AuthenticateRequest{Step: &pb.AuthenticateRequest_Answer{Answer: &pb.AuthenticateAnswer{S: s.String()}}}); err != nil {
+		return "", streamError(ctx, "failed to send the answer", stream, err)
+	}
+	resp, err = stream.Recv()
+	if err != nil {
+		return "", rpcError(ctx, "failed to auth", err)
+	}
+	stream.CloseSend()
+
+	sessionId := resp.GetSession().GetSessionId()
+	if sessionId == "" {
+		return "", fmt.Errorf("server did not send a session")
+	}
+	log.Printf("Success, this is our session ID: '%s'", sessionId)
+	return sessionId, nil
+}
+
+// Send only reports io.EOF when the server has ended the stream, the reason comes with the next Recv
+func streamError(ctx context.Context, msg string, stream pb.Auth_AuthenticateClient, err error) error {
+	if err == io.EOF {
+		_, err = stream.Recv()
+	}
+	return rpcError(ctx, msg, err)
+}
+
+// This returns (g^e mod p, h^e mod p)
+func commitToSecret(arith zkpautils.Arithmetic, params *zkpautils.Params, e *big.Int) (*big.Int, *big.Int) {
+	return arith.Exp(params.G, e), arith.Exp(params.H, e)
+}
+
+// The server refuses requests with the codes below, anything else means that we never got an answer
+// An expired challenge is DeadlineExceeded, as is running out of -timeout, which ctx tells apart
+func rpcError(ctx context.Context, msg string, err error) error {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		if ctx.Err() != nil {
+			break
+		}
+		fallthrough
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.Unauthenticated, codes.PermissionDenied,
+		codes.NotFound, codes.AlreadyExists, codes.Aborted:
+		return refusedError{fmt.Errorf("%s: %v", msg, status.Convert(err).Message())}
+	}
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return usageError{err.Error()}
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Sprintf("unexpected arguments: %v", fs.Args())}
+	}
+	return nil
+}
+
+// This returns x from the command line, or derives a new one from the passphrase read returns
+// the KDF parameters are nil when x was given to us
+func newSecret(secret string, algorithm string, q *big.Int, read func() (string, error)) (*big.Int, *pb.KdfParameters, error) {
+	if secret == "" {
+		return newSecretFromPassphrase(algorithm, q, read)
+	}
+
+	x, ok := new(big.Int).SetString(secret, 10)
+	if !ok {
+		return nil, nil, usageError{"-x must be a number"}
+	}
+	return x, nil, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mischat/zkp_auth/keystore"
+	pb "github.com/mischat/zkp_auth/pb"
+)
+
+func defaultKeystorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "zkp_auth_keystore.json"
+	}
+	return filepath.Join(home, ".zkp_auth", "keystore.json")
+}
+
+// The keystore remembers secrets per server, user and parameter set
+func (e *env) identity(user string) keystore.Identity {
+	return keystore.Identity{Server: *addrFlag, User: user, Params: e.params.ShortFingerprint()}
+}
+
+func openKeystore() (*keystore.Keystore, error) {
+	return keystore.Open(*keystoreFlag)
+}
+
+func unlockKeystore(ks *keystore.Keystore) error {
+	passphrase, err := readKeystorePassphrase()
+	if err != nil {
+		return err
+	}
+	if err := ks.Unlock(passphrase); err != nil {
+		if errors.Is(err, keystore.ErrWrongPassphrase) {
+			return refusedError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+func runKeystore(env *env, args []string) error {
+	if len(args) == 0 {
+		return usageError{"keystore needs a subcommand"}
+	}
+
+	switch args[0] {
+	case "import":
+		return runKeystoreImport(env, args[1:])
+	case "export":
+		return runKeystoreExport(env, args[1:])
+	case "list":
+		return runKeystoreList(env, args[1:])
+	case "delete":
+		return runKeystoreDelete(env, args[1:])
+	default:
+		return usageError{fmt.Sprintf("unknown keystore subcommand: '%s'", args[0])}
+	}
+}
+
+// This stores x in the keystore, either as given or derived from the account passphrase
+func runKeystoreImport(env *env, args []string) error {
+	fs := newFlagSet("keystore import")
+	uFlag := fs.String("u", "", "the client id")
+	xFlag := fs.String("x", "", "the client secret, when it is not set x is derived from the account passphrase")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *uFlag == "" {
+		return usageError{"-u is required"}
+	}
+	id := env.identity(*uFlag)
+
+	var x *big.Int
+	if *xFlag != "" {
+		var ok bool
+		x, ok = new(big.Int).SetString(*xFlag, 10)
+		if !ok {
+			return usageError{"-x must be a number"}
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+		defer cancel()
+
+		resp, err := pb.NewAuthClient(env.conn).GetKdfParameters(ctx, &pb.KdfParametersRequest{User: *uFlag})
+		if err != nil {
+			return rpcError(ctx, "could not fetch kdf parameters", err)
+		}
+
+		x, err = secretFromPassphrase(resp.GetKdf(), env.params.Q)
+		if err != nil {
+			return err
+		}
+	}
+
+	ks, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	if err := unlockKeystore(ks); err != nil {
+		return err
+	}
+	if err := ks.Put(id, x); err != nil {
+		return err
+	}
+	if err := ks.Save(); err != nil {
+		return err
+	}
+
+	return env.out.print(fmt.Sprintf("Imported %v", id), id)
+}
+
+func runKeystoreExport(env *env, args []string) error {
+	fs := newFlagSet("keystore export")
+	uFlag := fs.String("u", "", "the client id")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *uFlag == "" {
+		return usageError{"-u is required"}
+	}
+	id := env.identity(*uFlag)
+
+	ks, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	if !ks.Has(id) {
+		return refusedError{fmt.Errorf("%v: %v", keystore.ErrNotFound, id)}
+	}
+	if err := unlockKeystore(ks); err != nil {
+		return err
+	}
+
+	x, err := ks.Get(id)
+	if err != nil {
+		return err
+	}
+
+	return env.out.print(x.String(), struct {
+		keystore.Identity
+		X string `json:"x"`
+	}{id, x.String()})
+}
+
+func runKeystoreList(env *env, args []string) error {
+	if err := parseFlags(newFlagSet("keystore list"), args); err != nil {
+		return err
+	}
+
+	ks, err := openKeystore()
+	if err != nil {
+		return err
+	}
+
+	ids := ks.List()
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, id.String())
+	}
+
+	return env.out.print(strings.Join(lines, "\n"), struct {
+		Identities []keystore.Identity `json:"identities"`
+	}{ids})
+}
+
+func runKeystoreDelete(env *env, args []string) error {
+	fs := newFlagSet("keystore delete")
+	uFlag := fs.String("u", "", "the client id")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *uFlag == "" {
+		return usageError{"-u is required"}
+	}
+	id := env.identity(*uFlag)
+
+	ks, err := openKeystore()
+	if err != nil {
+		return err
+	}
+	if !ks.Delete(id) {
+		return refusedError{fmt.Errorf("%v: %v", keystore.ErrNotFound, id)}
+	}
+	if err := ks.Save(); err != nil {
+		return err
+	}
+
+	return env.out.print(fmt.Sprintf("Deleted %v", id), id)
+}
+
+// This returns x from the keystore, it is nil when the keystore doesn't know the user
+func secretFromKeystore(id keystore.Identity) (*big.Int, error) {
+	ks, err := openKeystore()
+	if err != nil {
+		return nil, err
+	}
+	if !ks.Has(id) {
+		return nil, nil
+	}
+	if err := unlockKeystore(ks); err != nil {
+		return nil, err
+	}
+	return ks.Get(id)
+}
+
+// After a rotation the old x is useless, so we replace it if the keystore has it
+func updateKeystore(id keystore.Identity, x *big.Int) (bool, error) {
+	ks, err := openKeystore()
+	if err != nil {
+		return false, err
+	}
+	if !ks.Has(id) {
+		return false, nil
+	}
+	if err := unlockKeystore(ks); err != nil {
+		return false, err
+	}
+	if err := ks.Put(id, x); err != nil {
+		return false, err
+	}
+	return true, ks.Save()
+}
@@ -1,19 +1,29 @@
-// Package main implements a client for Greeter service.
+// Package main implements a command line client for the Auth service.
 package main
 
 import (
-	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
-	"math/big"
+	"os"
+	"strings"
 	"time"
 
-	pb "github.com/mischat/zkp_auth/pb"
 	zkpautils "github.com/mischat/zkp_auth/utils"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// Exit codes, so that scripts can tell the failures apart
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitRefused = 3
+)
+
 var (
 	addrFlag = flag.String("addr", "localhost:50051", "the address to connect to")
 
@@ -24,96 +34,215 @@ var (
 	qFlag = flag.String("q", "11", "for prime order calculation")
 	gFlag = flag.String("g", "12", "first in group")
 	hFlag = flag.String("h", "13", "second in group")
-
-	// This is the client id and secret
-	uFlag = flag.String("u", "alice@example.com", "the client id")
-	xFlag = flag.String("x", "6", "the client secret")
+	// Or all four of them from a file made by scripts/gennumbers
+	paramsFlag = flag.String("params", "", "a parameter file to read p, q, g and h from, instead of the flags")
+	// Or one of the built-in groups
+	groupFlag = flag.String("group", "", "a standard group to use instead of the flags, one of "+strings.Join(zkpautils.StandardGroupNames(), ", "))
+	// The toy policy lets the small default group through, use default or strict for anything real
+	policyFlag = flag.String("policy", "toy", "how big the group must be, one of toy, default or strict")
+	// Pinning the fingerprint stops a typo in the flags, or the wrong file, from going unnoticed
+	fingerprintFlag = flag.String("fingerprint", "", "the expected fingerprint of the public variables, in full or short")
+	// Constant-time arithmetic keeps the timing of x and k to ourselves, at some cost in speed
+	arithmeticFlag = flag.String("arithmetic", "", "the arithmetic used on secrets, 'big' or 'constant-time', the parameter set's choice when not set")
+
+	outputFlag   = flag.String("output", "text", "the output format, either 'text' or 'json'")
+	sessionFlag  = flag.String("session", defaultSessionPath(), "the file used to remember sessions between runs")
+	noncesFlag   = flag.String("nonces", defaultNoncePath(), "the file used to remember a hash of every k used, so that none is used twice")
+	keystoreFlag = flag.String("keystore", defaultKeystorePath(), "the encrypted file client secrets are kept in")
+	timeoutFlag  = flag.Duration("timeout", 5*time.Second, "how long to wait for the server")
+	verboseFlag  = flag.Bool("v", false, "log the numbers flying around to stderr")
+	nonceFlag    = flag.String("nonce", "hedged", "how k is picked, 'hedged' derives it from x, the login and fresh randomness, 'random' only uses randomness")
+	// The streaming call keeps the challenge on the stream, rather than under an auth ID on the server
+	streamFlag = flag.Bool("stream", false, "log in with the streaming Authenticate call rather than two calls")
 )
 
-func main() {
-	flag.Parse()
+// A command is one of the subcommands the client supports
+type command struct {
+	name  string
+	usage string
+	run   func(env *env, args []string) error
+}
 
-	// creating bigInts from the flags
-	p := new(big.Int)
-	p.SetString(*pFlag, 10)
+var commands = []command{
+	{"register", "register -u <user> [-x <secret> | -kdf <kdf>]", runRegister},
+	{"login", "login -u <user> [-x <secret>]", runLogin},
+	{"logout", "logout", runLogout},
+	{"whoami", "whoami", runWhoAmI},
+	{"params", "params fetch", runParams},
+	{"rotate", "rotate [-old-x <secret>] [-x <new secret> | -kdf <kdf>]", runRotate},
+	{"keystore", "keystore import|export|list|delete [-u <user>] [-x <secret>]", runKeystore},
+}
 
-	q := new(big.Int)
-	q.SetString(*qFlag, 10)
+// usageError is returned when the command line doesn't make sense
+type usageError struct {
+	msg string
+}
 
-	g := new(big.Int)
-	g.SetString(*gFlag, 10)
+func (e usageError) Error() string {
+	return e.msg
+}
 
-	h := new(big.Int)
-	h.SetString(*hFlag, 10)
+// refusedError is returned when the server turns a request down, or we are not logged in
+type refusedError struct {
+	err error
+}
 
-	x := new(big.Int)
-	x.SetString(*xFlag, 10)
+func (e refusedError) Error() string {
+	return e.err.Error()
+}
 
-	log.Printf("p: %v q: %v g: %v h: %v\n", p, q, g, h)
+func (e refusedError) Unwrap() error {
+	return e.err
+}
 
-	// This makes sure that we validate the public variables passed in
-	// This ensures from the clients POV that what they are using is correct
-	// That p,q,g,h make sense
-	_, err := zkpautils.ValidatePublicVariables(p, q, g, h)
-	if err != nil {
-		log.Fatalf("could not validate public variables: %v", err)
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %s\n", cmd.usage)
 	}
-	// The config is now validated and in good shape
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
-	// Set up a connection to the server.
-	conn, err := grpc.Dial(*addrFlag, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	os.Exit(run(flag.Args()))
+}
+
+func run(args []string) int {
+	out, err := newPrinter(*outputFlag, os.Stdout)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if *nonceFlag != "hedged" && *nonceFlag != "random" {
+		out.error(usageError{fmt.Sprintf("unknown nonce mode: '%s'", *nonceFlag)})
+		return exitUsage
+	}
+
+	if !*verboseFlag {
+		log.SetOutput(io.Discard)
 	}
-	defer conn.Close()
-	c := pb.NewAuthClient(conn)
 
-	// Now to calculate y1
-	y1 := new(big.Int).Exp(g, x, p)
-	// Now to calculate y2
-	y2 := new(big.Int).Exp(h, x, p)
+	if len(args) == 0 {
+		flag.Usage()
+		return exitUsage
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == args[0] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		out.error(usageError{fmt.Sprintf("unknown command: '%s'", args[0])})
+		return exitUsage
+	}
 
-	_, err = c.Register(ctx, &pb.RegisterRequest{User: *uFlag, Y1: y1.String(), Y2: y2.String()})
+	env, err := newEnv(out)
 	if err != nil {
-		log.Fatalf("could not register: %v", err)
-	}
-	log.Printf("Registered user %s with Y1=%d and Y2=%d", *uFlag, y1, y2)
-
-	// now we need to generate a random k
-	// TODO: Need to ascertain whether I can use a incrementing, contiguous nonce here
-	// Initially i wanted to use a nonce here, but
-	// I am not sure about the maths behind it, I wasn't sure if it would
-	// compromise the security of the system, so I decided to use a random number
-	// Finally, we should be storing the data
-	// The way we store it would be decided based on whether we use a nonce or not
-	// will add a note about this in the README
-	k := zkpautils.RandomBigInt()
-	log.Printf("Generated random k: %d", k)
-
-	// Now to calculate (r1, r2) = g^k, h^k
-	r1 := new(big.Int).Exp(g, k, p)
-	r2 := new(big.Int).Exp(h, k, p)
-
-	resp, err := c.CreateAuthenticationChallenge(ctx, &pb.AuthenticationChallengeRequest{User: *uFlag, R1: r1.String(), R2: r2.String()})
+		out.error(err)
+		return exitUsage
+	}
+	defer env.close()
+
+	err = cmd.run(env, args[1:])
+
+	var uerr usageError
+	var rerr refusedError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &uerr):
+		out.error(err)
+		fmt.Fprintf(os.Stderr, "usage: %s\n", cmd.usage)
+		return exitUsage
+	case errors.As(err, &rerr):
+		out.error(err)
+		return exitRefused
+	default:
+		out.error(err)
+		return exitFailure
+	}
+}
+
+// env holds everything the commands share
+type env struct {
+	out      *printer
+	params   *zkpautils.Params
+	arith    zkpautils.Arithmetic
+	conn     *grpc.ClientConn
+	sessions *sessionStore
+	nonces   *nonceStore
+}
+
+func newEnv(out *printer) (*env, error) {
+	// creating bigInts from the flags, or reading them from the parameter file or the standard groups
+	var params *zkpautils.Params
+	var err error
+	if *paramsFlag != "" {
+		params, err = zkpautils.ReadParamsFile(*paramsFlag)
+	} else if *groupFlag != "" {
+		params, err = zkpautils.StandardGroup(*groupFlag)
+	} else {
+		params, err = zkpautils.ParseParams(*pFlag, *qFlag, *gFlag, *hFlag)
+	}
 	if err != nil {
-		log.Fatalf("failed to create auth challenge: %v", err)
+		return nil, err
 	}
 
-	authId := resp.AuthId
-	chal := new(big.Int)
-	chal.SetString(resp.C, 10)
-	log.Printf("authId: %s c: %d", authId, chal)
+	log.Printf("p: %v q: %v g: %v h: %v\n", params.P, params.Q, params.G, params.H)
+	log.Printf("parameter fingerprint: %s", params.Fingerprint())
+
+	if *fingerprintFlag != "" && !params.MatchesFingerprint(*fingerprintFlag) {
+		return nil, fmt.Errorf("the public variables have fingerprint %s, but -fingerprint pins %s", params.Fingerprint(), *fingerprintFlag)
+	}
+
+	// This makes sure that we validate the public variables passed in
+	// This ensures from the clients POV that what they are using is correct
+	// That p,q,g,h make sense
+	policy, err := zkpautils.PolicyByName(*policyFlag)
+	if err != nil {
+		return nil, usageError{err.Error()}
+	}
+	if err := zkpautils.ValidateParams(params, policy).Err(); err != nil {
+		return nil, fmt.Errorf("could not validate public variables: %v", err)
+	}
+	if err := zkpautils.ValidateParams(params, zkpautils.PolicyDefault).Err(); err != nil {
+		log.Printf("WARNING: the public variables are not safe to use outside of testing: %v", err)
+	}
+	// The config is now validated and in good shape
 
-	// Not to calculate s = (k - c .x) mod q
-	s := zkpautils.CalculateS(k, chal, x, q)
+	arithmetic := params.Arithmetic
+	if *arithmeticFlag != "" {
+		arithmetic = *arithmeticFlag
+	}
+	arith, err := zkpautils.NewArithmeticByName(params, arithmetic)
+	if err != nil {
+		return nil, usageError{err.Error()}
+	}
 
-	verResp, err := c.VerifyAuthentication(ctx, &pb.AuthenticationAnswerRequest{AuthId: authId, S: s.String()})
+	// Set up a connection to the server.
+	// Dial doesn't block, so a server that isn't up is only noticed by the first call
+	conn, err := grpc.Dial(*addrFlag, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
-		log.Fatalf("Failed to auth: %v", err)
+		return nil, fmt.Errorf("did not connect: %v", err)
 	}
 
-	log.Printf("Success, this is our session ID: '%s'", verResp.SessionId)
+	return &env{
+		out:      out,
+		params:   params,
+		arith:    arith,
+		conn:     conn,
+		sessions: &sessionStore{path: *sessionFlag},
+		nonces:   &nonceStore{path: *noncesFlag},
+	}, nil
+}
+
+func (e *env) close() {
+	e.conn.Close()
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	zkpautils "github.com/mischat/zkp_auth/utils"
+)
+
+// The most ks we remember, a broken RNG tends to repeat itself soon rather than after years of logins
+const maxUsedNonces = 10000
+
+// nonceStore keeps the hashes of a zkpautils.NonceTracker in a JSON file, so that a k is never used
+// twice even across runs, each of which only logs in once
+type nonceStore struct {
+	path string
+}
+
+func defaultNoncePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "zkp_auth_nonces.json"
+	}
+	return filepath.Join(home, ".zkp_auth", "nonces.json")
+}
+
+func (ns *nonceStore) read() ([]string, error) {
+	data, err := os.ReadFile(ns.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var used []string
+	if err := json.Unmarshal(data, &used); err != nil {
+		return nil, fmt.Errorf("could not read used nonces from '%s': %v", ns.path, err)
+	}
+	return used, nil
+}
+
+// use records k, it returns zkpautils.ErrNonceReused if k has been used before
+// k is written down before it is used, so that a run that dies half way still counts
+func (ns *nonceStore) use(k *big.Int) error {
+	used, err := ns.read()
+	if err != nil {
+		return err
+	}
+	tracker, err := zkpautils.LoadNonceTracker(used)
+	if err != nil {
+		return fmt.Errorf("could not read used nonces from '%s': %v", ns.path, err)
+	}
+	if err := tracker.Use(k); err != nil {
+		return err
+	}
+
+	used = tracker.Export()
+	if len(used) > maxUsedNonces {
+		used = used[len(used)-maxUsedNonces:]
+	}
+	data, err := json.Marshal(used)
+	if err != nil {
+		return err
+	}
+
+	// Only a hash of k is kept, but there is no reason for anyone else to read it
+	if err := os.MkdirAll(filepath.Dir(ns.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(ns.path, data, 0600)
+}
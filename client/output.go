@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// printer writes command results either for people or for scripts
+type printer struct {
+	json bool
+	w    io.Writer
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	switch format {
+	case "text":
+		return &printer{w: w}, nil
+	case "json":
+		return &printer{json: true, w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: '%s'", format)
+	}
+}
+
+// print writes text for people, or v encoded as a JSON object for scripts
+func (pr *printer) print(text string, v interface{}) error {
+	if pr.json {
+		return json.NewEncoder(pr.w).Encode(v)
+	}
+	_, err := fmt.Fprintln(pr.w, text)
+	return err
+}
+
+// error reports a failure, in JSON it goes to stdout so that it can be parsed
+func (pr *printer) error(err error) {
+	if pr.json {
+		json.NewEncoder(pr.w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	zkpautils "github.com/mischat/zkp_auth/utils"
+	"golang.org/x/term"
+)
+
+// The passphrases are read from these environment variables when they are set,
+// otherwise we ask for them on stdin
+const (
+	passphraseEnv         = "ZKP_AUTH_PASSPHRASE"
+	keystorePassphraseEnv = "ZKP_AUTH_KEYSTORE_PASSPHRASE"
+	// rotate needs the old passphrase and the new one
+	newPassphraseEnv = "ZKP_AUTH_NEW_PASSPHRASE"
+)
+
+// We may need more than one passphrase from stdin, so they all share a reader
+var stdin = bufio.NewReader(os.Stdin)
+
+func readPassphrase() (string, error) {
+	return promptPassphrase("Passphrase", passphraseEnv)
+}
+
+func readNewPassphrase() (string, error) {
+	return promptPassphrase("New passphrase", newPassphraseEnv)
+}
+
+func readKeystorePassphrase() (string, error) {
+	return promptPassphrase("Keystore passphrase", keystorePassphraseEnv)
+}
+
+func promptPassphrase(prompt string, env string) (string, error) {
+	if passphrase, ok := os.LookupEnv(env); ok {
+		return passphrase, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	line, err := readLine()
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %v", err)
+	}
+
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", usageError{"the passphrase must not be empty"}
+	}
+	return passphrase, nil
+}
+
+// On a terminal the passphrase is read without echoing it, from a pipe it is the next line
+func readLine() (string, error) {
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		line, err := term.ReadPassword(fd)
+		// the newline the user typed wasn't echoed either
+		fmt.Fprintln(os.Stderr)
+		return string(line), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return line, nil
+}
+
+// This derives x from the passphrase read returns with fresh KDF parameters
+// and returns the parameters so that they can be stored on the server
+func newSecretFromPassphrase(algorithm string, q *big.Int, read func() (string, error)) (*big.Int, *pb.KdfParameters, error) {
+	kdf, err := zkpautils.NewKDFParams(algorithm)
+	if err != nil {
+		return nil, nil, usageError{err.Error()}
+	}
+
+	passphrase, err := read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	x, err := zkpautils.DeriveSecret(passphrase, kdf, q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return x, &pb.KdfParameters{
+		Algorithm: kdf.Algorithm,
+		Salt:      kdf.Salt,
+		Time:      kdf.Time,
+		Memory:    kdf.Memory,
+		Threads:   uint32(kdf.Threads),
+	}, nil
+}
+
+// This derives x from a passphrase with the KDF parameters the server stored for us
+func secretFromPassphrase(kdf *pb.KdfParameters, q *big.Int) (*big.Int, error) {
+	if kdf.GetThreads() > 255 {
+		return nil, fmt.Errorf("kdf threads:'%d' is over the maximum of 255", kdf.GetThreads())
+	}
+
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	return zkpautils.DeriveSecret(passphrase, &zkpautils.KDFParams{
+		Algorithm: kdf.GetAlgorithm(),
+		Salt:      kdf.GetSalt(),
+		Time:      kdf.GetTime(),
+		Memory:    kdf.GetMemory(),
+		Threads:   uint8(kdf.GetThreads()),
+	}, q)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// session is what we remember about a login between runs
+type session struct {
+	User      string `json:"user"`
+	SessionId string `json:"session_id"`
+}
+
+// sessionStore keeps one session per server address in a JSON file
+type sessionStore struct {
+	path string
+}
+
+func defaultSessionPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "zkp_auth_sessions.json"
+	}
+	return filepath.Join(home, ".zkp_auth", "sessions.json")
+}
+
+func (st *sessionStore) read() (map[string]session, error) {
+	sessions := make(map[string]session)
+
+	data, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("could not read sessions from '%s': %v", st.path, err)
+	}
+	return sessions, nil
+}
+
+func (st *sessionStore) write(sessions map[string]session) error {
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Session IDs are bearer tokens, so only the user gets to read them
+	if err := os.MkdirAll(filepath.Dir(st.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(st.path, data, 0600)
+}
+
+// load returns the session for a server, not being logged in is an auth failure
+func (st *sessionStore) load(addr string) (session, error) {
+	sessions, err := st.read()
+	if err != nil {
+		return session{}, err
+	}
+
+	sess, exists := sessions[addr]
+	if !exists {
+		return session{}, refusedError{fmt.Errorf("not logged in to '%s'", addr)}
+	}
+	return sess, nil
+}
+
+func (st *sessionStore) save(addr string, sess session) error {
+	sessions, err := st.read()
+	if err != nil {
+		return err
+	}
+
+	sessions[addr] = sess
+	return st.write(sessions)
+}
+
+func (st *sessionStore) remove(addr string) error {
+	sessions, err := st.read()
+	if err != nil {
+		return err
+	}
+
+	delete(sessions, addr)
+	return st.write(sessions)
+}
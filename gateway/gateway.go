@@ -0,0 +1,241 @@
+// Package gateway serves the Auth service as HTTP with JSON bodies, for clients that can't speak gRPC.
+// Every route calls the same pb.AuthServer method the gRPC call does, and openapi.json describes them all.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The most a request body can be, the numbers in it are at most a few thousand digits
+const maxBodySize = 64 << 10
+
+// Number is a big integer, in decimal or in hex with a 0x prefix
+// The gateway always answers in decimal, as the gRPC service does
+type Number string
+
+// Gateway is an http.Handler for the routes of the Auth service
+type Gateway struct {
+	srv pb.AuthServer
+	// The most hex digits a number can have, one more than p has
+	maxHexDigits int
+	routes       map[string]route
+	openAPI      []byte
+	// The WebSocket login, nil when srv can't hand out challenges without storing them
+	loginSocket http.Handler
+}
+
+// A route maps an HTTP request onto a call of the Auth service
+type route struct {
+	method  string
+	path    string
+	summary string
+	// A pointer to the type the body is decoded into, nil for GET
+	request interface{}
+	// A pointer to the type of the answer, for the OpenAPI document
+	response interface{}
+	// The codes the service can refuse the request with, for the OpenAPI document
+	refusals []codes.Code
+	call     func(ctx context.Context, in interface{}) (interface{}, error)
+}
+
+// New returns a Gateway in front of srv
+func New(srv pb.AuthServer) (*Gateway, error) {
+	resp, err := srv.GetPublicParameters(context.Background(), &pb.PublicParametersRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch public variables: %v", err)
+	}
+	p, ok := new(big.Int).SetString(resp.GetP(), 10)
+	if !ok {
+		return nil, fmt.Errorf("p is not a number: '%s'", resp.GetP())
+	}
+
+	gw := &Gateway{
+		srv:          srv,
+		maxHexDigits: (p.BitLen()+3)/4 + 1,
+		routes:       make(map[string]route),
+	}
+	for _, r := range gw.routeTable() {
+		gw.routes[r.path] = r
+	}
+	if ch, ok := srv.(Challenger); ok {
+		gw.loginSocket = gw.newLoginSocket(ch)
+	}
+	if gw.openAPI, err = OpenAPI(); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+// ServeHTTP answers every request with JSON, errors too
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == openAPIPath {
+		if r.Method != http.MethodGet {
+			writeError(w, errMethodNotAllowed(http.MethodGet))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(gw.openAPI)
+		return
+	}
+
+	if r.URL.Path == loginSocketPath && gw.loginSocket != nil {
+		gw.loginSocket.ServeHTTP(w, r)
+		return
+	}
+
+	rt, ok := gw.routes[r.URL.Path]
+	if !ok {
+		writeError(w, &httpError{status: http.StatusNotFound, code: "not_found", msg: fmt.Sprintf("there is no route '%s'", r.URL.Path)})
+		return
+	}
+	if r.Method != rt.method {
+		writeError(w, errMethodNotAllowed(rt.method))
+		return
+	}
+
+	var in interface{}
+	if rt.request != nil {
+		in = reflect.New(reflect.TypeOf(rt.request).Elem()).Interface()
+		if err := decodeBody(w, r, in); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	out, err := rt.call(r.Context(), in)
+	if err != nil {
+		he := serviceError(err)
+		log.Printf("%s %s refused: %v", r.Method, r.URL.Path, he.msg)
+		writeError(w, he)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, in interface{}) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		return &httpError{status: http.StatusUnsupportedMediaType, code: "unsupported_media_type", msg: fmt.Sprintf("the body must be application/json, not '%s'", ct)}
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(in); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &httpError{status: http.StatusRequestEntityTooLarge, code: "too_large", msg: fmt.Sprintf("the body is over %d bytes", maxBodySize)}
+		}
+		return errBadRequest("the body is not valid JSON: %v", err)
+	}
+	if dec.More() {
+		return errBadRequest("the body has more than one JSON value")
+	}
+	return nil
+}
+
+// This turns a Number into the decimal the service expects
+// Hex is converted here, decimal is passed on as it is for the service to check
+func (gw *Gateway) decimal(name string, n Number) (string, error) {
+	s := string(n)
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return s, nil
+	}
+	digits := s[2:]
+	if len(digits) > gw.maxHexDigits {
+		return "", errBadRequest("%s is too long: %d hex digits, the most is %d", name, len(digits), gw.maxHexDigits)
+	}
+	v, ok := new(big.Int).SetString(digits, 16)
+	if !ok {
+		return "", errBadRequest("%s is not a hex number: '%s'", name, s)
+	}
+	return v.String(), nil
+}
+
+// httpError is an error with the status and code it is answered with
+type httpError struct {
+	status int
+	code   string
+	msg    string
+	// The method to use instead, for method_not_allowed
+	allow string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func errBadRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, code: "bad_request", msg: fmt.Sprintf(format, args...)}
+}
+
+func errMethodNotAllowed(allowed string) *httpError {
+	return &httpError{status: http.StatusMethodNotAllowed, code: "method_not_allowed", msg: fmt.Sprintf("the method must be %s", allowed), allow: allowed}
+}
+
+// The status and code each gRPC code of the service is answered with
+// Anything else, a plain error included, is answered as internal
+var serviceStatuses = map[codes.Code]struct {
+	status int
+	code   string
+	// What it means, for the OpenAPI document
+	doc string
+}{
+	codes.InvalidArgument:    {http.StatusBadRequest, "bad_request", "A number, or the KDF parameters, are not valid"},
+	codes.FailedPrecondition: {http.StatusBadRequest, "bad_request", "The challenge has been answered already"},
+	codes.Unauthenticated:    {http.StatusUnauthorized, "unauthorized", "The proof or the session is not valid"},
+	codes.PermissionDenied:   {http.StatusForbidden, "forbidden", "The challenge is for another user"},
+	codes.NotFound:           {http.StatusNotFound, "not_found", "The user or the challenge does not exist"},
+	codes.AlreadyExists:      {http.StatusConflict, "conflict", "The user already exists"},
+	codes.Aborted:            {http.StatusConflict, "conflict", "The secret was rotated by another request first"},
+	codes.DeadlineExceeded:   {http.StatusGone, "expired", "The challenge has expired"},
+	codes.Unavailable:        {http.StatusServiceUnavailable, "unavailable", "The server is shutting down"},
+}
+
+// This turns an error of a route into what it is answered with, from its gRPC code
+// Errors of the gateway itself already are httpErrors
+func serviceError(err error) *httpError {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he
+	}
+	st := status.Convert(err)
+	if s, ok := serviceStatuses[st.Code()]; ok {
+		return &httpError{status: s.status, code: s.code, msg: st.Message()}
+	}
+	return &httpError{status: http.StatusInternalServerError, code: "internal", msg: st.Message()}
+}
+
+// errorBody is what every error is answered with
+type errorBody struct {
+	Error   string `json:"error" doc:"what went wrong: bad_request, unauthorized, forbidden, not_found, method_not_allowed, conflict, expired, too_large, unsupported_media_type, internal or unavailable"`
+	Message string `json:"message" doc:"why, for people rather than programs"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var he *httpError
+	if !errors.As(err, &he) {
+		he = &httpError{status: http.StatusInternalServerError, code: "internal", msg: err.Error()}
+	}
+	if he.allow != "" {
+		w.Header().Set("Allow", he.allow)
+	}
+	writeJSON(w, he.status, errorBody{Error: he.code, Message: he.msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write the answer: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The OpenAPI document is built from the route table and the types of the bodies,
+// so it can't drift from what the gateway does. scripts/genopenapi writes it to gateway/openapi.json.
+
+// The pattern of a Number
+const numberPattern = "^(0[xX][0-9a-fA-F]+|[0-9]+)$"
+
+var numberType = reflect.TypeOf(Number(""))
+
+// OpenAPI returns the OpenAPI 3 document of the gateway
+func OpenAPI() ([]byte, error) {
+	errorRef := map[string]interface{}{"$ref": "#/components/schemas/Error"}
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorRef}},
+		}
+	}
+
+	paths := make(map[string]interface{})
+	for _, rt := range (&Gateway{}).routeTable() {
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema(reflect.TypeOf(rt.response))}},
+			},
+			"500": errorResponse("The service failed"),
+		}
+		op := map[string]interface{}{
+			"summary":     rt.summary,
+			"operationId": strings.TrimPrefix(rt.path, "/v1/"),
+			"responses":   responses,
+		}
+
+		// codes that share a status share its response
+		docs := make(map[int][]string)
+		var statuses []int
+		if rt.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schema(reflect.TypeOf(rt.request))}},
+			}
+			docs[http.StatusBadRequest] = []string{"The body is not valid JSON"}
+			statuses = append(statuses, http.StatusBadRequest)
+		}
+		for _, c := range rt.refusals {
+			s := serviceStatuses[c]
+			if _, ok := docs[s.status]; !ok {
+				statuses = append(statuses, s.status)
+			}
+			docs[s.status] = append(docs[s.status], s.doc)
+		}
+		for _, status := range statuses {
+			responses[strconv.Itoa(status)] = errorResponse(strings.Join(docs[status], ". "))
+		}
+		paths[rt.path] = map[string]interface{}{strings.ToLower(rt.method): op}
+	}
+	paths[openAPIPath] = map[string]interface{}{"get": map[string]interface{}{
+		"summary":     "Get this document",
+		"operationId": "openapi",
+		"responses":   map[string]interface{}{"200": map[string]interface{}{"description": "The OpenAPI document"}},
+	}}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "zkp_auth",
+			"version":     "1",
+			"description": "The Chaum-Pedersen login of zkp_auth over HTTP. Big integers are strings, in decimal or in hex with a 0x prefix; answers are always in decimal.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": map[string]interface{}{"Error": schema(reflect.TypeOf(errorBody{}))}},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// This returns the JSON schema of a body type, the json tags name the fields and the doc tags describe them
+func schema(t reflect.Type) map[string]interface{} {
+	if t == numberType {
+		return map[string]interface{}{"type": "string", "pattern": numberPattern}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0, "maximum": uint64(1<<32 - 1)}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			s := schema(f.Type)
+			if doc := f.Tag.Get("doc"); doc != "" {
+				s["description"] = doc
+			}
+			properties[name] = s
+			if opts != "omitempty" {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	panic(fmt.Sprintf("no schema for %v", t))
+}
@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	zkpautils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc/codes"
+)
+
+// The bodies of the routes, named after the fields of the gRPC messages
+
+type kdfParameters struct {
+	Algorithm string `json:"algorithm" doc:"the KDF x was derived with, argon2id"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory" doc:"in KiB"`
+	Threads   uint32 `json:"threads"`
+}
+
+func (k *kdfParameters) proto() *pb.KdfParameters {
+	if k == nil {
+		return nil
+	}
+	return &pb.KdfParameters{Algorithm: k.Algorithm, Salt: k.Salt, Time: k.Time, Memory: k.Memory, Threads: k.Threads}
+}
+
+type registerRequest struct {
+	User string         `json:"user"`
+	Y1   Number         `json:"y1" doc:"g^x mod p"`
+	Y2   Number         `json:"y2" doc:"h^x mod p"`
+	Kdf  *kdfParameters `json:"kdf,omitempty" doc:"only set when x was derived from a passphrase"`
+}
+
+type emptyResponse struct{}
+
+type challengeRequest struct {
+	User string `json:"user"`
+	R1   Number `json:"r1" doc:"g^k mod p, for a fresh k"`
+	R2   Number `json:"r2" doc:"h^k mod p"`
+}
+
+type challengeResponse struct {
+	AuthId string `json:"auth_id" doc:"what the answer to the challenge is sent with"`
+	C      Number `json:"c" doc:"the challenge"`
+}
+
+type verifyRequest struct {
+	AuthId string `json:"auth_id"`
+	S      Number `json:"s" doc:"(k - c.x) mod q"`
+}
+
+type verifyResponse struct {
+	SessionId string `json:"session_id"`
+}
+
+type paramsResponse struct {
+	P           Number `json:"p"`
+	Q           Number `json:"q"`
+	G           Number `json:"g"`
+	H           Number `json:"h"`
+	Fingerprint string `json:"fingerprint" doc:"the SHA-256 fingerprint of p, q, g and h, as the client and server print it"`
+}
+
+type sessionRequest struct {
+	SessionId string `json:"session_id"`
+}
+
+type whoAmIResponse struct {
+	User      string `json:"user"`
+	CreatedAt int64  `json:"created_at" doc:"when the session was created, in seconds since the Unix epoch"`
+}
+
+type rotateRequest struct {
+	SessionId string         `json:"session_id"`
+	Y1        Number         `json:"y1" doc:"g^x mod p for the new x"`
+	Y2        Number         `json:"y2" doc:"h^x mod p for the new x"`
+	Kdf       *kdfParameters `json:"kdf,omitempty" doc:"only set when the new x was derived from a passphrase"`
+	AuthId    string         `json:"auth_id" doc:"a challenge from /v1/challenge for the logged in user"`
+	S         Number         `json:"s" doc:"the answer to the challenge with the old x"`
+}
+
+type kdfRequest struct {
+	User string `json:"user"`
+}
+
+type kdfResponse struct {
+	Kdf *kdfParameters `json:"kdf"`
+}
+
+// The path the OpenAPI document is served at
+const openAPIPath = "/v1/openapi.json"
+
+// The routes of the gateway, each is one call of the Auth service
+func (gw *Gateway) routeTable() []route {
+	return []route{
+		{http.MethodPost, "/v1/register", "Register a user with y1 and y2", &registerRequest{}, &emptyResponse{},
+			[]codes.Code{codes.InvalidArgument, codes.AlreadyExists}, gw.register},
+		{http.MethodPost, "/v1/challenge", "Commit to r1 and r2, and get a challenge", &challengeRequest{}, &challengeResponse{},
+			[]codes.Code{codes.InvalidArgument, codes.NotFound}, gw.challenge},
+		{http.MethodPost, "/v1/verify", "Answer a challenge, and get a session", &verifyRequest{}, &verifyResponse{},
+			[]codes.Code{codes.InvalidArgument, codes.Unauthenticated, codes.NotFound, codes.DeadlineExceeded, codes.Unavailable}, gw.verify},
+		{http.MethodGet, "/v1/params", "Get the public variables", nil, &paramsResponse{},
+			nil, gw.params},
+		{http.MethodPost, "/v1/whoami", "Get the user a session belongs to", &sessionRequest{}, &whoAmIResponse{},
+			[]codes.Code{codes.Unauthenticated}, gw.whoAmI},
+		{http.MethodPost, "/v1/logout", "End a session", &sessionRequest{}, &emptyResponse{},
+			nil, gw.logout},
+		{http.MethodPost, "/v1/rotate", "Replace the y1 and y2 of the logged in user, with a proof for the old ones", &rotateRequest{}, &emptyResponse{},
+			[]codes.Code{codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied, codes.NotFound, codes.Aborted, codes.DeadlineExceeded, codes.Unavailable}, gw.rotate},
+		{http.MethodPost, "/v1/kdf", "Get the KDF parameters a user registered with", &kdfRequest{}, &kdfResponse{},
+			nil, gw.kdf},
+	}
+}
+
+func (gw *Gateway) register(ctx context.Context, in interface{}) (interface{}, error) {
+	req := in.(*registerRequest)
+	y1, err := gw.decimal("y1", req.Y1)
+	if err != nil {
+		return nil, err
+	}
+	y2, err := gw.decimal("y2", req.Y2)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.srv.Register(ctx, &pb.RegisterRequest{User: req.User, Y1: y1, Y2: y2, Kdf: req.Kdf.proto()}); err != nil {
+		return nil, err
+	}
+	return &emptyResponse{}, nil
+}
+
+func (gw *Gateway) challenge(ctx context.Context, in interface{}) (interface{}, error) {
+	req := in.(*challengeRequest)
+	r1, err := gw.decimal("r1", req.R1)
+	if err != nil {
+		return nil, err
+	}
+	r2, err := gw.decimal("r2", req.R2)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := gw.srv.CreateAuthenticationChallenge(ctx, &pb.AuthenticationChallengeRequest{User: req.User, R1: r1, R2: r2})
+	if err != nil {
+		return nil, err
+	}
+	return &challengeResponse{AuthId: resp.GetAuthId(), C: Number(resp.GetC())}, nil
+}
+
+func (gw *Gateway) verify(ctx context.Context, in interface{}) (interface{}, error) {
+	req := in.(*verifyRequest)
+	s, err := gw.decimal("s", req.S)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := gw.srv.VerifyAuthentication(ctx, &pb.AuthenticationAnswerRequest{AuthId: req.AuthId, S: s})
+	if err != nil {
+		return nil, err
+	}
+	return &verifyResponse{SessionId: resp.GetSessionId()}, nil
+}
+
+func (gw *Gateway) params(ctx context.Context, in interface{}) (interface{}, error) {
+	resp, err := gw.srv.GetPublicParameters(ctx, &pb.PublicParametersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	params, err := zkpautils.ParseParams(resp.GetP(), resp.GetQ(), resp.GetG(), resp.GetH())
+	if err != nil {
+		return nil, err
+	}
+	return &paramsResponse{P: Number(resp.GetP()), Q: Number(resp.GetQ()), G: Number(resp.GetG()), H: Number(resp.GetH()), Fingerprint: params.Fingerprint()}, nil
+}
+
+func (gw *Gateway) whoAmI(ctx context.Context, in interface{}) (interface{}, error) {
+	resp, err := gw.srv.WhoAmI(ctx, &pb.WhoAmIRequest{SessionId: in.(*sessionRequest).SessionId})
+	if err != nil {
+		return nil, err
+	}
+	return &whoAmIResponse{User: resp.GetUser(), CreatedAt: resp.GetCreatedAt()}, nil
+}
+
+func (gw *Gateway) logout(ctx context.Context, in interface{}) (interface{}, error) {
+	if _, err := gw.srv.Logout(ctx, &pb.LogoutRequest{SessionId: in.(*sessionRequest).SessionId}); err != nil {
+		return nil, err
+	}
+	return &emptyResponse{}, nil
+}
+
+func (gw *Gateway) rotate(ctx context.Context, in interface{}) (interface{}, error) {
+	req := in.(*rotateRequest)
+	y1, err := gw.decimal("y1", req.Y1)
+	if err != nil {
+		return nil, err
+	}
+	y2, err := gw.decimal("y2", req.Y2)
+	if err != nil {
+		return nil, err
+	}
+	s, err := gw.decimal("s", req.S)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.srv.Rotate(ctx, &pb.RotateRequest{SessionId: req.SessionId, Y1: y1, Y2: y2, Kdf: req.Kdf.proto(), AuthId: req.AuthId, S: s}); err != nil {
+		return nil, err
+	}
+	return &emptyResponse{}, nil
+}
+
+func (gw *Gateway) kdf(ctx context.Context, in interface{}) (interface{}, error) {
+	resp, err := gw.srv.GetKdfParameters(ctx, &pb.KdfParametersRequest{User: in.(*kdfRequest).User})
+	if err != nil {
+		return nil, err
+	}
+	kdf := resp.GetKdf()
+	return &kdfResponse{Kdf: &kdfParameters{Algorithm: kdf.GetAlgorithm(), Salt: kdf.GetSalt(), Time: kdf.GetTime(), Memory: kdf.GetMemory(), Threads: kdf.GetThreads()}}, nil
+}
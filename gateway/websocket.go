@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mischat/zkp_auth/authserver"
+	"golang.org/x/net/websocket"
+)
+
+// The path of the WebSocket login
+const loginSocketPath = "/v1/login/ws"
+
+// How long a connection can go without a message before it is closed
+const socketIdleTimeout = 2 * time.Minute
+
+// Challenger is the part of authserver.Server the WebSocket login needs, the challenge
+// of a login is kept by the connection rather than under an auth ID on the server
+type Challenger interface {
+	Challenge(user string, r1 string, r2 string) (*authserver.Authentication, error)
+	Answer(ctx context.Context, auth *authserver.Authentication, s string) (string, error)
+}
+
+// The messages of the WebSocket login, one JSON object per text frame
+//
+//	-> {"type": "commit", "user": "alice@example.com", "r1": "...", "r2": "..."}
+//	<- {"type": "challenge", "c": "..."}
+//	-> {"type": "answer", "s": "..."}
+//	<- {"type": "session", "session_id": "..."}
+//
+// Anything that goes wrong is answered with {"type": "error", "error": "<code>", "message": "<why>"},
+// which drops the open challenge. The connection stays open for another login.
+type socketMessage struct {
+	Type      string `json:"type"`
+	User      string `json:"user,omitempty"`
+	R1        Number `json:"r1,omitempty"`
+	R2        Number `json:"r2,omitempty"`
+	C         Number `json:"c,omitempty"`
+	S         Number `json:"s,omitempty"`
+	SessionId string `json:"session_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+func (gw *Gateway) newLoginSocket(ch Challenger) http.Handler {
+	return websocket.Server{
+		// Any origin can log in, the login doesn't rely on cookies or anything else the browser adds
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ws.MaxPayloadBytes = maxBodySize
+			gw.serveLogins(ws, ch)
+		},
+	}
+}
+
+// This runs logins over one connection, the open challenge only lives in here
+func (gw *Gateway) serveLogins(ws *websocket.Conn, ch Challenger) {
+	ctx := ws.Request().Context()
+	var open *authserver.Authentication
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(socketIdleTimeout))
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("closing the login connection: %v", err)
+			}
+			return
+		}
+
+		var msg, reply socketMessage
+		err := json.Unmarshal(data, &msg)
+		if err != nil {
+			err = errBadRequest("the message is not valid JSON: %v", err)
+		}
+		switch {
+		case err != nil:
+		case msg.Type == "commit":
+			open, reply, err = gw.commit(ch, msg)
+		case msg.Type == "answer":
+			if open == nil {
+				err = errBadRequest("there is no challenge to answer, send a commit first")
+				break
+			}
+			// the challenge can't be answered twice, right or wrong
+			auth := open
+			open = nil
+			reply, err = gw.answer(ctx, ch, auth, msg)
+		default:
+			err = errBadRequest("the type must be commit or answer, not '%s'", msg.Type)
+		}
+		if err != nil {
+			open = nil
+			he := serviceError(err)
+			reply = socketMessage{Type: "error", Error: he.code, Message: he.msg}
+		}
+		if err := websocket.JSON.Send(ws, reply); err != nil {
+			log.Printf("closing the login connection: %v", err)
+			return
+		}
+	}
+}
+
+func (gw *Gateway) commit(ch Challenger, msg socketMessage) (*authserver.Authentication, socketMessage, error) {
+	r1, err := gw.decimal("r1", msg.R1)
+	if err != nil {
+		return nil, socketMessage{}, err
+	}
+	r2, err := gw.decimal("r2", msg.R2)
+	if err != nil {
+		return nil, socketMessage{}, err
+	}
+	auth, err := ch.Challenge(msg.User, r1, r2)
+	if err != nil {
+		return nil, socketMessage{}, err
+	}
+	return auth, socketMessage{Type: "challenge", C: Number(auth.C().String())}, nil
+}
+
+func (gw *Gateway) answer(ctx context.Context, ch Challenger, auth *authserver.Authentication, msg socketMessage) (socketMessage, error) {
+	s, err := gw.decimal("s", msg.S)
+	if err != nil {
+		return socketMessage{}, err
+	}
+	sessionId, err := ch.Answer(ctx, auth, s)
+	if err != nil {
+		return socketMessage{}, err
+	}
+	return socketMessage{Type: "session", SessionId: sessionId}, nil
+}
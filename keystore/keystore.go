@@ -0,0 +1,282 @@
+// Package keystore keeps client secrets in a file, encrypted under a passphrase.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	zkpautils "github.com/mischat/zkp_auth/utils"
+)
+
+const version = 1
+
+// The check value is encrypted when the keystore is created,
+// so that a wrong passphrase is noticed even when there are no identities
+var checkPlaintext = []byte("zkp_auth keystore")
+
+var (
+	// ErrWrongPassphrase is returned by Unlock when the passphrase doesn't open the keystore
+	ErrWrongPassphrase = errors.New("wrong keystore passphrase")
+	// ErrLocked is returned when a secret is needed before Unlock was called
+	ErrLocked = errors.New("keystore is locked")
+	// ErrNotFound is returned when there is no secret for an identity
+	ErrNotFound = errors.New("identity not found in keystore")
+)
+
+// Identity is what a secret is stored against
+// the same user can have different secrets on different servers or groups
+type Identity struct {
+	Server string `json:"server"`
+	User   string `json:"user"`
+	// Params identifies the parameter set p, q, g, h the secret belongs to
+	Params string `json:"params"`
+}
+
+func (id Identity) String() string {
+	return fmt.Sprintf("%s@%s (%s)", id.User, id.Server, id.Params)
+}
+
+// The additional data binds a ciphertext to its identity, so entries can't be swapped around
+func (id Identity) additionalData() []byte {
+	data, _ := json.Marshal(id)
+	return data
+}
+
+// This is how the keystore looks on disk
+type file struct {
+	Version    int               `json:"version"`
+	KDF        kdf               `json:"kdf"`
+	Check      sealed            `json:"check"`
+	Identities []encryptedSecret `json:"identities"`
+}
+
+type kdf struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory,omitempty"`
+	Threads   uint8  `json:"threads,omitempty"`
+}
+
+type sealed struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+type encryptedSecret struct {
+	Identity
+	sealed
+}
+
+// Keystore holds secrets per identity, each one encrypted with AES-GCM
+// under a key derived from the keystore passphrase
+type Keystore struct {
+	path string
+	file file
+	aead cipher.AEAD
+}
+
+// Open reads the keystore at path, a keystore that doesn't exist yet is empty
+// The identities can be listed straight away, the secrets need Unlock
+func Open(path string) (*Keystore, error) {
+	ks := &Keystore{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &ks.file); err != nil {
+		return nil, fmt.Errorf("could not read keystore '%s': %v", path, err)
+	}
+	if ks.file.Version != version {
+		return nil, fmt.Errorf("keystore '%s' has unsupported version %d", path, ks.file.Version)
+	}
+	return ks, nil
+}
+
+// Unlock derives the key from the passphrase, a new keystore is set up with it
+func (ks *Keystore) Unlock(passphrase string) error {
+	if ks.file.Version == 0 {
+		return ks.create(passphrase)
+	}
+
+	aead, err := newAEAD(passphrase, ks.file.KDF)
+	if err != nil {
+		return err
+	}
+
+	check, err := aead.Open(nil, ks.file.Check.Nonce, ks.file.Check.Ciphertext, checkPlaintext)
+	if err != nil || string(check) != string(checkPlaintext) {
+		return ErrWrongPassphrase
+	}
+
+	ks.aead = aead
+	return nil
+}
+
+func (ks *Keystore) create(passphrase string) error {
+	params, err := zkpautils.NewKDFParams(zkpautils.KDFArgon2id)
+	if err != nil {
+		return err
+	}
+	k := kdf{Algorithm: params.Algorithm, Salt: params.Salt, Time: params.Time, Memory: params.Memory, Threads: params.Threads}
+
+	aead, err := newAEAD(passphrase, k)
+	if err != nil {
+		return err
+	}
+
+	check, err := seal(aead, checkPlaintext, checkPlaintext)
+	if err != nil {
+		return err
+	}
+
+	ks.file = file{Version: version, KDF: k, Check: check}
+	ks.aead = aead
+	return nil
+}
+
+// List returns the identities in the keystore, this works while it is locked
+func (ks *Keystore) List() []Identity {
+	ids := make([]Identity, 0, len(ks.file.Identities))
+	for _, entry := range ks.file.Identities {
+		ids = append(ids, entry.Identity)
+	}
+	return ids
+}
+
+// Has reports whether there is a secret for the identity, this works while it is locked
+func (ks *Keystore) Has(id Identity) bool {
+	return ks.find(id) >= 0
+}
+
+// Get decrypts the secret x stored for the identity
+func (ks *Keystore) Get(id Identity) (*big.Int, error) {
+	if ks.aead == nil {
+		return nil, ErrLocked
+	}
+
+	i := ks.find(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	entry := ks.file.Identities[i]
+
+	plaintext, err := ks.aead.Open(nil, entry.Nonce, entry.Ciphertext, id.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secret for %v: %v", id, err)
+	}
+
+	x, ok := new(big.Int).SetString(string(plaintext), 10)
+	if !ok {
+		return nil, fmt.Errorf("secret for %v is not a number", id)
+	}
+	return x, nil
+}
+
+// Put stores the secret x for the identity, replacing any secret already there
+func (ks *Keystore) Put(id Identity, x *big.Int) error {
+	if ks.aead == nil {
+		return ErrLocked
+	}
+
+	s, err := seal(ks.aead, []byte(x.String()), id.additionalData())
+	if err != nil {
+		return err
+	}
+
+	entry := encryptedSecret{Identity: id, sealed: s}
+	if i := ks.find(id); i >= 0 {
+		ks.file.Identities[i] = entry
+	} else {
+		ks.file.Identities = append(ks.file.Identities, entry)
+	}
+
+	sort.Slice(ks.file.Identities, func(i, j int) bool {
+		return ks.file.Identities[i].String() < ks.file.Identities[j].String()
+	})
+	return nil
+}
+
+// Delete removes the identity, it reports whether it was there
+func (ks *Keystore) Delete(id Identity) bool {
+	i := ks.find(id)
+	if i < 0 {
+		return false
+	}
+	ks.file.Identities = append(ks.file.Identities[:i], ks.file.Identities[i+1:]...)
+	return true
+}
+
+// Save writes the keystore back to disk, readable by the user only
+func (ks *Keystore) Save() error {
+	if ks.file.Version == 0 {
+		return ErrLocked
+	}
+
+	data, err := json.MarshalIndent(ks.file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash doesn't leave half a keystore behind
+	tmp := ks.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+func (ks *Keystore) find(id Identity) int {
+	for i, entry := range ks.file.Identities {
+		if entry.Identity == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// This derives an AES-256 key from the passphrase
+func newAEAD(passphrase string, k kdf) (cipher.AEAD, error) {
+	key, err := zkpautils.DeriveKey(passphrase, &zkpautils.KDFParams{
+		Algorithm: k.Algorithm,
+		Salt:      k.Salt,
+		Time:      k.Time,
+		Memory:    k.Memory,
+		Threads:   k.Threads,
+	}, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Every encryption uses a fresh random nonce
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) (sealed, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return sealed{}, fmt.Errorf("could not generate nonce: %v", err)
+	}
+	return sealed{Nonce: nonce, Ciphertext: aead.Seal(nil, nonce, plaintext, additionalData)}, nil
+}
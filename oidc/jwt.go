@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Tokens are JWTs signed with Ed25519, which JOSE calls EdDSA (RFC 8037)
+const signingAlgorithm = "EdDSA"
+
+// JWK is an Ed25519 public key as a JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// This returns the JWK of a public key, its kid is the RFC 7638 thumbprint
+func newJWK(pub ed25519.PublicKey) JWK {
+	x := base64.RawURLEncoding.EncodeToString(pub)
+	// the members the thumbprint is over, in lexical order and without spaces
+	thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   x,
+		Kid: base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		Use: "sig",
+		Alg: signingAlgorithm,
+	}
+}
+
+// PublicKey returns the key of a JWK, checking that it is an Ed25519 key
+func (k JWK) PublicKey() (ed25519.PublicKey, error) {
+	if k.Kty != "OKP" || k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("the key is kty:'%s' crv:'%s', not an Ed25519 key", k.Kty, k.Crv)
+	}
+	pub, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("the key is not base64url: %v", err)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("the key is %d bytes, not %d", len(pub), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// This signs claims as a compact JWT of type typ
+func signJWT(key ed25519.PrivateKey, kid string, typ string, claims interface{}) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: signingAlgorithm, Typ: typ, Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(key, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyJWT checks the signature of a compact JWT against the keys of a JWKS, and decodes its claims into claims
+// It doesn't look at the claims, checking iss, aud, exp and nonce is up to the caller
+func VerifyJWT(token string, keys []JWK, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("the token has %d parts, not 3", len(parts))
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("the header is not base64url: %v", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("the header is not JSON: %v", err)
+	}
+	if header.Alg != signingAlgorithm {
+		return fmt.Errorf("the token is signed with '%s', not %s", header.Alg, signingAlgorithm)
+	}
+
+	var pub ed25519.PublicKey
+	for _, k := range keys {
+		if k.Kid == header.Kid {
+			if pub, err = k.PublicKey(); err != nil {
+				return err
+			}
+		}
+	}
+	if pub == nil {
+		return fmt.Errorf("there is no key '%s'", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("the signature is not base64url: %v", err)
+	}
+	if !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return fmt.Errorf("the signature does not verify")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("the claims are not base64url: %v", err)
+	}
+	return json.Unmarshal(payload, claims)
+}
+
+// ReadKeyFile reads an Ed25519 private key from a PKCS #8 PEM file, as written by
+// openssl genpkey -algorithm ed25519
+func ReadKeyFile(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("'%s' has no PRIVATE KEY block", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the key in '%s': %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the key in '%s' is a %T, not an Ed25519 key", path, key)
+	}
+	return edKey, nil
+}
+
+// GenerateKey returns a new Ed25519 key, for when there is no key file
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// The login page a relying party sends the user to. It runs the ZKP login of the gateway over its
+// WebSocket, in the browser, so x never leaves the page: only r1, r2 and s are sent. With the session
+// it got, the page posts back to /v1/authorize, which sends the user on to the relying party.
+// The gateway is always at the root of the host, as server/ runs it, whatever the path of the issuer.
+//
+// The passphrase is stretched with WebCrypto, which only has PBKDF2, so users who registered with
+// argon2id enter x, or log in with the client and post their session to /v1/authorize themselves.
+
+type loginPageData struct {
+	Client    string
+	Action    string
+	RequestId string
+	// The nonce of the Content-Security-Policy, which the inline script and style need
+	Nonce string
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+<style nonce="{{.Nonce}}">
+body { font-family: sans-serif; max-width: 24em; margin: 4em auto; }
+label, input, button { display: block; width: 100%; margin-top: 0.5em; }
+#error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Log in to {{.Client}}</h1>
+<form id="login">
+<label>User <input name="user" autocomplete="username" required></label>
+<label>Passphrase <input name="passphrase" type="password" autocomplete="current-password"></label>
+<label>Or the secret x <input name="x" type="password" autocomplete="off"></label>
+<button type="submit">Log in</button>
+</form>
+<p id="error" role="alert"></p>
+<form id="done" method="post" action="{{.Action}}">
+<input type="hidden" name="request_id" value="{{.RequestId}}">
+<input type="hidden" name="session_id">
+</form>
+<script nonce="{{.Nonce}}">
+"use strict";
+
+const form = document.getElementById("login");
+
+async function postJSON(path, body) {
+	const resp = await fetch(path, {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)});
+	const answer = await resp.json();
+	if (!resp.ok) {
+		throw new Error(answer.message);
+	}
+	return answer;
+}
+
+// b^e mod m
+function modPow(b, e, m) {
+	let r = 1n;
+	for (b %= m; e > 0n; e >>= 1n) {
+		if (e & 1n) {
+			r = r * b % m;
+		}
+		b = b * b % m;
+	}
+	return r;
+}
+
+// The bytes a scalar is reduced from, 128 bits more than q has, as in utils.DeriveSecret
+function scalarBytes(q) {
+	return Math.ceil(q.toString(2).length / 8) + 16;
+}
+
+// bytes mod (q - 1) + 1, a number in [1, q)
+function toScalar(bytes, q) {
+	let n = 0n;
+	for (const b of bytes) {
+		n = (n << 8n) | BigInt(b);
+	}
+	return n % (q - 1n) + 1n;
+}
+
+// x from the passphrase and the KDF parameters the user registered with
+async function deriveSecret(user, passphrase, q) {
+	const {kdf} = await postJSON("/v1/kdf", {user});
+	if (kdf.algorithm !== "pbkdf2-sha256") {
+		throw new Error("a passphrase stretched with " + kdf.algorithm + " can't be used in the browser, enter x instead");
+	}
+	const salt = Uint8Array.from(atob(kdf.salt), (c) => c.charCodeAt(0));
+	const key = await crypto.subtle.importKey("raw", new TextEncoder().encode(passphrase), "PBKDF2", false, ["deriveBits"]);
+	const bits = await crypto.subtle.deriveBits({name: "PBKDF2", hash: "SHA-256", salt, iterations: kdf.time}, key, scalarBytes(q) * 8);
+	return toScalar(new Uint8Array(bits), q);
+}
+
+// One login over the WebSocket, it resolves to the session ID
+function login(user, x, p, q, g, h) {
+	return new Promise((resolve, reject) => {
+		const k = toScalar(crypto.getRandomValues(new Uint8Array(scalarBytes(q))), q);
+		const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/v1/login/ws");
+		ws.onerror = () => reject(new Error("could not reach the login"));
+		ws.onopen = () => ws.send(JSON.stringify({type: "commit", user, r1: modPow(g, k, p).toString(), r2: modPow(h, k, p).toString()}));
+		ws.onmessage = (event) => {
+			const msg = JSON.parse(event.data);
+			if (msg.type === "challenge") {
+				const s = ((k - BigInt(msg.c) * x) % q + q) % q;
+				ws.send(JSON.stringify({type: "answer", s: s.toString()}));
+				return;
+			}
+			ws.close();
+			if (msg.type === "session") {
+				resolve(msg.session_id);
+			} else {
+				reject(new Error(msg.message));
+			}
+		};
+	});
+}
+
+form.addEventListener("submit", async (event) => {
+	event.preventDefault();
+	document.getElementById("error").textContent = "";
+	try {
+		const params = await (await fetch("/v1/params")).json();
+		const [p, q, g, h] = [params.p, params.q, params.g, params.h].map(BigInt);
+		const user = form.user.value;
+		const x = form.x.value ? BigInt(form.x.value) : await deriveSecret(user, form.passphrase.value, q);
+		const done = document.getElementById("done");
+		done.session_id.value = await login(user, x, p, q, g, h);
+		done.submit();
+	} catch (err) {
+		document.getElementById("error").textContent = err.message;
+	}
+});
+</script>
+</body>
+</html>
+`))
+
+// This serves the login page for a request that has been checked, and kept under requestId
+func (p *Provider) writeLoginPage(w http.ResponseWriter, client string, requestId string) {
+	nonce := randomString()
+	// The form posts to the relying party's redirect URI in the end, so there is no form-action
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+nonce+"'; style-src 'nonce-"+nonce+"'; connect-src 'self'; frame-ancestors 'none'; base-uri 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err := loginPage.Execute(w, loginPageData{Client: client, Action: p.path + AuthorizePath, RequestId: requestId, Nonce: nonce})
+	if err != nil {
+		log.Printf("could not write the login page: %v", err)
+	}
+}
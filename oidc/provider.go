@@ -0,0 +1,711 @@
+// Package oidc is a minimal OpenID provider on top of the ZKP login. A user who has logged in
+// gets an authorization code or an ID token for a relying party, signed with a local Ed25519 key,
+// so that apps which already speak OpenID Connect can use the ZKP login without any gRPC.
+package oidc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	"google.golang.org/grpc/status"
+)
+
+// The defaults of the optional Config fields
+const (
+	DefaultCodeTTL  = time.Minute
+	DefaultTokenTTL = 10 * time.Minute
+	DefaultLoginTTL = 10 * time.Minute
+)
+
+// The paths the provider serves, under the path of the issuer
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks.json"
+	AuthorizePath = "/v1/authorize"
+	TokenPath     = "/token"
+)
+
+// The most a request body can be
+const maxBodySize = 64 << 10
+
+// Config is how a Provider is set up, the optional fields use the defaults above when they are 0
+type Config struct {
+	// The URL the provider is reached at, which is the iss of every token
+	Issuer string
+	// The key tokens are signed with
+	Key ed25519.PrivateKey
+	// The relying parties that can ask for tokens
+	Clients []Client
+	// The service sessions are looked up in
+	Auth pb.AuthServer
+	// How long an authorization code can be redeemed for
+	CodeTTL time.Duration
+	// How long tokens are valid for
+	TokenTTL time.Duration
+	// How long the user has to log in on the login page
+	LoginTTL time.Duration
+	// The clock, time.Now when nil
+	Now func() time.Time
+}
+
+// Client is a relying party
+type Client struct {
+	ID string `json:"client_id"`
+	// Clients without a secret are public, and have to use PKCE for codes
+	Secret       string   `json:"client_secret,omitempty"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// ReadClientsFile reads the relying parties from a JSON file of the form {"clients": [...]}
+func ReadClientsFile(path string) ([]Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Clients []Client `json:"clients"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not read clients from '%s': %v", path, err)
+	}
+	return file.Clients, nil
+}
+
+// Provider serves the discovery document, the keys, and the authorize and token endpoints
+type Provider struct {
+	issuer string
+	// The path of the issuer, which the provider's paths go under, without a trailing /
+	path     string
+	key      ed25519.PrivateKey
+	jwk      JWK
+	clients  map[string]Client
+	auth     pb.AuthServer
+	codeTTL  time.Duration
+	tokenTTL time.Duration
+	loginTTL time.Duration
+	now      func() time.Time
+
+	// Codes that haven't been redeemed, each can only be redeemed once,
+	// and the requests of the login pages that are open, each can only be finished once
+	mu     sync.Mutex
+	codes  map[string]grant
+	logins map[string]pendingLogin
+}
+
+// A grant is what an authorization code stands for
+type grant struct {
+	client      string
+	redirectURI string
+	user        string
+	authTime    int64
+	nonce       string
+	scope       string
+	// The S256 PKCE challenge, empty when the client didn't send one
+	codeChallenge string
+	expires       time.Time
+}
+
+// New returns a Provider for the config
+func New(cfg Config) (*Provider, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || !issuer.IsAbs() || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return nil, fmt.Errorf("the issuer must be an absolute URL without a query or fragment, not '%s'", cfg.Issuer)
+	}
+	if len(cfg.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("the provider needs an Ed25519 key")
+	}
+	if cfg.Auth == nil {
+		return nil, fmt.Errorf("the provider needs the Auth service to look sessions up in")
+	}
+	if cfg.CodeTTL < 0 || cfg.TokenTTL < 0 || cfg.LoginTTL < 0 {
+		return nil, fmt.Errorf("the durations of the provider config must not be negative")
+	}
+	if cfg.CodeTTL == 0 {
+		cfg.CodeTTL = DefaultCodeTTL
+	}
+	if cfg.TokenTTL == 0 {
+		cfg.TokenTTL = DefaultTokenTTL
+	}
+	if cfg.LoginTTL == 0 {
+		cfg.LoginTTL = DefaultLoginTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	clients := make(map[string]Client)
+	for _, c := range cfg.Clients {
+		if c.ID == "" {
+			return nil, fmt.Errorf("a client has no client_id")
+		}
+		if _, exists := clients[c.ID]; exists {
+			return nil, fmt.Errorf("client '%s' is there twice", c.ID)
+		}
+		if len(c.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("client '%s' has no redirect_uris", c.ID)
+		}
+		for _, uri := range c.RedirectURIs {
+			if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+				return nil, fmt.Errorf("the redirect URI '%s' of client '%s' must be an absolute URL without a fragment", uri, c.ID)
+			}
+		}
+		clients[c.ID] = c
+	}
+
+	return &Provider{
+		issuer:   strings.TrimSuffix(cfg.Issuer, "/"),
+		path:     strings.TrimSuffix(issuer.Path, "/"),
+		key:      cfg.Key,
+		jwk:      newJWK(cfg.Key.Public().(ed25519.PublicKey)),
+		clients:  clients,
+		auth:     cfg.Auth,
+		codeTTL:  cfg.CodeTTL,
+		tokenTTL: cfg.TokenTTL,
+		loginTTL: cfg.LoginTTL,
+		now:      cfg.Now,
+		codes:    make(map[string]grant),
+		logins:   make(map[string]pendingLogin),
+	}, nil
+}
+
+// Register adds the provider's paths to mux, under the path of the issuer
+func (p *Provider) Register(mux *http.ServeMux) {
+	mux.HandleFunc(p.path+DiscoveryPath, p.discovery)
+	mux.HandleFunc(p.path+JWKSPath, p.jwks)
+	mux.HandleFunc(p.path+AuthorizePath, p.authorize)
+	mux.HandleFunc(p.path+TokenPath, p.token)
+}
+
+// Discovery is the provider metadata of OpenID Connect Discovery 1.0, for the fields we support
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                  []string `json:"scopes_supported"`
+	GrantTypesSupported              []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethods         []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, Discovery{
+		Issuer:                           p.issuer,
+		AuthorizationEndpoint:            p.issuer + AuthorizePath,
+		TokenEndpoint:                    p.issuer + TokenPath,
+		JWKSURI:                          p.issuer + JWKSPath,
+		ResponseTypesSupported:           []string{"code", "id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{signingAlgorithm},
+		ScopesSupported:                  []string{"openid"},
+		GrantTypesSupported:              []string{"authorization_code", "implicit"},
+		TokenEndpointAuthMethods:         []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:    []string{"S256"},
+		ClaimsSupported:                  []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Keys []JWK `json:"keys"`
+	}{[]JWK{p.jwk}})
+}
+
+// IDTokenClaims are the claims of an ID token
+type IDTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	// When the user logged in, the creation of their session
+	AuthTime int64  `json:"auth_time"`
+	Nonce    string `json:"nonce,omitempty"`
+	// How the user logged in, always zkp
+	AMR []string `json:"amr"`
+}
+
+// AccessTokenClaims are the claims of an access token, as in RFC 9068
+type AccessTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ClientID  string `json:"client_id"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	ID        string `json:"jti"`
+	Scope     string `json:"scope"`
+}
+
+// The parameters of /v1/authorize, as the relying party sent the user over with them
+// A client that logged in with the ZKP itself posts them as JSON, with its session
+type authorizeRequest struct {
+	SessionId           string `json:"session_id"`
+	ClientId            string `json:"client_id"`
+	RedirectUri         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// The answer of /v1/authorize, the client sends the user on to redirect_to
+type authorizeResponse struct {
+	RedirectTo string `json:"redirect_to"`
+	Code       string `json:"code,omitempty"`
+	IDToken    string `json:"id_token,omitempty"`
+}
+
+// authorizeError is a refused authorization request
+type authorizeError struct {
+	status      int
+	code        string
+	description string
+	// Whether the redirect URI was checked, so that the error can go back to the relying party, RFC 6749 4.1.2.1
+	redirect bool
+}
+
+// A login page that is open, its authorization request is finished once the user has a session
+type pendingLogin struct {
+	req     authorizeRequest
+	expires time.Time
+}
+
+// The relying party sends the user here with GET, and gets them back with a code or an ID token
+// once they logged in on the login page. A client that logs in with the ZKP itself can instead
+// post its session with the parameters as JSON, and send the user on to the URL it gets back
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet:
+		p.authorizePage(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded"):
+		p.finishLogin(w, r)
+	case r.Method == http.MethodPost:
+		p.authorizeSession(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "the method must be GET or POST")
+	}
+}
+
+// This checks the request, and serves the login page for it
+func (p *Provider) authorizePage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := authorizeRequest{
+		ClientId:            query.Get("client_id"),
+		RedirectUri:         query.Get("redirect_uri"),
+		ResponseType:        query.Get("response_type"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+	if aerr := p.checkRequest(req); aerr != nil {
+		p.refuse(w, req, aerr)
+		return
+	}
+	p.writeLoginPage(w, req.ClientId, p.newLogin(req))
+}
+
+// This is the login page posting the session it logged in with, which finishes the request it was opened for
+func (p *Provider) finishLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("the body is not a valid form: %v", err))
+		return
+	}
+	req, err := p.takeLogin(r.PostForm.Get("request_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	req.SessionId = r.PostForm.Get("session_id")
+
+	resp, aerr := p.issue(r, req)
+	if aerr != nil {
+		p.refuse(w, req, aerr)
+		return
+	}
+	http.Redirect(w, r, resp.RedirectTo, http.StatusSeeOther)
+}
+
+// This turns the session of a client that logged in itself into a code or an ID token
+func (p *Provider) authorizeSession(w http.ResponseWriter, r *http.Request) {
+	var req authorizeRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("the body is not valid JSON: %v", err))
+		return
+	}
+
+	aerr := p.checkRequest(req)
+	if aerr == nil {
+		var resp authorizeResponse
+		if resp, aerr = p.issue(r, req); aerr == nil {
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	}
+	writeError(w, aerr.status, aerr.code, aerr.description)
+}
+
+// This checks the parameters the relying party sent, everything but the session
+func (p *Provider) checkRequest(req authorizeRequest) *authorizeError {
+	client, ok := p.clients[req.ClientId]
+	if !ok {
+		return &authorizeError{status: http.StatusBadRequest, code: "unauthorized_client", description: fmt.Sprintf("there is no client '%s'", req.ClientId)}
+	}
+	if !contains(client.RedirectURIs, req.RedirectUri) {
+		return &authorizeError{status: http.StatusBadRequest, code: "invalid_request", description: fmt.Sprintf("'%s' is not a redirect URI of client '%s'", req.RedirectUri, client.ID)}
+	}
+
+	// from here on the redirect URI is one of the client's
+	refused := func(code string, description string) *authorizeError {
+		return &authorizeError{status: http.StatusBadRequest, code: code, description: description, redirect: true}
+	}
+	if !contains(strings.Fields(req.Scope), "openid") {
+		return refused("invalid_scope", "the scope must include openid")
+	}
+	switch req.ResponseType {
+	case "code":
+		if req.CodeChallenge == "" && client.Secret == "" {
+			return refused("invalid_request", fmt.Sprintf("client '%s' is public, so it has to send a code_challenge", client.ID))
+		}
+		if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+			return refused("invalid_request", fmt.Sprintf("the code_challenge_method must be S256, not '%s'", req.CodeChallengeMethod))
+		}
+	case "id_token":
+		// without a nonce, an ID token in a redirect can be replayed
+		if req.Nonce == "" {
+			return refused("invalid_request", "an id_token response needs a nonce")
+		}
+	default:
+		return refused("unsupported_response_type", fmt.Sprintf("the response_type must be code or id_token, not '%s'", req.ResponseType))
+	}
+	return nil
+}
+
+// This issues a code or an ID token for the user of the session, the request has been checked already
+func (p *Provider) issue(r *http.Request, req authorizeRequest) (authorizeResponse, *authorizeError) {
+	session, err := p.auth.WhoAmI(r.Context(), &pb.WhoAmIRequest{SessionId: req.SessionId})
+	if err != nil {
+		return authorizeResponse{}, &authorizeError{status: http.StatusUnauthorized, code: "login_required", description: fmt.Sprintf("the session is not valid: %v", status.Convert(err).Message()), redirect: true}
+	}
+
+	g := grant{
+		client:        req.ClientId,
+		redirectURI:   req.RedirectUri,
+		user:          session.GetUser(),
+		authTime:      session.GetCreatedAt(),
+		nonce:         req.Nonce,
+		scope:         req.Scope,
+		codeChallenge: req.CodeChallenge,
+	}
+
+	var resp authorizeResponse
+	if req.ResponseType == "code" {
+		resp.Code = p.newCode(g)
+		resp.RedirectTo = redirectURL(req, url.Values{"code": {resp.Code}})
+	} else {
+		if resp.IDToken, err = p.idToken(g); err != nil {
+			return authorizeResponse{}, &authorizeError{status: http.StatusInternalServerError, code: "server_error", description: err.Error()}
+		}
+		resp.RedirectTo = redirectURL(req, url.Values{"id_token": {resp.IDToken}})
+	}
+	log.Printf("authorized '%s' for client '%s' with a %s", g.user, g.client, req.ResponseType)
+	return resp, nil
+}
+
+// This answers a refused request, by sending the user back to the relying party with the error
+// when the redirect URI can be trusted, and to nobody when it can't
+func (p *Provider) refuse(w http.ResponseWriter, req authorizeRequest, aerr *authorizeError) {
+	if !aerr.redirect {
+		writeError(w, aerr.status, aerr.code, aerr.description)
+		return
+	}
+	w.Header().Set("Location", redirectURL(req, url.Values{"error": {aerr.code}, "error_description": {aerr.description}}))
+	w.WriteHeader(http.StatusFound)
+}
+
+// This adds values and the state to the redirect URI, which has been checked already
+// ID tokens go in the fragment, so that they don't end up in the relying party's logs
+func redirectURL(req authorizeRequest, values url.Values) string {
+	if req.State != "" {
+		values.Set("state", req.State)
+	}
+	// the redirect URI is one of the client's, so it parses, and has no fragment
+	redirect, _ := url.Parse(req.RedirectUri)
+	if req.ResponseType == "id_token" {
+		return redirect.String() + "#" + values.Encode()
+	}
+	query := redirect.Query()
+	for k, v := range values {
+		query[k] = v
+	}
+	redirect.RawQuery = query.Encode()
+	return redirect.String()
+}
+
+// This keeps a checked request until the login page finishes it, and drops the ones that have expired
+func (p *Provider) newLogin(req authorizeRequest) string {
+	now := p.now()
+	id := randomString()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for other, login := range p.logins {
+		if !now.Before(login.expires) {
+			delete(p.logins, other)
+		}
+	}
+	p.logins[id] = pendingLogin{req: req, expires: now.Add(p.loginTTL)}
+	return id
+}
+
+// This takes the request a login page was opened for, it can only be finished once
+func (p *Provider) takeLogin(id string) (authorizeRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	login, ok := p.logins[id]
+	if !ok {
+		return authorizeRequest{}, errors.New("the request_id is not valid, or has been used already")
+	}
+	delete(p.logins, id)
+	if !p.now().Before(login.expires) {
+		return authorizeRequest{}, errors.New("the login page has expired, go back to the app and start again")
+	}
+	return login.req, nil
+}
+
+// This stores a grant under a new code, and drops the codes that have expired
+func (p *Provider) newCode(g grant) string {
+	now := p.now()
+	g.expires = now.Add(p.codeTTL)
+	code := randomString()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c, other := range p.codes {
+		if !now.Before(other.expires) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = g
+	return code
+}
+
+// This takes the grant of a code, which can then never be redeemed again
+func (p *Provider) redeem(code string) (grant, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.codes[code]
+	if !ok {
+		return grant{}, errors.New("the code is not valid, or has been redeemed already")
+	}
+	delete(p.codes, code)
+	if !p.now().Before(g.expires) {
+		return grant{}, errors.New("the code has expired")
+	}
+	return g, nil
+}
+
+func (p *Provider) idToken(g grant) (string, error) {
+	now := p.now()
+	return signJWT(p.key, p.jwk.Kid, "JWT", IDTokenClaims{
+		Issuer:    p.issuer,
+		Subject:   g.user,
+		Audience:  g.client,
+		ExpiresAt: now.Add(p.tokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		AuthTime:  g.authTime,
+		Nonce:     g.nonce,
+		AMR:       []string{"zkp"},
+	})
+}
+
+func (p *Provider) accessToken(g grant) (string, error) {
+	now := p.now()
+	return signJWT(p.key, p.jwk.Kid, "at+jwt", AccessTokenClaims{
+		Issuer:    p.issuer,
+		Subject:   g.user,
+		Audience:  g.client,
+		ClientID:  g.client,
+		ExpiresAt: now.Add(p.tokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        randomString(),
+		Scope:     g.scope,
+	})
+}
+
+// TokenResponse is the answer of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// This redeems an authorization code for tokens, as in RFC 6749 4.1.3
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("the body is not a valid form: %v", err))
+		return
+	}
+	if gt := r.PostForm.Get("grant_type"); gt != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("the grant_type must be authorization_code, not '%s'", gt))
+		return
+	}
+
+	client, err := p.authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	g, err := p.redeem(r.PostForm.Get("code"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	if g.client != client.ID {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "the code was issued to another client")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != g.redirectURI {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "the redirect_uri is not the one the code was issued for")
+		return
+	}
+	if g.codeChallenge != "" {
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(verifier[:])), []byte(g.codeChallenge)) != 1 {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "the code_verifier does not match the code_challenge")
+			return
+		}
+	}
+
+	idToken, err := p.idToken(g)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	accessToken, err := p.accessToken(g)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	log.Printf("issued tokens for '%s' to client '%s'", g.user, g.client)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(p.tokenTTL / time.Second),
+		IDToken:     idToken,
+		Scope:       g.scope,
+	})
+}
+
+// This finds the client of a token request, from HTTP basic auth or the form
+// A client with a secret has to send it, a public client only sends its client_id
+func (p *Provider) authenticateClient(r *http.Request) (Client, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// the credentials are form encoded before they go into the header, RFC 6749 2.3.1
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return Client{}, fmt.Errorf("the client_id is not form encoded")
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return Client{}, fmt.Errorf("the client_secret is not form encoded")
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, ok := p.clients[id]
+	if !ok {
+		return Client{}, fmt.Errorf("there is no client '%s'", id)
+	}
+	if client.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		return Client{}, fmt.Errorf("the client_secret of client '%s' is wrong", id)
+	}
+	return client, nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "invalid_request", fmt.Sprintf("the method must be %s", method))
+	return false
+}
+
+// Errors are answered as in RFC 6749 5.2, which is what relying parties' libraries expect
+func writeError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}{code, description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write the answer: %v", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// A random 256 bit string, for codes and token IDs
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
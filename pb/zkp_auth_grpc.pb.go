@@ -22,6 +22,12 @@ const (
 	Auth_Register_FullMethodName                      = "/zkp_auth.Auth/Register"
 	Auth_CreateAuthenticationChallenge_FullMethodName = "/zkp_auth.Auth/CreateAuthenticationChallenge"
 	Auth_VerifyAuthentication_FullMethodName          = "/zkp_auth.Auth/VerifyAuthentication"
+	Auth_GetPublicParameters_FullMethodName           = "/zkp_auth.Auth/GetPublicParameters"
+	Auth_WhoAmI_FullMethodName                        = "/zkp_auth.Auth/WhoAmI"
+	Auth_Logout_FullMethodName                        = "/zkp_auth.Auth/Logout"
+	Auth_Rotate_FullMethodName                        = "/zkp_auth.Auth/Rotate"
+	Auth_GetKdfParameters_FullMethodName              = "/zkp_auth.Auth/GetKdfParameters"
+	Auth_Authenticate_FullMethodName                  = "/zkp_auth.Auth/Authenticate"
 )
 
 // AuthClient is the client API for Auth service.
@@ -31,6 +37,13 @@ type AuthClient interface {
 	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
 	CreateAuthenticationChallenge(ctx context.Context, in *AuthenticationChallengeRequest, opts ...grpc.CallOption) (*AuthenticationChallengeResponse, error)
 	VerifyAuthentication(ctx context.Context, in *AuthenticationAnswerRequest, opts ...grpc.CallOption) (*AuthenticationAnswerResponse, error)
+	GetPublicParameters(ctx context.Context, in *PublicParametersRequest, opts ...grpc.CallOption) (*PublicParametersResponse, error)
+	WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*WhoAmIResponse, error)
+	Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*LogoutResponse, error)
+	Rotate(ctx context.Context, in *RotateRequest, opts ...grpc.CallOption) (*RotateResponse, error)
+	GetKdfParameters(ctx context.Context, in *KdfParametersRequest, opts ...grpc.CallOption) (*KdfParametersResponse, error)
+	// One login on one stream, the challenge only lives as long as the stream
+	Authenticate(ctx context.Context, opts ...grpc.CallOption) (Auth_AuthenticateClient, error)
 }
 
 type authClient struct {
@@ -68,6 +81,82 @@ func (c *authClient) VerifyAuthentication(ctx context.Context, in *Authenticatio
 	return out, nil
 }
 
+func (c *authClient) GetPublicParameters(ctx context.Context, in *PublicParametersRequest, opts ...grpc.CallOption) (*PublicParametersResponse, error) {
+	out := new(PublicParametersResponse)
+	err := c.cc.Invoke(ctx, Auth_GetPublicParameters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) WhoAmI(ctx context.Context, in *WhoAmIRequest, opts ...grpc.CallOption) (*WhoAmIResponse, error) {
+	out := new(WhoAmIResponse)
+	err := c.cc.Invoke(ctx, Auth_WhoAmI_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*LogoutResponse, error) {
+	out := new(LogoutResponse)
+	err := c.cc.Invoke(ctx, Auth_Logout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) Rotate(ctx context.Context, in *RotateRequest, opts ...grpc.CallOption) (*RotateResponse, error) {
+	out := new(RotateResponse)
+	err := c.cc.Invoke(ctx, Auth_Rotate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) GetKdfParameters(ctx context.Context, in *KdfParametersRequest, opts ...grpc.CallOption) (*KdfParametersResponse, error) {
+	out := new(KdfParametersResponse)
+	err := c.cc.Invoke(ctx, Auth_GetKdfParameters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) Authenticate(ctx context.Context, opts ...grpc.CallOption) (Auth_AuthenticateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Auth_ServiceDesc.Streams[0], Auth_Authenticate_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &authAuthenticateClient{stream}
+	return x, nil
+}
+
+type Auth_AuthenticateClient interface {
+	Send(*AuthenticateRequest) error
+	Recv() (*AuthenticateResponse, error)
+	grpc.ClientStream
+}
+
+type authAuthenticateClient struct {
+	grpc.ClientStream
+}
+
+func (x *authAuthenticateClient) Send(m *AuthenticateRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *authAuthenticateClient) Recv() (*AuthenticateResponse, error) {
+	m := new(AuthenticateResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // AuthServer is the server API for Auth service.
 // All implementations must embed UnimplementedAuthServer
 // for forward compatibility
@@ -75,6 +164,13 @@ type AuthServer interface {
 	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
 	CreateAuthenticationChallenge(context.Context, *AuthenticationChallengeRequest) (*AuthenticationChallengeResponse, error)
 	VerifyAuthentication(context.Context, *AuthenticationAnswerRequest) (*AuthenticationAnswerResponse, error)
+	GetPublicParameters(context.Context, *PublicParametersRequest) (*PublicParametersResponse, error)
+	WhoAmI(context.Context, *WhoAmIRequest) (*WhoAmIResponse, error)
+	Logout(context.Context, *LogoutRequest) (*LogoutResponse, error)
+	Rotate(context.Context, *RotateRequest) (*RotateResponse, error)
+	GetKdfParameters(context.Context, *KdfParametersRequest) (*KdfParametersResponse, error)
+	// One login on one stream, the challenge only lives as long as the stream
+	Authenticate(Auth_AuthenticateServer) error
 	mustEmbedUnimplementedAuthServer()
 }
 
@@ -91,6 +187,24 @@ func (UnimplementedAuthServer) CreateAuthenticationChallenge(context.Context, *A
 func (UnimplementedAuthServer) VerifyAuthentication(context.Context, *AuthenticationAnswerRequest) (*AuthenticationAnswerResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method VerifyAuthentication not implemented")
 }
+func (UnimplementedAuthServer) GetPublicParameters(context.Context, *PublicParametersRequest) (*PublicParametersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPublicParameters not implemented")
+}
+func (UnimplementedAuthServer) WhoAmI(context.Context, *WhoAmIRequest) (*WhoAmIResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WhoAmI not implemented")
+}
+func (UnimplementedAuthServer) Logout(context.Context, *LogoutRequest) (*LogoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+func (UnimplementedAuthServer) Rotate(context.Context, *RotateRequest) (*RotateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rotate not implemented")
+}
+func (UnimplementedAuthServer) GetKdfParameters(context.Context, *KdfParametersRequest) (*KdfParametersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetKdfParameters not implemented")
+}
+func (UnimplementedAuthServer) Authenticate(Auth_AuthenticateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
 func (UnimplementedAuthServer) mustEmbedUnimplementedAuthServer() {}
 
 // UnsafeAuthServer may be embedded to opt out of forward compatibility for this service.
@@ -158,6 +272,122 @@ func _Auth_VerifyAuthentication_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Auth_GetPublicParameters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublicParametersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).GetPublicParameters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_GetPublicParameters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).GetPublicParameters(ctx, req.(*PublicParametersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_WhoAmI_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WhoAmIRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).WhoAmI(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_WhoAmI_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).WhoAmI(ctx, req.(*WhoAmIRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_Logout_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).Logout(ctx, req.(*LogoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_Rotate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).Rotate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_Rotate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).Rotate(ctx, req.(*RotateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_GetKdfParameters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KdfParametersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).GetKdfParameters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Auth_GetKdfParameters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).GetKdfParameters(ctx, req.(*KdfParametersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_Authenticate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AuthServer).Authenticate(&authAuthenticateServer{stream})
+}
+
+type Auth_AuthenticateServer interface {
+	Send(*AuthenticateResponse) error
+	Recv() (*AuthenticateRequest, error)
+	grpc.ServerStream
+}
+
+type authAuthenticateServer struct {
+	grpc.ServerStream
+}
+
+func (x *authAuthenticateServer) Send(m *AuthenticateResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *authAuthenticateServer) Recv() (*AuthenticateRequest, error) {
+	m := new(AuthenticateRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Auth_ServiceDesc is the grpc.ServiceDesc for Auth service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -177,7 +407,34 @@ var Auth_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "VerifyAuthentication",
 			Handler:    _Auth_VerifyAuthentication_Handler,
 		},
+		{
+			MethodName: "GetPublicParameters",
+			Handler:    _Auth_GetPublicParameters_Handler,
+		},
+		{
+			MethodName: "WhoAmI",
+			Handler:    _Auth_WhoAmI_Handler,
+		},
+		{
+			MethodName: "Logout",
+			Handler:    _Auth_Logout_Handler,
+		},
+		{
+			MethodName: "Rotate",
+			Handler:    _Auth_Rotate_Handler,
+		},
+		{
+			MethodName: "GetKdfParameters",
+			Handler:    _Auth_GetKdfParameters_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Authenticate",
+			Handler:       _Auth_Authenticate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "zkp_auth.proto",
 }
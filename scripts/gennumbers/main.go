@@ -1,113 +1,125 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
-	"math/big"
+	"os"
+	"time"
 
-	"github.com/fxtlabs/primes"
 	zkpautils "github.com/mischat/zkp_auth/utils"
 )
 
 // We are going to use this script to generate the public data we need
 // To setup our ZKP Auth system
-// To find suitable values for g, h, and q we need to perform the following steps:
-// 1. pick a prime number p
-// 2. find a prime number q that divides p-1 evenly
-//   a. that is find a number r such that p = qr + 1
-// 3. Find a generator for the Schnorr group
-// 4. Generate 20 numbers in the group
-// 5. find two numbers g, h such that h^r mod p = 1 && g^r mod p = 1
-// 6. g and h must have the same prime order
-// 7. Validate these numbers; p, q, g, h are public variables and they pass our validation checks
+// To find suitable values for p, q, g and h we perform the following steps:
+// 1. pick a random prime number q of -qbits bits
+// 2. pick random numbers of -pbits bits until we find a prime p such that q divides p-1
+//   a. that is p = qr + 1
+// 3. derive g and h from a public seed, as in FIPS 186-4 A.2.3
+//   a. hash the seed, "ggen", an index and a counter, and raise the hash to the power r mod p
+//   b. this lands them in the subgroup of order q, so g^q mod p = 1 && h^q mod p = 1
+//   c. g and h come out of SHA-256, so nobody can know log_g h
+// 4. Validate these numbers; p, q, g, h are public variables and they pass our validation checks under -policy
+// 5. Write them out, with the seed and counters, as a parameter file that the server and client read with -params
+//   a. as JSON, or with -format pem as an armored block with the fingerprint in its headers
+//
+// With -verify the script instead re-derives g and h of an existing parameter file from its seed
 
 // Note that the security of the protocol depends on the difficulty of computing discrete logarithms in the group generated by g. For this reason, it's important to choose a large prime number p and a large prime factor q.
 // https://en.wikipedia.org/wiki/Schnorr_group
 func main() {
 	// Define command line flags
-	pFlag := flag.Int("p", 20000, "the maximum prime number to search for")
+	pBitsFlag := flag.Int("pbits", 2048, "the size of p in bits, 2048 or 3072 are sensible")
+	qBitsFlag := flag.Int("qbits", 256, "the size of q in bits")
+	outFlag := flag.String("out", "-", "the parameter file to write, '-' for stdout")
+	seedFlag := flag.String("seed", "", "the public seed g and h are derived from in hex, random when not set")
+	verifyFlag := flag.String("verify", "", "a parameter file to check, rather than generating a new one")
+	policyFlag := flag.String("policy", "default", "the validation policy the parameters must meet, one of toy, default or strict")
+	nameFlag := flag.String("name", "", "a name for the parameter set, recorded in the file")
+	formatFlag := flag.String("format", zkpautils.ParamsFormatJSON, "the format of the parameter file, either 'json' or 'pem'")
+	arithmeticFlag := flag.String("arithmetic", "", "the arithmetic clients should use on secrets, 'big' or 'constant-time', math/big when not set")
 	flag.Parse()
 
-	fmt.Println("Hello, this setup script should be used to calaculate the public variables for the ZKP Auth system")
-	// Firstly let's get a prime number, and then we need to find a group.
-	// Generate the prime numbers less than or equal to some number that is passed in
-	ps := primes.Sieve(*pFlag)
-	fmt.Printf("we have just found all primes up until: '%d'\n", pFlag)
-
-	// Get the biggest one
-	p := big.NewInt(int64(ps[len(ps)-1]))
+	policy, err := zkpautils.PolicyByName(*policyFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Printf("The prime number p is selected as: '%d'\n", p)
+	if *verifyFlag != "" {
+		verify(*verifyFlag, policy)
+		return
+	}
 
-	// Here we use the Schnorr group theory https://en.wikipedia.org/wiki/Schnorr_group
-	// to find a set of suitable values for g, h and q
-	// given that we will start from a value for p, we need to find a q, h and g
+	var seed []byte
+	if *seedFlag != "" {
+		var err error
+		seed, err = hex.DecodeString(*seedFlag)
+		if err != nil {
+			log.Fatalf("-seed is not hex: %v", err)
+		}
+	}
 
-	// Find a prime number q that divides p-1 evenly
-	// such that p = qr + 1
-	pMinusOne := new(big.Int).Sub(p, big.NewInt(1))
+	log.Printf("Generating a %d bit p with a %d bit q, this can take a little while", *pBitsFlag, *qBitsFlag)
+	start := time.Now()
 
-	q := new(big.Int)
+	params, err := zkpautils.GenerateParams(rand.Reader, *pBitsFlag, *qBitsFlag, seed)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	allPrimes := []*big.Int{}
-	allRs := []*big.Int{}
+	log.Printf("Found a group in %v", time.Since(start).Round(time.Millisecond))
 
-	for i := 0; i < len(ps)-1; i++ {
-		pToCheck := big.NewInt(int64(ps[i]))
+	// I think that this sanity validation check is a good idea
+	// It might all be implied here
+	if report := zkpautils.ValidateParams(params, policy); !report.Valid() {
+		log.Fatalf("the generated parameters are not valid:\n%v", report)
+	}
 
-		// This check ensures that we don't end up with an error due to rounding ints
-		divison := new(big.Float).Quo(new(big.Float).SetInt(pMinusOne), new(big.Float).SetInt(pToCheck))
-		if divison.IsInt() {
-			if new(big.Int).Mod(new(big.Int).Div(pMinusOne, pToCheck), big.NewInt(2)).Cmp(big.NewInt(0)) == 0 {
-				fmt.Println("This one here is a prime number that divides p-1 evenly: ", pToCheck)
-				allPrimes = append(allPrimes, pToCheck)
-				allRs = append(allRs, new(big.Int).Div(pMinusOne, pToCheck))
-			}
-		}
+	params.Name = *nameFlag
+	params.Arithmetic = *arithmeticFlag
+	if _, err := zkpautils.NewArithmetic(params); err != nil {
+		log.Fatal(err)
 	}
+	params.Created = time.Now().UTC().Truncate(time.Second)
 
-	fmt.Printf("We have found '%d' many primes\n", len(allPrimes))
-
-	generator := new(big.Int)
-
-	for prime := range allPrimes {
-		// Now to try and generate a value for h and g that have the right order
-		for h := big.NewInt(1); h.Cmp(p) < 0; h.Add(h, big.NewInt(1)) {
-			g := new(big.Int).Exp(h, allRs[prime], p)
-			if g.Cmp(big.NewInt(1)) == 0 {
-				continue
-			} else {
-				if new(big.Int).Exp(g, allPrimes[prime], p).Cmp(big.NewInt(1)) == 0 {
-					fmt.Printf("Found a generator of p and q: '%d'\n", g)
-					generator = g
-					q = allPrimes[prime]
-					break
-				}
-			}
-		}
+	data, err := zkpautils.MarshalParams(params, *formatFlag)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Now we should have our generator
-	g := generator
-	fmt.Printf("The generators is: g:'%d'\n", g)
+	log.Printf("The parameter fingerprint is %s", params.Fingerprint())
 
-	group := []*big.Int{}
-	// Create a group of size 20 (as we don't really need more in the group)
-	for i := big.NewInt(1); i.Cmp(big.NewInt(20)) < 0; i.Add(i, big.NewInt(1)) {
-		tmp := new(big.Int).Exp(g, i, p)
-		group = append(group, tmp)
+	if *outFlag == "-" {
+		os.Stdout.Write(data)
+		return
 	}
 
-	fmt.Println("The group is: ", group)
-	// I think that this sanity validation check is a good idea
-	// It might all be implied here
-	fmt.Printf("Now to sanity check the values p:'%d' q: '%d' g: '%d' h: '%d'\n", p, q, group[0], group[1])
-	valid, err := zkpautils.ValidatePublicVariables(p, q, group[0], group[1])
+	if err := os.WriteFile(*outFlag, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the parameters to '%s', start the server and client with -params %s\n", *outFlag, *outFlag)
+}
 
+// This checks a parameter file, and that its g and h really come from its seed
+func verify(path string, policy zkpautils.ValidationPolicy) {
+	params, err := zkpautils.ReadParamsFile(path)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("Valid: %t\n", valid)
+	report := zkpautils.ValidateParams(params, policy)
+	fmt.Print(report)
+	if !report.Valid() {
+		log.Fatalf("'%s' failed %d of the checks", path, len(report.Failed()))
+	}
+
+	if err := zkpautils.VerifyDerivation(params); err != nil {
+		log.Fatalf("could not verify g and h: %v", err)
+	}
+
+	fmt.Printf("g and h in '%s' are derived from seed %x\n", path, params.Derivation.Seed)
+	fmt.Printf("fingerprint: %s\n", params.Fingerprint())
 }
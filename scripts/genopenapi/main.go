@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mischat/zkp_auth/gateway"
+)
+
+// This script writes the OpenAPI document of the HTTP gateway, or checks that a file is up to date
+// The document is built from the gateway's routes, so it has to be written again whenever they change.
+//
+//	go run ./scripts/genopenapi -out gateway/openapi.json
+//	go run ./scripts/genopenapi -check gateway/openapi.json
+func main() {
+	outFlag := flag.String("out", "-", "the file to write the document to, '-' for stdout")
+	checkFlag := flag.String("check", "", "a file to compare with the document, rather than writing it")
+	flag.Parse()
+
+	doc, err := gateway.OpenAPI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *checkFlag != "" {
+		data, err := os.ReadFile(*checkFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !bytes.Equal(data, doc) {
+			log.Fatalf("'%s' is out of date, write it again with -out", *checkFlag)
+		}
+		fmt.Printf("'%s' is up to date\n", *checkFlag)
+		return
+	}
+
+	if *outFlag == "-" {
+		os.Stdout.Write(doc)
+		return
+	}
+	if err := os.WriteFile(*outFlag, doc, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the OpenAPI document to '%s'\n", *outFlag)
+}
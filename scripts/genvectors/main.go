@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	zkpautils "github.com/mischat/zkp_auth/utils"
+)
+
+// This script writes the test vectors, or checks a file of them
+// The vectors are transcripts of the protocol with the x, k and c that made them, and what
+// VerifyProof and VerifyChaumPedersen make of them, so that clients in other languages can check
+// that they compute the same y1, y2, r1, r2 and s, and accept and refuse the same proofs.
+// They are the same every time, so regenerating them only changes the file when the code changes.
+//
+//	go run ./scripts/genvectors -out test/testdata/vectors.json
+//	go run ./scripts/genvectors -check test/testdata/vectors.json
+func main() {
+	outFlag := flag.String("out", "-", "the file to write the vectors to, '-' for stdout")
+	checkFlag := flag.String("check", "", "a file of vectors to check, rather than writing them")
+	flag.Parse()
+
+	if *checkFlag != "" {
+		check(*checkFlag)
+		return
+	}
+
+	vs, err := generateVectors()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.MarshalIndent(vs, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+
+	if *outFlag == "-" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*outFlag, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d vectors over %d groups to '%s'\n", len(vs.Vectors), len(vs.Groups), *outFlag)
+}
+
+// This checks every vector of a file, and that the file is what this code would write
+func check(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var vs zkpautils.Vectors
+	if err := json.Unmarshal(data, &vs); err != nil {
+		log.Fatalf("could not read '%s': %v", path, err)
+	}
+
+	if err := zkpautils.CheckVectors(&vs); err != nil {
+		log.Fatalf("the vectors in '%s' do not match:\n%v", path, err)
+	}
+	fmt.Printf("all %d vectors in '%s' match\n", len(vs.Vectors), path)
+
+	// vectors written by someone else can be right without being ours
+	ours, err := generateVectors()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ours.Vectors) != len(vs.Vectors) {
+		fmt.Printf("'%s' has %d vectors, this code writes %d\n", path, len(vs.Vectors), len(ours.Vectors))
+		return
+	}
+	for i := range ours.Vectors {
+		if ours.Vectors[i] != vs.Vectors[i] {
+			fmt.Printf("'%s' differs from the vectors this code writes from '%s' on\n", path, vs.Vectors[i].Name)
+			return
+		}
+	}
+	fmt.Println("and they are the vectors this code writes")
+}
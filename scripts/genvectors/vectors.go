@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	zkpautils "github.com/mischat/zkp_auth/utils"
+)
+
+// Everything is derived from fixed labels, so generateVectors returns the same vectors every time
+
+// The groups of the vectors: the small example group, the 256 bit group of the README,
+// a 2048 bit Schnorr group and a 2048 bit safe-prime group
+func vectorGroups() ([]*zkpautils.Params, error) {
+	toy, err := zkpautils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		return nil, err
+	}
+	toy.Name = "toy"
+	toy.Type = zkpautils.GroupTypeSchnorr
+
+	readme, err := zkpautils.ParseParams(
+		"115792089237316195423570985008687907852837564279074904382605163141518161494337",
+		"341948486974166000522343609283189",
+		"74446558554923317135296388588396736831887322850186029432124219757485062736903",
+		"79726485623116979445189935890227226532411986477410367519098002861237945910855")
+	if err != nil {
+		return nil, err
+	}
+	readme.Name = "readme256"
+	readme.Type = zkpautils.GroupTypeSchnorr
+
+	// the group of test/testdata/schnorr2048.json
+	p, err := decimal("26858686365832179492957106619921530860907134585946980951976175255110264559857682610720131932245959771075099391944857972397070634718103835972748692018501961338053408250387661600499862034363909872897739912069286881463948805846349819196139897301039883284172446429743305497981357306167241707155806869807983461107350508313996017618609377007538737490945494049973201856320981939453197127552459569381074487224820985796500802686711770282497435841915825792868123383976381292679037244335703363044198853331936408402223233223542028259534695184847338231352186295796291314804751967562120104343624836793067145129341385548919412360713")
+	if err != nil {
+		return nil, err
+	}
+	q, err := decimal("78663372919503809033023097145787456364631141033790289229561277117855443549299")
+	if err != nil {
+		return nil, err
+	}
+	schnorr, err := zkpautils.DeriveParams(p, q, []byte("zkp_auth test group"))
+	if err != nil {
+		return nil, err
+	}
+	schnorr.Name = "schnorr2048"
+
+	modp, err := zkpautils.StandardGroup("modp2048")
+	if err != nil {
+		return nil, err
+	}
+	return []*zkpautils.Params{toy, readme, schnorr, modp}, nil
+}
+
+func decimal(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a decimal number", s)
+	}
+	return n, nil
+}
+
+// generateVectors returns the test vectors, which are the same every time
+func generateVectors() (*zkpautils.Vectors, error) {
+	groups, err := vectorGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	vs := &zkpautils.Vectors{
+		Version:     zkpautils.VectorsVersion,
+		Description: "Chaum-Pedersen transcripts for zkp_auth, see README.md",
+	}
+	for _, params := range groups {
+		vs.Groups = append(vs.Groups, zkpautils.VectorGroup{Mode: zkpautils.VectorModeModP, Params: params})
+
+		one := big.NewInt(1)
+		qMinusOne := new(big.Int).Sub(params.Q, one)
+		scalar := func(label string) (*big.Int, error) {
+			return zkpautils.RandomScalar(params.Q, newLabelReader(params.Name+" "+label))
+		}
+
+		for _, tc := range []struct {
+			name     string
+			tampered string
+			// the x, k and c of the transcript, picked from the label when nil
+			x, k, c *big.Int
+			// this changes the transcript after s has been worked out
+			tamper func(x, k, c, s *big.Int) (*big.Int, *big.Int)
+		}{
+			{name: "honest 1"},
+			{name: "honest 2"},
+			{name: "honest 3"},
+			{name: "honest edges", x: qMinusOne, k: one, c: qMinusOne},
+			{name: "s plus 1", tampered: "s + 1", tamper: func(x, k, c, s *big.Int) (*big.Int, *big.Int) {
+				return c, new(big.Int).Add(s, one)
+			}},
+			{name: "c plus 1", tampered: "c + 1, s is for c", tamper: func(x, k, c, s *big.Int) (*big.Int, *big.Int) {
+				return new(big.Int).Add(c, one), s
+			}},
+			{name: "wrong x", tampered: "s is for x + 1", tamper: func(x, k, c, s *big.Int) (*big.Int, *big.Int) {
+				return c, zkpautils.CalculateS(k, c, new(big.Int).Add(x, one), params.Q)
+			}},
+			{name: "s plus q", tampered: "s + q", tamper: func(x, k, c, s *big.Int) (*big.Int, *big.Int) {
+				return c, new(big.Int).Add(s, params.Q)
+			}},
+			{name: "x is 0", tampered: "x = 0", x: big.NewInt(0)},
+			{name: "c is 0", tampered: "c = 0", c: big.NewInt(0)},
+		} {
+			var err error
+			x, k, c := tc.x, tc.k, tc.c
+			if x == nil {
+				if x, err = scalar(tc.name + " x"); err != nil {
+					return nil, err
+				}
+			}
+			if k == nil {
+				if k, err = scalar(tc.name + " k"); err != nil {
+					return nil, err
+				}
+			}
+			if c == nil {
+				if c, err = scalar(tc.name + " c"); err != nil {
+					return nil, err
+				}
+			}
+
+			y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+			r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+			s := zkpautils.CalculateS(k, c, x, params.Q)
+			if tc.tamper != nil {
+				c, s = tc.tamper(x, k, c, s)
+			}
+
+			v := zkpautils.Vector{
+				Name:     params.Name + " " + tc.name,
+				Group:    params.Name,
+				Tampered: tc.tampered,
+				X:        x.String(),
+				K:        k.String(),
+				C:        c.String(),
+				Y1:       y1.String(),
+				Y2:       y2.String(),
+				R1:       r1.String(),
+				R2:       r2.String(),
+				S:        s.String(),
+			}
+			v.VerifyProof, v.VerifyError = verifyVector(params, y1, y2, r1, r2, c, s)
+			vs.Vectors = append(vs.Vectors, v)
+		}
+	}
+	return vs, nil
+}
+
+// The results of this code for a transcript, which zkpautils.CheckVectors checks again
+func verifyVector(params *zkpautils.Params, y1, y2, r1, r2, c, s *big.Int) (bool, string) {
+	ok1, _ := zkpautils.VerifyProof(r1, params.G, s, y1, c, params.P)
+	ok2, _ := zkpautils.VerifyProof(r2, params.H, s, y2, c, params.P)
+
+	verifyError := ""
+	if err := zkpautils.VerifyChaumPedersen(params, y1, y2, r1, r2, c, s); err != nil {
+		verifyError = err.Error()
+	}
+	return ok1 && ok2, verifyError
+}
+
+// labelReader is an endless stream of SHA-256(label || counter) blocks, for randomness that is the same every time
+type labelReader struct {
+	label   string
+	counter uint64
+	buf     []byte
+}
+
+func newLabelReader(label string) *labelReader {
+	return &labelReader{label: "zkp_auth test vector " + label}
+}
+
+func (lr *labelReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(lr.buf) == 0 {
+			block := sha256.New()
+			block.Write([]byte(lr.label))
+			binary.Write(block, binary.BigEndian, lr.counter)
+			lr.buf = block.Sum(nil)
+			lr.counter++
+		}
+		copied := copy(p[n:], lr.buf)
+		lr.buf = lr.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
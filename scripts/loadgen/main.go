@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/mischat/zkp_auth/pb"
+	zkpautils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// This script puts load on a running server, to see how many logins a second it can take.
+// It simulates -users users at once, each registers once with a random x and then logs in
+// over and over, -logins times or until -duration is up. At the end it reports the throughput
+// and the latency percentiles of a whole login, that is both round trips and the client's work.
+//
+//	go run ./server -params test/testdata/schnorr2048.json &
+//	go run ./scripts/loadgen -users 32 -duration 30s
+//
+// The users are named after a random run ID, so runs against the same server don't get in each other's way.
+func main() {
+	addrFlag := flag.String("addr", "localhost:50051", "the address of the server")
+	usersFlag := flag.Int("users", 10, "how many users log in at once")
+	loginsFlag := flag.Int("logins", 0, "how many times each user logs in, 0 to log in until -duration is up")
+	durationFlag := flag.Duration("duration", 10*time.Second, "how long to run for when -logins is 0")
+	timeoutFlag := flag.Duration("timeout", 5*time.Second, "how long to wait for each request")
+	flag.Parse()
+
+	if *usersFlag < 1 {
+		log.Fatalf("-users must be at least 1, not '%d'", *usersFlag)
+	}
+	if *loginsFlag < 0 {
+		log.Fatalf("-logins must not be negative, not '%d'", *loginsFlag)
+	}
+	if *loginsFlag == 0 && *durationFlag <= 0 {
+		log.Fatalf("-duration must be positive, not '%v'", *durationFlag)
+	}
+
+	conn, err := grpc.Dial(*addrFlag, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	c := pb.NewAuthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+	resp, err := c.GetPublicParameters(ctx, &pb.PublicParametersRequest{})
+	cancel()
+	if err != nil {
+		log.Fatalf("could not fetch public variables: %v", err)
+	}
+	params, err := zkpautils.ParseParams(resp.GetP(), resp.GetQ(), resp.GetG(), resp.GetH())
+	if err != nil {
+		log.Fatal(err)
+	}
+	arith, err := zkpautils.NewArithmetic(params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runId := make([]byte, 4)
+	if _, err := rand.Read(runId); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "%d users logging in to %s, p is %d bits, fingerprint %s\n", *usersFlag, *addrFlag, params.P.BitLen(), params.Fingerprint())
+
+	lg := &loadgen{c: c, params: params, arith: arith, timeout: *timeoutFlag}
+	deadline := time.Time{}
+	if *loginsFlag == 0 {
+		deadline = time.Now().Add(*durationFlag)
+	}
+
+	results := make([]result, *usersFlag)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := fmt.Sprintf("loadgen-%s-%d@example.com", hex.EncodeToString(runId), i)
+			results[i] = lg.run(user, *loginsFlag, deadline)
+		}(i)
+	}
+	wg.Wait()
+	report(results, time.Since(start))
+}
+
+type loadgen struct {
+	c       pb.AuthClient
+	params  *zkpautils.Params
+	arith   zkpautils.Arithmetic
+	timeout time.Duration
+}
+
+// What one user saw
+type result struct {
+	latencies []time.Duration
+	errors    map[string]int
+	// set when the user couldn't even register
+	err error
+}
+
+// This registers user and logs in logins times, or until deadline when logins is 0
+func (lg *loadgen) run(user string, logins int, deadline time.Time) result {
+	res := result{errors: make(map[string]int)}
+
+	x, err := zkpautils.RandomScalar(lg.params.Q, rand.Reader)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	if err := lg.register(user, x); err != nil {
+		res.err = err
+		return res
+	}
+
+	for i := 0; logins == 0 || i < logins; i++ {
+		if logins == 0 && !time.Now().Before(deadline) {
+			break
+		}
+		start := time.Now()
+		if err := lg.login(user, x); err != nil {
+			res.errors[status.Convert(err).Message()]++
+			continue
+		}
+		res.latencies = append(res.latencies, time.Since(start))
+	}
+	return res
+}
+
+func (lg *loadgen) register(user string, x *big.Int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), lg.timeout)
+	defer cancel()
+
+	y1, y2 := lg.arith.Exp(lg.params.G, x), lg.arith.Exp(lg.params.H, x)
+	_, err := lg.c.Register(ctx, &pb.RegisterRequest{User: user, Y1: y1.String(), Y2: y2.String()})
+	if err != nil {
+		return fmt.Errorf("could not register '%s': %v", user, status.Convert(err).Message())
+	}
+	return nil
+}
+
+// A whole login, with a fresh k every time
+func (lg *loadgen) login(user string, x *big.Int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), lg.timeout)
+	defer cancel()
+
+	k, err := zkpautils.RandomScalar(lg.params.Q, rand.Reader)
+	if err != nil {
+		return err
+	}
+	r1, r2 := lg.arith.Exp(lg.params.G, k), lg.arith.Exp(lg.params.H, k)
+	resp, err := lg.c.CreateAuthenticationChallenge(ctx, &pb.AuthenticationChallengeRequest{User: user, R1: r1.String(), R2: r2.String()})
+	if err != nil {
+		return err
+	}
+	chal, ok := new(big.Int).SetString(resp.GetC(), 10)
+	if !ok {
+		return fmt.Errorf("server sent a challenge that is not a number: '%s'", resp.GetC())
+	}
+	s := lg.arith.CalculateS(k, chal, x)
+	_, err = lg.c.VerifyAuthentication(ctx, &pb.AuthenticationAnswerRequest{AuthId: resp.GetAuthId(), S: s.String()})
+	return err
+}
+
+func report(results []result, elapsed time.Duration) {
+	var latencies []time.Duration
+	errs := make(map[string]int)
+	failed := 0
+	var registerErrs []error
+	for _, res := range results {
+		if res.err != nil {
+			registerErrs = append(registerErrs, res.err)
+			continue
+		}
+		latencies = append(latencies, res.latencies...)
+		for msg, n := range res.errors {
+			errs[msg] += n
+			failed += n
+		}
+	}
+	if len(registerErrs) > 0 {
+		fmt.Printf("%d of %d users could not register:\n%v\n", len(registerErrs), len(results), errors.Join(registerErrs...))
+	}
+
+	fmt.Printf("logins:     %d ok, %d failed in %v\n", len(latencies), failed, elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput: %.1f logins/s\n", float64(len(latencies))/elapsed.Seconds())
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Printf("latency:    p50 %v, p90 %v, p99 %v, max %v\n",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), latencies[len(latencies)-1].Round(time.Microsecond))
+	}
+	for msg, n := range errs {
+		fmt.Printf("  %d x %s\n", n, msg)
+	}
+	if failed > 0 || len(registerErrs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// The nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Microsecond)
+}
@@ -1,16 +1,21 @@
+// Package main runs the Auth service over TCP, the service itself lives in authserver.
 package main
 
 import (
-	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math/big"
 	"net"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/mischat/zkp_auth/authserver"
+	"github.com/mischat/zkp_auth/gateway"
+	"github.com/mischat/zkp_auth/oidc"
 	pb "github.com/mischat/zkp_auth/pb"
 	zkpautils "github.com/mischat/zkp_auth/utils"
 	"google.golang.org/grpc"
@@ -18,212 +23,149 @@ import (
 
 var (
 	portFlag = flag.Int("port", 50051, "The server port")
+	// The same service as HTTP with JSON bodies, for clients that can't speak gRPC
+	httpPortFlag = flag.Int("http-port", 0, "the port of the HTTP/JSON gateway, 0 to not run it")
+	// An OpenID provider next to the gateway, for apps that already speak OpenID Connect
+	issuerFlag      = flag.String("issuer", "", "the URL of the OpenID provider, which needs -http-port, not set to not run it")
+	oidcKeyFlag     = flag.String("oidc-key", "", "the Ed25519 key tokens are signed with as a PKCS #8 PEM file, a new key on every start when not set")
+	oidcClientsFlag = flag.String("oidc-clients", "", "the JSON file of the relying parties that can ask for tokens")
+
+	// How many of each user's commitments we remember, and for how long, to spot replays
+	commitmentHistoryFlag   = flag.Int("commitment-history", authserver.DefaultCommitmentHistory, "the number of (r1, r2) commitments remembered per user")
+	commitmentRetentionFlag = flag.Duration("commitment-retention", authserver.DefaultCommitmentRetention, "how long (r1, r2) commitments are remembered for")
+	auditLogFlag            = flag.String("audit-log", "", "the file audit events are appended to, stderr when not set")
+	// Users without KDF parameters get made up ones, so that GetKdfParameters doesn't tell which accounts exist
+	kdfSecretFlag = flag.String("kdf-secret-file", "", "a file of at least 32 random bytes the made up KDF parameters are derived with, a new secret on every start when not set")
+
+	// Proofs arriving within the window are checked together, which is cheaper when many users log in at once
+	batchWindowFlag = flag.Duration("batch-window", 0, "how long to collect proofs for to verify them as a batch, 0 verifies each proof on its own")
+	batchSizeFlag   = flag.Int("batch-size", authserver.DefaultBatchSize, "the most proofs verified in one batch")
+
+	// Fewer bits make for shorter challenges, but a cheat gets through one login in 2^bits
+	challengeBitsFlag = flag.Int("challenge-bits", 0, "the size of the challenges in bits, 0 uses the whole of [1, q)")
+	// An unanswered challenge is dropped after this long
+	challengeTTLFlag = flag.Duration("challenge-ttl", authserver.DefaultChallengeTTL, "how long a client has to answer a challenge")
 
 	// Public variables needed for the auth system to work
 	pFlag = flag.String("p", "23", "the prime number we start our group")
 	qFlag = flag.String("q", "11", "for prime order calculation")
 	gFlag = flag.String("g", "12", "first number from group")
 	hFlag = flag.String("h", "13", "second number from group")
-
-	p = new(big.Int)
-	q = new(big.Int)
-	g = new(big.Int)
-	h = new(big.Int)
+	// Or all four of them from a file made by scripts/gennumbers
+	paramsFlag = flag.String("params", "", "a parameter file to read p, q, g and h from, instead of the flags")
+	// Or one of the built-in groups
+	groupFlag = flag.String("group", "", "a standard group to use instead of the flags, one of "+strings.Join(zkpautils.StandardGroupNames(), ", "))
+	// The toy policy lets the small default group through, use default or strict for anything real
+	policyFlag = flag.String("policy", "toy", "how big the group must be, one of toy, default or strict")
 )
 
-// server is used to implement zkp_auth.server
-type server struct {
-	pb.UnimplementedAuthServer
-	userRegData map[string]UserRegistration
-	// This datastructure doesn't have any timeout in place
-	// One for the future, would be good for these not to be valid for long
-	authenticationData map[string]Authentication
-
-	sessionData map[string]Session
-}
+func main() {
+	flag.Parse()
 
-func newServer() *server {
-	return &server{
-		userRegData:        make(map[string]UserRegistration),
-		authenticationData: make(map[string]Authentication),
-		sessionData:        make(map[string]Session),
+	// reading the public variables from the parameter file or the standard groups, or else from the flags
+	var params *zkpautils.Params
+	var err error
+	switch {
+	case *paramsFlag != "":
+		params, err = zkpautils.ReadParamsFile(*paramsFlag)
+	case *groupFlag != "":
+		params, err = zkpautils.StandardGroup(*groupFlag)
+	default:
+		params, err = zkpautils.ParseParams(*pFlag, *qFlag, *gFlag, *hFlag)
 	}
-}
-
-// This stores the user registration data against the user ID
-type UserRegistration struct {
-	y1 *big.Int
-	y2 *big.Int
-}
-
-// This stores the authentication data against the auth ID
-type Authentication struct {
-	user string
-	r1   *big.Int
-	r2   *big.Int
-	c    *big.Int
-}
-
-// This stores the session data against the session ID
-type Session struct {
-	user      string
-	createdAt time.Time
-}
-
-// Generate a random string of length n
-// We will use these for string IDs
-func randomString(n int) string {
-	b := make([]byte, n)
-	_, err := rand.Read(b)
 	if err != nil {
-		panic(err)
+		log.Fatalf("could not read public variables: %v", err)
+	}
+	if params.Name != "" {
+		log.Printf("using parameter set '%s'", params.Name)
 	}
-	return base64.URLEncoding.EncodeToString(b)[:n]
-}
-
-// This implements the Register gRPC call
-// Note that this implementation does not support updating a user's registration info
-func (srv *server) Register(ctx context.Context, in *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	log.Printf("Received UserID: %v", in.GetUser())
-	log.Printf("Received Y1: %v", in.GetY1())
-	log.Printf("Received Y2: %v", in.GetY2())
-
-	y1 := new(big.Int)
-	y1.SetString(in.GetY1(), 10)
 
-	y2 := new(big.Int)
-	y2.SetString(in.GetY2(), 10)
+	log.Printf("p: %v q: %v g: %v h: %v\n", params.P, params.Q, params.G, params.H)
+	// Clients compare this with their own, or pin it with -fingerprint
+	log.Printf("parameter fingerprint: %s", params.Fingerprint())
 
-	// Retrieve User from the map
-	_, exists := srv.userRegData[in.GetUser()]
-	if exists {
-		return &pb.RegisterResponse{}, fmt.Errorf("user '%v' already exists", in.GetUser())
+	// This makes sure that we validate the public variables passed in
+	policy, err := zkpautils.PolicyByName(*policyFlag)
+	if err != nil {
+		log.Fatal(err)
 	}
-	// TODO: validate that y1 and y2 are in the group
-
-	// Store Y1 and Y2 in the userRegData map
-	srv.userRegData[in.GetUser()] = UserRegistration{
-		y1: y1,
-		y2: y2,
+	if report := zkpautils.ValidateParams(params, policy); !report.Valid() {
+		log.Fatalf("could not validate public variables:\n%v", report)
 	}
-
-	log.Printf("Stored UserID: %v", in.GetUser())
-
-	return &pb.RegisterResponse{}, nil
-}
-
-// This is the second step in the authentication process
-// The client will send the r1 and r2 values based on a random value k
-func (srv *server) CreateAuthenticationChallenge(ctx context.Context, in *pb.AuthenticationChallengeRequest) (*pb.AuthenticationChallengeResponse, error) {
-	log.Printf("Received UserID: %v", in.GetUser())
-	log.Printf("Received R1: %v", in.GetR1())
-	log.Printf("Received R2: %v", in.GetR2())
-
-	// Retrieve User from the map
-	_, exists := srv.userRegData[in.GetUser()]
-	if !exists {
-		return &pb.AuthenticationChallengeResponse{}, fmt.Errorf("user doesn't exists")
+	if report := zkpautils.ValidateParams(params, zkpautils.PolicyDefault); !report.Valid() {
+		log.Printf("WARNING: the public variables are not safe to use outside of testing: %v", report.Err())
 	}
+	// The config is now validated and in good shape
 
-	r1 := new(big.Int)
-	r1.SetString(in.GetR1(), 10)
-	r2 := new(big.Int)
-	r2.SetString(in.GetR2(), 10)
-
-	// TODO: write this into the README file
-	// Now the challenger picks a random value c
-	// it is important that these are unique for each user
-	// ideally we store the used ones somewhere, like in an associative array or something.
-	// but for this excercise we will just generate a new random one each time
-	// using a big(ish) number to ensure some randomness
-	c := zkpautils.RandomBigInt()
-	log.Printf("Generated random c: %d", c)
-
-	// Store c in the authenticationmap
-	// Note that this will only allow for a user to authenticate in one place at a time
-	authId := randomString(20)
-
-	srv.authenticationData[authId] = Authentication{
-		user: in.GetUser(),
-		r1:   r1,
-		r2:   r2,
-		c:    c,
+	if *commitmentHistoryFlag < 1 {
+		log.Fatalf("-commitment-history must be at least 1")
 	}
-
-	return &pb.AuthenticationChallengeResponse{AuthId: authId, C: c.String()}, nil
-
-}
-
-// This is the third step in the authentication process
-// This is where the verifier proofs authentication with no knowledge of the secret x
-func (srv *server) VerifyAuthentication(ctx context.Context, in *pb.AuthenticationAnswerRequest) (*pb.AuthenticationAnswerResponse, error) {
-	log.Printf("Received AuthID: %v", in.GetAuthId())
-	log.Printf("Received S: %v", in.GetS())
-
-	s := new(big.Int)
-	s.SetString(in.GetS(), 10)
-	// Retrieve Auth object from map
-	auth, exists := srv.authenticationData[in.GetAuthId()]
-	if !exists {
-		return &pb.AuthenticationAnswerResponse{}, fmt.Errorf("authId doesn't exists: %v", in.GetAuthId())
+	if *batchSizeFlag < 1 {
+		log.Fatalf("-batch-size must be at least 1")
 	}
 
-	// Retrieve User from the map
-	user, exists := srv.userRegData[auth.user]
-	if !exists {
-		return &pb.AuthenticationAnswerResponse{}, fmt.Errorf("user doesn't exists: %v", auth.user)
+	var audit io.Writer = os.Stderr
+	if *auditLogFlag != "" {
+		f, err := os.OpenFile(*auditLogFlag, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("could not open audit log: %v", err)
+		}
+		defer f.Close()
+		audit = f
 	}
 
-	// Now we have all the data we need to validate the proof
-	// Now the verifier needs to verify the proof
-	// r1 = g^s . y1^c mod p
-	_, err := zkpautils.VerifyProof(auth.r1, g, s, user.y1, auth.c, p)
-	if err != nil {
-		return &pb.AuthenticationAnswerResponse{}, fmt.Errorf("r1 does not match: %v", err)
+	var kdfSecret []byte
+	if *kdfSecretFlag != "" {
+		if kdfSecret, err = os.ReadFile(*kdfSecretFlag); err != nil {
+			log.Fatalf("could not read the kdf secret: %v", err)
+		}
 	}
 
-	// r2 = h^s . y2^c mod p
-	_, err = zkpautils.VerifyProof(auth.r2, h, s, user.y2, auth.c, p)
+	srv, err := authserver.New(authserver.Config{
+		Params:              params,
+		ChallengeBits:       *challengeBitsFlag,
+		ChallengeTTL:        *challengeTTLFlag,
+		CommitmentHistory:   *commitmentHistoryFlag,
+		CommitmentRetention: *commitmentRetentionFlag,
+		BatchWindow:         *batchWindowFlag,
+		BatchSize:           *batchSizeFlag,
+		AuditLog:            audit,
+		KdfSecret:           kdfSecret,
+	})
 	if err != nil {
-		return &pb.AuthenticationAnswerResponse{}, fmt.Errorf("r2 does not match: %v", err)
+		log.Fatal(err)
 	}
+	defer srv.Close()
+	log.Printf("challenges are picked from [1, %d)", srv.Challenges().Bound)
 
-	log.Println("Proof verified!")
-
-	// Now we mint a sessionID
-	sessionId := randomString(20)
-
-	// Now we store the sessionID against the user, with a createdAt timestamp
-	// for the future
-	srv.sessionData[sessionId] = Session{
-		user:      auth.user,
-		createdAt: time.Now(),
+	if *issuerFlag != "" && *httpPortFlag == 0 {
+		log.Fatalf("-issuer needs -http-port")
 	}
-
-	// This deletes the old authentication data object
-	// as we don't want to use it again.
-	delete(srv.authenticationData, in.GetAuthId())
-
-	return &pb.AuthenticationAnswerResponse{SessionId: sessionId}, nil
-}
-
-func main() {
-	flag.Parse()
-
-	// creating bigInts from the flags
-	p.SetString(*pFlag, 10)
-	q.SetString(*qFlag, 10)
-	g.SetString(*gFlag, 10)
-	h.SetString(*hFlag, 10)
-
-	log.Printf("p: %v q: %v g: %v h: %v\n", p, q, g, h)
-
-	// This makes sure that we validate the public variables passed in
-	_, err := zkpautils.ValidatePublicVariables(p, q, g, h)
-	if err != nil {
-		log.Fatalf("could not validate public variables: %v", err)
+	if *httpPortFlag != 0 {
+		gw, err := gateway.New(srv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpLis, err := net.Listen("tcp", fmt.Sprintf(":%d", *httpPortFlag))
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		log.Printf("HTTP gateway listening at %v", httpLis.Addr())
+		mux := http.NewServeMux()
+		mux.Handle("/", gw)
+		if *issuerFlag != "" {
+			provider, err := newProvider(srv)
+			if err != nil {
+				log.Fatalf("could not start the OpenID provider: %v", err)
+			}
+			provider.Register(mux)
+			log.Printf("OpenID provider at %s", *issuerFlag)
+		}
+		httpSrv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			log.Fatalf("failed to serve HTTP: %v", httpSrv.Serve(httpLis))
+		}()
 	}
-	// The config is now validated and in good shape
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *portFlag))
 	if err != nil {
@@ -231,10 +173,33 @@ func main() {
 	}
 
 	s := grpc.NewServer()
-	pb.RegisterAuthServer(s, newServer())
+	pb.RegisterAuthServer(s, srv)
 	log.Printf("server listening at %v", lis.Addr())
 
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
+
+// This sets up the OpenID provider from the flags
+func newProvider(srv *authserver.Server) (*oidc.Provider, error) {
+	var key ed25519.PrivateKey
+	var err error
+	if *oidcKeyFlag != "" {
+		key, err = oidc.ReadKeyFile(*oidcKeyFlag)
+	} else {
+		log.Printf("WARNING: no -oidc-key, tokens are signed with a new key that is lost on restart")
+		key, err = oidc.GenerateKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []oidc.Client
+	if *oidcClientsFlag != "" {
+		if clients, err = oidc.ReadClientsFile(*oidcClientsFlag); err != nil {
+			return nil, err
+		}
+	}
+	return oidc.New(oidc.Config{Issuer: *issuerFlag, Key: key, Clients: clients, Auth: srv})
+}
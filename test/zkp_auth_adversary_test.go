@@ -0,0 +1,336 @@
+package utils_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/mischat/zkp_auth/authserver"
+	pb "github.com/mischat/zkp_auth/pb"
+	zkutils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc/status"
+)
+
+// These are clients that try to log in without following the protocol, or without knowing x.
+// Every one of them has to be turned away, with the reason the server gives for it.
+
+// commit sends a chosen r1 and r2, and returns the auth ID and the challenge
+func commit(c pb.AuthClient, user string, r1, r2 *big.Int) (string, *big.Int, error) {
+	resp, err := c.CreateAuthenticationChallenge(context.Background(), &pb.AuthenticationChallengeRequest{User: user, R1: r1.String(), R2: r2.String()})
+	if err != nil {
+		return "", nil, err
+	}
+	chal, ok := new(big.Int).SetString(resp.GetC(), 10)
+	if !ok {
+		return "", nil, fmt.Errorf("c is not a number: '%s'", resp.GetC())
+	}
+	return resp.GetAuthId(), chal, nil
+}
+
+// forge makes a proof for y1 and y2 without x, for a challenge picked before committing:
+// with s picked first, r1 = g^s . y1^c and r2 = h^s . y2^c satisfy the equations
+func forge(t testing.TB, params *zkutils.Params, y1, y2, chal *big.Int) (*big.Int, *big.Int, *big.Int) {
+	s, err := zkutils.RandomScalar(params.Q, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1 := mulModP(params, new(big.Int).Exp(params.G, s, params.P), new(big.Int).Exp(y1, chal, params.P))
+	r2 := mulModP(params, new(big.Int).Exp(params.H, s, params.P), new(big.Int).Exp(y2, chal, params.P))
+	return r1, r2, s
+}
+
+func mulModP(params *zkutils.Params, a, b *big.Int) *big.Int {
+	z := new(big.Int).Mul(a, b)
+	return z.Mod(z, params.P)
+}
+
+// An adversary knows alice's y1 and y2, and x when the attack is a broken client rather than an impostor
+type adversary struct {
+	c      pb.AuthClient
+	params *zkutils.Params
+	x      *big.Int
+	y1, y2 *big.Int
+}
+
+func newAdversary(t testing.TB, cfg authserver.Config) *adversary {
+	c := startServer(t, cfg)
+	x := nonZeroScalar(t, cfg.Params.Q)
+	register(t, c, cfg.Params, "alice@example.com", x)
+	return &adversary{
+		c:      c,
+		params: cfg.Params,
+		x:      x,
+		y1:     new(big.Int).Exp(cfg.Params.G, x, cfg.Params.P),
+		y2:     new(big.Int).Exp(cfg.Params.H, x, cfg.Params.P),
+	}
+}
+
+// honest returns a fresh k with its r1 and r2
+func (a *adversary) honest(t testing.TB) (*big.Int, *big.Int, *big.Int) {
+	k := nonZeroScalar(t, a.params.Q)
+	return k, new(big.Int).Exp(a.params.G, k, a.params.P), new(big.Int).Exp(a.params.H, k, a.params.P)
+}
+
+// This commits to r1 and r2 and answers with s(c), returning the error of whichever step failed
+func (a *adversary) login(r1, r2 *big.Int, s func(c *big.Int) *big.Int) error {
+	authId, chal, err := commit(a.c, "alice@example.com", r1, r2)
+	if err != nil {
+		return err
+	}
+	_, err = answer(a.c, authId, s(chal))
+	return err
+}
+
+func TestMaliciousProvers(t *testing.T) {
+	params := serverParams(t)
+	one := big.NewInt(1)
+	pMinusOne := new(big.Int).Sub(params.P, one)
+
+	for _, tc := range []struct {
+		name string
+		// the error the server has to answer with
+		expected string
+		attack   func(t *testing.T, a *adversary) error
+	}{
+		{"r1 of order 2", zkutils.ErrR1Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			k, _, r2 := a.honest(t)
+			return a.login(pMinusOne, r2, func(c *big.Int) *big.Int { return zkutils.CalculateS(k, c, a.x, a.params.Q) })
+		}},
+		{"r2 outside of the subgroup", zkutils.ErrR2Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			k, r1, r2 := a.honest(t)
+			return a.login(r1, mulModP(a.params, r2, pMinusOne), func(c *big.Int) *big.Int { return zkutils.CalculateS(k, c, a.x, a.params.Q) })
+		}},
+		{"r1 is 0", zkutils.ErrR1OutOfRange.Error(), func(t *testing.T, a *adversary) error {
+			_, _, r2 := a.honest(t)
+			return a.login(big.NewInt(0), r2, func(c *big.Int) *big.Int { return big.NewInt(0) })
+		}},
+		{"r2 is p", zkutils.ErrR2OutOfRange.Error(), func(t *testing.T, a *adversary) error {
+			_, r1, _ := a.honest(t)
+			return a.login(r1, a.params.P, func(c *big.Int) *big.Int { return big.NewInt(0) })
+		}},
+		{"r1 is g^k + p", zkutils.ErrR1OutOfRange.Error(), func(t *testing.T, a *adversary) error {
+			k, r1, r2 := a.honest(t)
+			return a.login(new(big.Int).Add(r1, a.params.P), r2, func(c *big.Int) *big.Int { return zkutils.CalculateS(k, c, a.x, a.params.Q) })
+		}},
+		{"s is 0", zkutils.ErrR1Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			_, r1, r2 := a.honest(t)
+			return a.login(r1, r2, func(c *big.Int) *big.Int { return big.NewInt(0) })
+		}},
+		{"s is negative", zkutils.ErrResponseOutOfRange.Error(), func(t *testing.T, a *adversary) error {
+			// s - q passes the equations, as g has order q
+			k, r1, r2 := a.honest(t)
+			return a.login(r1, r2, func(c *big.Int) *big.Int {
+				return new(big.Int).Sub(zkutils.CalculateS(k, c, a.x, a.params.Q), a.params.Q)
+			})
+		}},
+		{"s is q or more", zkutils.ErrResponseOutOfRange.Error(), func(t *testing.T, a *adversary) error {
+			k, r1, r2 := a.honest(t)
+			return a.login(r1, r2, func(c *big.Int) *big.Int {
+				return new(big.Int).Add(zkutils.CalculateS(k, c, a.x, a.params.Q), a.params.Q)
+			})
+		}},
+		{"reused k", "commitment has been used before", func(t *testing.T, a *adversary) error {
+			// with two answers for the same k, x = (s1 - s2) / (c2 - c1) mod q, so the second challenge is never handed out
+			k, r1, r2 := a.honest(t)
+			s := func(c *big.Int) *big.Int { return zkutils.CalculateS(k, c, a.x, a.params.Q) }
+			if err := a.login(r1, r2, s); err != nil {
+				t.Fatalf("the first login failed: %v", err)
+			}
+			return a.login(r1, r2, s)
+		}},
+		{"replayed login", "commitment has been used before", func(t *testing.T, a *adversary) error {
+			// an eavesdropper has r1, r2 and s of one of alice's logins, but the new c won't match s
+			k, r1, r2 := a.honest(t)
+			var seen *big.Int
+			if err := a.login(r1, r2, func(c *big.Int) *big.Int { seen = zkutils.CalculateS(k, c, a.x, a.params.Q); return seen }); err != nil {
+				t.Fatalf("alice's login failed: %v", err)
+			}
+			return a.login(r1, r2, func(c *big.Int) *big.Int { return seen })
+		}},
+		{"wrong h", zkutils.ErrR2Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			// a client using g in place of h
+			k, r1, _ := a.honest(t)
+			r2 := new(big.Int).Exp(a.params.G, k, a.params.P)
+			return a.login(r1, r2, func(c *big.Int) *big.Int { return zkutils.CalculateS(k, c, a.x, a.params.Q) })
+		}},
+		{"registered with the wrong h", zkutils.ErrR2Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			// y2 = g^x proves nothing about log_h y2, however consistently the client uses g
+			y := new(big.Int).Exp(a.params.G, a.x, a.params.P)
+			if _, err := a.c.Register(context.Background(), &pb.RegisterRequest{User: "mallory@example.com", Y1: y.String(), Y2: y.String()}); err != nil {
+				t.Fatal(err)
+			}
+			k, r1, _ := a.honest(t)
+			authId, chal, err := commit(a.c, "mallory@example.com", r1, r1)
+			if err != nil {
+				return err
+			}
+			_, err = answer(a.c, authId, zkutils.CalculateS(k, chal, a.x, a.params.Q))
+			return err
+		}},
+		{"swapped y1 and y2", zkutils.ErrR1Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			if _, err := a.c.Register(context.Background(), &pb.RegisterRequest{User: "mallory@example.com", Y1: a.y2.String(), Y2: a.y1.String()}); err != nil {
+				t.Fatal(err)
+			}
+			// and the commitment swapped to match
+			k, r1, r2 := a.honest(t)
+			authId, chal, err := commit(a.c, "mallory@example.com", r2, r1)
+			if err != nil {
+				return err
+			}
+			_, err = answer(a.c, authId, zkutils.CalculateS(k, chal, a.x, a.params.Q))
+			return err
+		}},
+		{"forged for a guessed c", zkutils.ErrR1Mismatch.Error(), func(t *testing.T, a *adversary) error {
+			guess := nonZeroScalar(t, a.params.Q)
+			r1, r2, s := forge(t, a.params, a.y1, a.y2, guess)
+			if err := zkutils.VerifyChaumPedersen(a.params, a.y1, a.y2, r1, r2, guess, s); err != nil {
+				t.Fatalf("the forgery doesn't even verify for its own c: %v", err)
+			}
+			return a.login(r1, r2, func(c *big.Int) *big.Int { return s })
+		}},
+		{"x = 0", zkutils.ErrY1OutOfRange.Error(), func(t *testing.T, a *adversary) error {
+			// y1 = y2 = 1 can be "proved" by anyone with r = 1, s = 0
+			_, err := a.c.Register(context.Background(), &pb.RegisterRequest{User: "mallory@example.com", Y1: "1", Y2: "1"})
+			return err
+		}},
+		{"y1 of order 2", zkutils.ErrY1NotInSubgroup.Error(), func(t *testing.T, a *adversary) error {
+			_, err := a.c.Register(context.Background(), &pb.RegisterRequest{User: "mallory@example.com", Y1: pMinusOne.String(), Y2: a.y2.String()})
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newAdversary(t, authserver.Config{Params: params})
+			err := tc.attack(t, a)
+			if err == nil {
+				t.Fatal("the server let the attack through")
+			}
+			if msg := status.Convert(err).Message(); !strings.Contains(msg, tc.expected) {
+				t.Errorf("the server refused with '%s', expected '%s'", msg, tc.expected)
+			}
+		})
+	}
+}
+
+// A forger who guesses c gets through once in as many challenges as there are,
+// so with the whole of [1, q) never, and with 2 bit challenges about a third of the time
+func TestForgerNeedsToGuessChallenge(t *testing.T) {
+	params := serverParams(t)
+
+	for _, tc := range []struct {
+		name     string
+		bits     int
+		min, max int
+	}{
+		{"challenges in [1, q)", 0, 0, 0},
+		{"2 bit challenges", 2, 1, 45},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newAdversary(t, authserver.Config{Params: params, ChallengeBits: tc.bits})
+			// the forger's best guess is any challenge from the space
+			guess := big.NewInt(1)
+
+			const attempts = 60
+			forged := 0
+			for i := 0; i < attempts; i++ {
+				r1, r2, s := forge(t, a.params, a.y1, a.y2, guess)
+				if a.login(r1, r2, func(c *big.Int) *big.Int { return s }) == nil {
+					forged++
+				}
+			}
+			if forged < tc.min || forged > tc.max {
+				t.Errorf("%d of %d forgeries got through, expected between %d and %d", forged, attempts, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+// Mallory has stolen alice's session, but doesn't know x. None of the ways she might try
+// to rotate alice's secret to one of her own works, and alice's rotation ends the stolen session.
+func TestRotateWithStolenSession(t *testing.T) {
+	params := serverParams(t)
+	a := newAdversary(t, authserver.Config{Params: params})
+	stolen, err := login(a.c, params, "alice@example.com", a.x)
+	if err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+	mallory := nonZeroScalar(t, params.Q)
+	register(t, a.c, params, "mallory@example.com", mallory)
+	y1, y2 := new(big.Int).Exp(params.G, mallory, params.P), new(big.Int).Exp(params.H, mallory, params.P)
+
+	steal := func(authId string, s *big.Int) error {
+		_, err := a.c.Rotate(context.Background(), &pb.RotateRequest{SessionId: stolen, Y1: y1.String(), Y2: y2.String(), AuthId: authId, S: s.String()})
+		return err
+	}
+
+	for _, tc := range []struct {
+		name string
+		// the error the server has to answer with
+		expected string
+		attack   func(t *testing.T) error
+	}{
+		{"no proof", "authId doesn't exists", func(t *testing.T) error {
+			return steal("", big.NewInt(0))
+		}},
+		{"a proof for her own account", "the challenge is for another user", func(t *testing.T) error {
+			authId, chal, k, err := challenge(a.c, params, "mallory@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return steal(authId, zkutils.CalculateS(k, chal, mallory, params.Q))
+		}},
+		{"a proof with her own x", zkutils.ErrR1Mismatch.Error(), func(t *testing.T) error {
+			authId, chal, k, err := challenge(a.c, params, "alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return steal(authId, zkutils.CalculateS(k, chal, mallory, params.Q))
+		}},
+		{"a forged proof", zkutils.ErrR1Mismatch.Error(), func(t *testing.T) error {
+			r1, r2, s := forge(t, params, a.y1, a.y2, big.NewInt(1))
+			authId, _, err := commit(a.c, "alice@example.com", r1, r2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return steal(authId, s)
+		}},
+		{"a replayed login of alice's", "authId doesn't exists", func(t *testing.T) error {
+			authId, chal, k, err := challenge(a.c, params, "alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := zkutils.CalculateS(k, chal, a.x, params.Q)
+			if _, err := answer(a.c, authId, s); err != nil {
+				t.Fatal(err)
+			}
+			return steal(authId, s)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.attack(t)
+			if err == nil {
+				t.Fatal("rotated alice's secret with a stolen session")
+			}
+			if msg := status.Convert(err).Message(); !strings.Contains(msg, tc.expected) {
+				t.Errorf("the server refused with '%s', expected '%s'", msg, tc.expected)
+			}
+			if _, err := login(a.c, params, "alice@example.com", a.x); err != nil {
+				t.Errorf("alice can't log in any more: %v", err)
+			}
+		})
+	}
+
+	// alice notices and rotates from another session, which ends the stolen one
+	sessionId, err := login(a.c, params, "alice@example.com", a.x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rotate(a.c, params, sessionId, a.x, nonZeroScalar(t, params.Q)); err != nil {
+		t.Fatalf("alice could not rotate: %v", err)
+	}
+	if _, err := a.c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: stolen}); err == nil {
+		t.Error("the stolen session outlived alice's rotation")
+	}
+}
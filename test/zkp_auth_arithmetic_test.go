@@ -0,0 +1,210 @@
+package utils_test
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// The groups the backends are compared on, from one limb up to 2048 bits
+func arithmeticGroups(t testing.TB) []*zkutils.Params {
+	toy := &zkutils.Params{P: big.NewInt(23), Q: big.NewInt(11), G: big.NewInt(4), H: big.NewInt(9)}
+	schnorr, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modp, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*zkutils.Params{toy, schnorr, modp}
+}
+
+// The secrets worth checking on top of random ones: the ends of [0, q), and some outside of it
+func edgeScalars(q *big.Int) []*big.Int {
+	return []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		new(big.Int).Sub(q, big.NewInt(1)),
+		new(big.Int).Set(q),
+		new(big.Int).Add(new(big.Int).Lsh(q, 3), big.NewInt(5)),
+		big.NewInt(-1),
+		new(big.Int).Neg(q),
+	}
+}
+
+func TestArithmeticExp(t *testing.T) {
+	for _, params := range arithmeticGroups(t) {
+		ct, err := zkutils.NewArithmeticByName(params, zkutils.ArithmeticConstantTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bg, err := zkutils.NewArithmeticByName(params, zkutils.ArithmeticBig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exps := edgeScalars(params.Q)
+		for i := 0; i < 10; i++ {
+			e, err := rand.Int(rand.Reader, params.Q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exps = append(exps, e)
+		}
+
+		for _, base := range []*big.Int{params.G, params.H} {
+			for _, e := range exps {
+				// g and h have order q, so g^e = g^(e mod q) for every e, negative ones included
+				expected := new(big.Int).Exp(base, new(big.Int).Mod(e, params.Q), params.P)
+				if got := ct.Exp(base, e); got.Cmp(expected) != 0 {
+					t.Errorf("constant-time Exp(%d, %d) mod %d = %d, expected %d", base, e, params.P, got, expected)
+				}
+				if got := bg.Exp(base, e); got.Cmp(expected) != 0 {
+					t.Errorf("big Exp(%d, %d) mod %d = %d, expected %d", base, e, params.P, got, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestArithmeticCalculateS(t *testing.T) {
+	for _, params := range arithmeticGroups(t) {
+		ct, err := zkutils.NewArithmeticByName(params, zkutils.ArithmeticConstantTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := edgeScalars(params.Q)
+		for i := 0; i < 5; i++ {
+			v, err := rand.Int(rand.Reader, params.Q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+
+		for _, k := range values {
+			for _, c := range values {
+				for _, x := range values {
+					expected := zkutils.CalculateS(k, c, x, params.Q)
+					if got := ct.CalculateS(k, c, x); got.Cmp(expected) != 0 {
+						t.Fatalf("constant-time CalculateS(%d, %d, %d) mod %d = %d, expected %d", k, c, x, params.Q, got, expected)
+					}
+				}
+			}
+		}
+	}
+}
+
+// A proof made with the constant-time backend has to verify like any other
+func TestArithmeticProofVerifies(t *testing.T) {
+	for _, params := range arithmeticGroups(t)[1:] {
+		ct, err := zkutils.NewArithmeticByName(params, zkutils.ArithmeticConstantTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		x, k, c := nonZeroScalar(t, params.Q), nonZeroScalar(t, params.Q), nonZeroScalar(t, params.Q)
+
+		y1, y2 := ct.Exp(params.G, x), ct.Exp(params.H, x)
+		r1, r2 := ct.Exp(params.G, k), ct.Exp(params.H, k)
+		s := ct.CalculateS(k, c, x)
+		if err := zkutils.VerifyChaumPedersen(params, y1, y2, r1, r2, c, s); err != nil {
+			t.Errorf("a proof from the constant-time backend did not verify: %v", err)
+		}
+	}
+}
+
+// This returns a random number in [1, q)
+func nonZeroScalar(t testing.TB, q *big.Int) *big.Int {
+	v, err := rand.Int(rand.Reader, new(big.Int).Sub(q, big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.Add(v, big.NewInt(1))
+}
+
+func TestArithmeticByName(t *testing.T) {
+	params := arithmeticGroups(t)[0]
+
+	if _, err := zkutils.NewArithmeticByName(params, "fast"); err == nil {
+		t.Error("an unknown backend was accepted")
+	}
+
+	// Montgomery multiplication needs an odd modulus
+	even := &zkutils.Params{P: big.NewInt(24), Q: big.NewInt(11), G: big.NewInt(4), H: big.NewInt(9)}
+	if _, err := zkutils.NewArithmeticByName(even, zkutils.ArithmeticConstantTime); err == nil {
+		t.Error("the constant-time backend accepted an even p")
+	}
+
+	// an empty name is math/big
+	params.Arithmetic = ""
+	if _, err := zkutils.NewArithmetic(params); err != nil {
+		t.Errorf("the default backend was refused: %v", err)
+	}
+}
+
+func TestArithmeticInParamsFile(t *testing.T) {
+	params, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.Arithmetic = zkutils.ArithmeticConstantTime
+
+	data, err := zkutils.MarshalParams(params, zkutils.ParamsFormatPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := zkutils.UnmarshalParams(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Arithmetic != zkutils.ArithmeticConstantTime {
+		t.Errorf("the arithmetic came back as '%s', expected '%s'", parsed.Arithmetic, zkutils.ArithmeticConstantTime)
+	}
+	// the backend is not part of the group, so it doesn't change the fingerprint
+	if parsed.Fingerprint() != params.Fingerprint() {
+		t.Error("the arithmetic changed the fingerprint")
+	}
+
+	// and it isn't written at all when not set
+	params.Arithmetic = ""
+	data, err = json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["arithmetic"]; ok {
+		t.Error("an empty arithmetic was written to the file")
+	}
+}
+
+func BenchmarkArithmeticExp(b *testing.B) {
+	params, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		b.Fatal(err)
+	}
+	x, err := rand.Int(rand.Reader, params.Q)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range []string{zkutils.ArithmeticBig, zkutils.ArithmeticConstantTime} {
+		arith, err := zkutils.NewArithmeticByName(params, name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				arith.Exp(params.G, x)
+			}
+		})
+	}
+}
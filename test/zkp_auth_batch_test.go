@@ -0,0 +1,196 @@
+package utils_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// This makes an honest proof for a random x
+func makeProof(t testing.TB, params *zkutils.Params) zkutils.BatchProof {
+	// x is never 0, otherwise y1 = y2 = 1 and any c verifies
+	x, err := rand.Int(rand.Reader, new(big.Int).Sub(params.Q, big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.Add(x, big.NewInt(1))
+	k, err := rand.Int(rand.Reader, params.Q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := rand.Int(rand.Reader, new(big.Int).Sub(params.Q, big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add(c, big.NewInt(1))
+
+	return zkutils.BatchProof{
+		Y1: new(big.Int).Exp(params.G, x, params.P),
+		Y2: new(big.Int).Exp(params.H, x, params.P),
+		R1: new(big.Int).Exp(params.G, k, params.P),
+		R2: new(big.Int).Exp(params.H, k, params.P),
+		C:  c,
+		S:  zkutils.CalculateS(k, c, x, params.Q),
+	}
+}
+
+func makeProofs(t testing.TB, params *zkutils.Params, n int) []zkutils.BatchProof {
+	proofs := make([]zkutils.BatchProof, n)
+	for i := range proofs {
+		proofs[i] = makeProof(t, params)
+	}
+	return proofs
+}
+
+func TestMultiExp(t *testing.T) {
+	params, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 1, 2, 7} {
+		bases := make([]*big.Int, n)
+		exps := make([]*big.Int, n)
+		expected := big.NewInt(1)
+		for i := range bases {
+			bases[i], _ = rand.Int(rand.Reader, params.P)
+			// exponents of different lengths, including 0
+			exps[i], _ = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(i*40)))
+			expected.Mul(expected, new(big.Int).Exp(bases[i], exps[i], params.P)).Mod(expected, params.P)
+		}
+
+		result, err := zkutils.MultiExp(bases, exps, params.P)
+		if err != nil {
+			t.Fatalf("MultiExp() returned error: %v", err)
+		}
+		if result.Cmp(expected) != 0 {
+			t.Errorf("MultiExp() of %d bases = %d, expected %d", n, result, expected)
+		}
+	}
+
+	if _, err := zkutils.MultiExp([]*big.Int{big.NewInt(2)}, []*big.Int{big.NewInt(-1)}, params.P); err == nil {
+		t.Errorf("MultiExp() with a negative exponent returned no error")
+	}
+	if _, err := zkutils.MultiExp([]*big.Int{big.NewInt(2)}, nil, params.P); err == nil {
+		t.Errorf("MultiExp() with fewer exponents than bases returned no error")
+	}
+}
+
+func TestBatchVerify(t *testing.T) {
+	params, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !zkutils.CanBatch(params) {
+		t.Fatal("modp2048 can't be batched")
+	}
+
+	proofs := makeProofs(t, params, 8)
+	errs, combined := zkutils.BatchVerify(params, proofs, nil)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("BatchVerify() proof %d = %v, expected nil", i, err)
+		}
+	}
+	if !combined {
+		t.Error("BatchVerify() checked honest proofs one by one, expected the combined check")
+	}
+
+	// one wrong s is pinpointed, and the others still verify
+	proofs[3].S = new(big.Int).Add(proofs[3].S, big.NewInt(1))
+	// r2 off by an element outside of the subgroup, which random weights could cancel out
+	proofs[5].R2 = new(big.Int).Sub(params.P, proofs[5].R2)
+	errs, combined = zkutils.BatchVerify(params, proofs, nil)
+	for i, err := range errs {
+		if (i == 3 || i == 5) != (err != nil) {
+			t.Errorf("BatchVerify() proof %d = %v", i, err)
+		}
+	}
+	if combined {
+		t.Error("BatchVerify() accepted a batch with a wrong proof in it")
+	}
+}
+
+func TestBatchVerifyOneByOne(t *testing.T) {
+	// a Schnorr group, where subgroup membership is expensive, and a toy group, where q is smaller
+	// than the weights, are checked one proof at a time
+	schnorr, err := zkutils.DeriveParams(
+		bigFromString("115792089237316195423570985008687907852837564279074904382605163141518161494337"),
+		bigFromString("341948486974166000522343609283189"),
+		[]byte("zkp_auth nothing up my sleeve"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toy, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []*zkutils.Params{schnorr, toy} {
+		if zkutils.CanBatch(params) {
+			t.Errorf("CanBatch(p:'%d') = true, expected false", params.P)
+		}
+		proofs := makeProofs(t, params, 4)
+		if _, combined := zkutils.BatchVerify(params, proofs, nil); combined {
+			t.Errorf("BatchVerify(p:'%d') used the combined check", params.P)
+		}
+		proofs[0].C = new(big.Int).Add(proofs[0].C, big.NewInt(1))
+		errs, _ := zkutils.BatchVerify(params, proofs, nil)
+		for i, err := range errs {
+			if (i == 0) != (err != nil) {
+				t.Errorf("BatchVerify(p:'%d') proof %d = %v", params.P, i, err)
+			}
+		}
+	}
+}
+
+// When there is no randomness for the weights, every proof is checked on its own
+func TestBatchVerifyWithoutRandomness(t *testing.T) {
+	params, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofs := makeProofs(t, params, 3)
+	proofs[1].S = big.NewInt(0)
+	errs, combined := zkutils.BatchVerify(params, proofs, failingReader{})
+	for i, err := range errs {
+		if (i == 1) != (err != nil) {
+			t.Errorf("BatchVerify() proof %d = %v", i, err)
+		}
+	}
+	if combined {
+		t.Error("BatchVerify() used the combined check without any weights")
+	}
+}
+
+func TestInSubgroup(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The subgroup of order 11 is {1, 2, 3, 4, 6, 8, 9, 12, 13, 16, 18}
+	members := map[int64]bool{1: true, 2: true, 3: true, 4: true, 6: true, 8: true, 9: true, 12: true, 13: true, 16: true, 18: true}
+	for x := int64(-1); x <= 24; x++ {
+		if zkutils.InSubgroup(params, big.NewInt(x)) != members[x] {
+			t.Errorf("InSubgroup(%d) = %t, expected %t", x, !members[x], members[x])
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+func bigFromString(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
+}
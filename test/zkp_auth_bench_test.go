@@ -0,0 +1,103 @@
+package utils_test
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/mischat/zkp_auth/authserver"
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// The benchmarks below run on three sizes of p, to size deployments:
+//
+//	go test ./test/ -run '^$' -bench 'VerifyProof|VerifyChaumPedersen|CalculateS|Login'
+
+type sizedGroup struct {
+	name   string
+	params *zkutils.Params
+}
+
+// The 256 bit group of the README, and Schnorr groups of 2048 and 3072 bits, all three with q of at most 256 bits
+func sizedGroups(b *testing.B) []sizedGroup {
+	readme, err := zkutils.ParseParams(readmeP, readmeQ, readmeG, readmeH)
+	if err != nil {
+		b.Fatal(err)
+	}
+	groups := []sizedGroup{{"p256", readme}}
+	for _, g := range []struct{ name, path string }{{"p2048", "testdata/schnorr2048.json"}, {"p3072", "testdata/schnorr3072.json"}} {
+		params, err := zkutils.ReadParamsFile(g.path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		groups = append(groups, sizedGroup{g.name, params})
+	}
+	return groups
+}
+
+func BenchmarkVerifyProof(b *testing.B) {
+	for _, g := range sizedGroups(b) {
+		proof := makeProof(b, g.params)
+		b.Run(g.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if ok, err := zkutils.VerifyProof(proof.R1, g.params.G, proof.S, proof.Y1, proof.C, g.params.P); !ok {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Both equations and every range and subgroup check, as the server does it
+func BenchmarkVerifyChaumPedersen(b *testing.B) {
+	for _, g := range sizedGroups(b) {
+		proof := makeProof(b, g.params)
+		pre := zkutils.Precompute(g.params)
+		b.Run(g.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := pre.VerifyChaumPedersen(proof.Y1, proof.Y2, proof.R1, proof.R2, proof.C, proof.S); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCalculateS(b *testing.B) {
+	for _, g := range sizedGroups(b) {
+		k, c, x := nonZeroScalar(b, g.params.Q), nonZeroScalar(b, g.params.Q), nonZeroScalar(b, g.params.Q)
+		for _, name := range []string{zkutils.ArithmeticBig, zkutils.ArithmeticConstantTime} {
+			arith, err := zkutils.NewArithmeticByName(g.params, name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(g.name+"/"+name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					arith.CalculateS(k, c, x)
+				}
+			})
+		}
+	}
+}
+
+// A whole login over bufconn: the client's commitment and answer, and the server's challenge and checks
+func BenchmarkLogin(b *testing.B) {
+	// the server logs every request
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, g := range sizedGroups(b) {
+		c := startServer(b, authserver.Config{Params: g.params})
+		x := nonZeroScalar(b, g.params.Q)
+		register(b, c, g.params, "alice@example.com", x)
+
+		b.Run(g.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := login(c, g.params, "alice@example.com", x); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
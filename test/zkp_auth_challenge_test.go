@@ -0,0 +1,144 @@
+package utils_test
+
+import (
+	"math/big"
+	mrand "math/rand"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func TestChallengeSpaceBounds(t *testing.T) {
+	toy := &zkutils.Params{P: big.NewInt(23), Q: big.NewInt(11), G: big.NewInt(4), H: big.NewInt(9)}
+	modp, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		params   *zkutils.Params
+		bits     int
+		expected *big.Int
+	}{
+		{toy, 0, big.NewInt(11)},
+		{toy, 3, big.NewInt(8)},
+		// 2^4 is more than q, so q is the bound
+		{toy, 4, big.NewInt(11)},
+		{toy, 300, big.NewInt(11)},
+		{modp, 0, modp.Q},
+		{modp, 128, new(big.Int).Lsh(big.NewInt(1), 128)},
+		{modp, 2048, modp.Q},
+	} {
+		cs, err := zkutils.NewChallengeSpace(tc.params, tc.bits)
+		if err != nil {
+			t.Errorf("q:'%d' bits:'%d' was refused: %v", tc.params.Q, tc.bits, err)
+			continue
+		}
+		if cs.Bound.Cmp(tc.expected) != 0 {
+			t.Errorf("q:'%d' bits:'%d' has the bound %d, expected %d", tc.params.Q, tc.bits, cs.Bound, tc.expected)
+		}
+	}
+
+	// negative bits, and spaces with a single challenge in them
+	for _, tc := range []struct {
+		q    int64
+		bits int
+	}{{11, -1}, {11, 1}, {2, 0}} {
+		params := &zkutils.Params{P: big.NewInt(23), Q: big.NewInt(tc.q), G: big.NewInt(4), H: big.NewInt(9)}
+		if _, err := zkutils.NewChallengeSpace(params, tc.bits); err == nil {
+			t.Errorf("q:'%d' bits:'%d' was accepted", tc.q, tc.bits)
+		}
+	}
+}
+
+func TestChallengeSampleInRange(t *testing.T) {
+	params := &zkutils.Params{P: big.NewInt(23), Q: big.NewInt(11), G: big.NewInt(4), H: big.NewInt(9)}
+	cs, err := zkutils.NewChallengeSpace(params, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		c, err := cs.Sample(random)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cs.Contains(c) || c.Sign() == 0 {
+			t.Fatalf("picked c:'%d', which is not in [1, %d)", c, cs.Bound)
+		}
+	}
+
+	for _, c := range []*big.Int{nil, big.NewInt(0), big.NewInt(4), big.NewInt(-1)} {
+		if cs.Contains(c) {
+			t.Errorf("c:'%d' is in [1, %d)", c, cs.Bound)
+		}
+	}
+
+	if _, err := cs.Sample(failingReader{}); err == nil {
+		t.Error("a challenge was picked without any randomness")
+	}
+}
+
+// Pearson's chi-square statistic of counts that should all be expected
+func chiSquare(counts []int, expected float64) float64 {
+	var stat float64
+	for _, n := range counts {
+		d := float64(n) - expected
+		stat += d * d / expected
+	}
+	return stat
+}
+
+// The challenges are checked for uniformity with a chi-square test. The randomness is seeded,
+// so that the test is repeatable, and the critical values are the 0.1% ones.
+func TestChallengeUniform(t *testing.T) {
+	toy := &zkutils.Params{P: big.NewInt(23), Q: big.NewInt(11), G: big.NewInt(4), H: big.NewInt(9)}
+	schnorr, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const samples = 20000
+	for _, tc := range []struct {
+		name   string
+		params *zkutils.Params
+		bits   int
+		// the challenges are counted in this many buckets of equal width
+		buckets int
+		// the 0.1% critical value of the chi-square distribution with buckets - 1 degrees of freedom
+		critical float64
+	}{
+		// every challenge in [1, 11) gets its own bucket
+		{"toy group", toy, 0, 10, 27.88},
+		// [1, 8), seven challenges
+		{"3 bit challenges", schnorr, 3, 7, 22.46},
+		// q is not a power of two, reducing a 256 bit sample mod q would overfill the low buckets
+		{"schnorr group", schnorr, 0, 16, 37.70},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, err := zkutils.NewChallengeSpace(tc.params, tc.bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			random := mrand.New(mrand.NewSource(40))
+			counts := make([]int, tc.buckets)
+			size := cs.Size()
+			for i := 0; i < samples; i++ {
+				c, err := cs.Sample(random)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the bucket of c is (c - 1) . buckets / size
+				b := new(big.Int).Sub(c, big.NewInt(1))
+				b.Mul(b, big.NewInt(int64(tc.buckets))).Div(b, size)
+				counts[b.Int64()]++
+			}
+
+			if stat := chiSquare(counts, float64(samples)/float64(tc.buckets)); stat > tc.critical {
+				t.Errorf("the challenges are not uniform, chi-square %.2f is over %.2f, counts %v", stat, tc.critical, counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mischat/zkp_auth/authserver"
+	pb "github.com/mischat/zkp_auth/pb"
+	zkutils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc"
+)
+
+// These build the client and run it against a server on a local port, the way a script would
+
+var (
+	buildClientOnce sync.Once
+	clientBinary    string
+	buildClientErr  error
+)
+
+// The client is built on first use, and removed once all the tests have run
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if clientBinary != "" {
+		os.RemoveAll(filepath.Dir(clientBinary))
+	}
+	os.Exit(code)
+}
+
+// This builds the client once for all the tests
+func buildClient(t testing.TB) string {
+	buildClientOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "zkp_auth_client")
+		if err != nil {
+			buildClientErr = err
+			return
+		}
+		clientBinary = filepath.Join(dir, "client")
+		out, err := exec.Command("go", "build", "-o", clientBinary, "../client").CombinedOutput()
+		if err != nil {
+			buildClientErr = errors.New(string(out))
+		}
+	})
+	if buildClientErr != nil {
+		t.Fatalf("could not build the client: %v", buildClientErr)
+	}
+	return clientBinary
+}
+
+// This starts a server for the config on a local port, and returns its address
+func startTCPServer(t testing.TB, cfg authserver.Config) string {
+	if cfg.AuditLog == nil {
+		cfg.AuditLog = io.Discard
+	}
+	srv, err := authserver.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterAuthServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(func() {
+		s.Stop()
+		srv.Close()
+	})
+	return lis.Addr().String()
+}
+
+// cli runs the client against one server, with its session file, used nonces and keystore in a temporary directory
+type cli struct {
+	t    *testing.T
+	bin  string
+	addr string
+	dir  string
+	// How the client gets the public variables
+	params []string
+	// Added to the client's environment, for the passphrases
+	env []string
+}
+
+func newCLI(t *testing.T, addr string) *cli {
+	return &cli{t: t, bin: buildClient(t), addr: addr, dir: t.TempDir(), params: []string{"-params", "testdata/schnorr2048.json"}}
+}
+
+// run returns the exit code and what the client wrote to stdout
+func (c *cli) run(args ...string) (int, []byte) {
+	flags := append([]string{
+		"-addr", c.addr,
+		"-session", filepath.Join(c.dir, "sessions.json"),
+		"-nonces", filepath.Join(c.dir, "nonces.json"),
+		"-keystore", filepath.Join(c.dir, "keystore.json"),
+	}, c.params...)
+	cmd := exec.Command(c.bin, append(flags, args...)...)
+	cmd.Env = append(os.Environ(), c.env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// nothing is ever read from the terminal
+	cmd.Stdin = bytes.NewReader(nil)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		c.t.Fatalf("could not run the client: %v", err)
+	}
+	if err != nil {
+		c.t.Logf("client %v: %s", args, stderr.String())
+		return exitErr.ExitCode(), stdout.Bytes()
+	}
+	return 0, stdout.Bytes()
+}
+
+func TestClientExitCodes(t *testing.T) {
+	params := serverParams(t)
+	c := newCLI(t, startTCPServer(t, authserver.Config{Params: params}))
+	x := nonZeroScalar(t, params.Q).String()
+	wrong := nonZeroScalar(t, params.Q).String()
+
+	// these run in order, against the same server and session file
+	for _, tc := range []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", nil, 2},
+		{"unknown command", []string{"signup"}, 2},
+		{"unknown output", []string{"-output", "yaml", "whoami"}, 2},
+		{"unknown nonce mode", []string{"-nonce", "counter", "whoami"}, 2},
+		{"missing user", []string{"login", "-x", x}, 2},
+		{"extra arguments", []string{"whoami", "alice@example.com"}, 2},
+		{"x not a number", []string{"register", "-u", "alice@example.com", "-x", "ten"}, 2},
+		{"not logged in", []string{"whoami"}, 3},
+		{"unknown user", []string{"login", "-u", "alice@example.com", "-x", x}, 3},
+		{"register", []string{"register", "-u", "alice@example.com", "-x", x}, 0},
+		{"register twice", []string{"register", "-u", "alice@example.com", "-x", x}, 3},
+		{"wrong secret", []string{"login", "-u", "alice@example.com", "-x", wrong}, 3},
+		{"login", []string{"login", "-u", "alice@example.com", "-x", x}, 0},
+		{"login over a stream", []string{"-stream", "login", "-u", "alice@example.com", "-x", x}, 0},
+		{"whoami", []string{"whoami"}, 0},
+		{"logout", []string{"logout"}, 0},
+		{"whoami after logout", []string{"whoami"}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c.t = t
+			if code, _ := c.run(tc.args...); code != tc.code {
+				t.Errorf("exited with %d, expected %d", code, tc.code)
+			}
+		})
+	}
+
+	t.Run("server down", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		down := newCLI(t, lis.Addr().String())
+		lis.Close()
+		if code, _ := down.run("-timeout", "1s", "login", "-u", "alice@example.com", "-x", x); code != 1 {
+			t.Errorf("exited with %d, expected 1", code)
+		}
+	})
+}
+
+func TestClientJSONOutput(t *testing.T) {
+	params := serverParams(t)
+	c := newCLI(t, startTCPServer(t, authserver.Config{Params: params}))
+	oldX, newX := nonZeroScalar(t, params.Q).String(), nonZeroScalar(t, params.Q).String()
+
+	// This runs a command with -output json, checks its exit code and decodes its one line of output
+	run := func(code int, out interface{}, args ...string) {
+		t.Helper()
+		got, stdout := c.run(append([]string{"-output", "json"}, args...)...)
+		if got != code {
+			t.Fatalf("%v exited with %d, expected %d", args, got, code)
+		}
+		dec := json.NewDecoder(bytes.NewReader(stdout))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(out); err != nil {
+			t.Fatalf("%v wrote '%s', which is not the JSON expected: %v", args, stdout, err)
+		}
+		if dec.More() {
+			t.Errorf("%v wrote more than one JSON value: '%s'", args, stdout)
+		}
+	}
+	type failure struct {
+		Error string `json:"error"`
+	}
+
+	var registered struct {
+		User string `json:"user"`
+	}
+	run(0, &registered, "register", "-u", "alice@example.com", "-x", oldX)
+	if registered.User != "alice@example.com" {
+		t.Errorf("register wrote %+v", registered)
+	}
+
+	var loggedIn struct {
+		User      string `json:"user"`
+		SessionId string `json:"session_id"`
+	}
+	run(0, &loggedIn, "login", "-u", "alice@example.com", "-x", oldX)
+	if loggedIn.User != "alice@example.com" || loggedIn.SessionId == "" {
+		t.Fatalf("login wrote %+v", loggedIn)
+	}
+
+	var who struct {
+		User      string `json:"user"`
+		SessionId string `json:"session_id"`
+		CreatedAt string `json:"created_at"`
+	}
+	run(0, &who, "whoami")
+	if who.User != "alice@example.com" || who.SessionId != loggedIn.SessionId || who.CreatedAt == "" {
+		t.Errorf("whoami wrote %+v, expected the session of the login", who)
+	}
+
+	var fetched struct {
+		P, Q, G, H  string
+		Fingerprint string
+		Matches     bool `json:"matches_local"`
+	}
+	run(0, &fetched, "params", "fetch")
+	if fetched.P != params.P.String() || fetched.Fingerprint != params.Fingerprint() || !fetched.Matches {
+		t.Errorf("params fetch wrote %+v", fetched)
+	}
+
+	var rotated struct {
+		User     string `json:"user"`
+		Keystore bool   `json:"keystore_updated"`
+	}
+	run(0, &rotated, "rotate", "-old-x", oldX, "-x", newX)
+	if rotated.User != "alice@example.com" || rotated.Keystore {
+		t.Errorf("rotate wrote %+v", rotated)
+	}
+	var refused failure
+	run(3, &refused, "login", "-u", "alice@example.com", "-x", oldX)
+	if refused.Error == "" {
+		t.Error("the login with the old secret failed without an error")
+	}
+	run(0, &loggedIn, "login", "-u", "alice@example.com", "-x", newX)
+
+	var loggedOut struct {
+		User string `json:"user"`
+	}
+	run(0, &loggedOut, "logout")
+	if loggedOut.User != "alice@example.com" {
+		t.Errorf("logout wrote %+v", loggedOut)
+	}
+	// the session is gone from the file, and from the server
+	data, err := os.ReadFile(filepath.Join(c.dir, "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sessions map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sessions[c.addr]; ok {
+		t.Error("the session is still in the session file after the logout")
+	}
+	run(3, &refused, "whoami")
+
+	// errors go to stdout as JSON too
+	var usage failure
+	run(2, &usage, "login", "-x", newX)
+	if usage.Error != "-u is required" {
+		t.Errorf("the usage error is '%s'", usage.Error)
+	}
+}
+
+// The keystore holds x, so that login and rotate don't need it on the command line
+func TestClientKeystore(t *testing.T) {
+	params := serverParams(t)
+	c := newCLI(t, startTCPServer(t, authserver.Config{Params: params}))
+	c.env = []string{"ZKP_AUTH_KEYSTORE_PASSPHRASE=correct horse battery staple"}
+	oldX, newX := nonZeroScalar(t, params.Q).String(), nonZeroScalar(t, params.Q).String()
+
+	run := func(out interface{}, args ...string) {
+		t.Helper()
+		code, stdout := c.run(append([]string{"-output", "json"}, args...)...)
+		if code != 0 {
+			t.Fatalf("%v exited with %d", args, code)
+		}
+		if err := json.Unmarshal(stdout, out); err != nil {
+			t.Fatalf("%v wrote '%s': %v", args, stdout, err)
+		}
+	}
+	// This exports x from the keystore
+	stored := func() string {
+		t.Helper()
+		var exported struct {
+			X string `json:"x"`
+		}
+		run(&exported, "keystore", "export", "-u", "alice@example.com")
+		return exported.X
+	}
+
+	var ignored interface{}
+	run(&ignored, "register", "-u", "alice@example.com", "-x", oldX)
+	run(&ignored, "keystore", "import", "-u", "alice@example.com", "-x", oldX)
+
+	// nothing is read from stdin, so without the keystore these would fail
+	var loggedIn struct {
+		SessionId string `json:"session_id"`
+	}
+	run(&loggedIn, "login", "-u", "alice@example.com")
+	if loggedIn.SessionId == "" {
+		t.Fatal("logged in without a session")
+	}
+
+	var rotated struct {
+		Keystore bool `json:"keystore_updated"`
+	}
+	run(&rotated, "rotate", "-x", newX)
+	if !rotated.Keystore {
+		t.Error("rotate did not update the keystore")
+	}
+	if x := stored(); x != newX {
+		t.Errorf("the keystore has x = %s after the rotation, expected the new one", x)
+	}
+
+	run(&loggedIn, "login", "-u", "alice@example.com")
+	if code, _ := c.run("login", "-u", "alice@example.com", "-x", oldX); code != 3 {
+		t.Errorf("the login with the old secret exited with %d, expected 3", code)
+	}
+
+	// a wrong keystore passphrase is a refusal, and x isn't asked for in its place
+	c.env = []string{"ZKP_AUTH_KEYSTORE_PASSPHRASE=wrong"}
+	if code, _ := c.run("login", "-u", "alice@example.com"); code != 3 {
+		t.Errorf("the login with the wrong keystore passphrase exited with %d, expected 3", code)
+	}
+}
+
+// In the toy group there are only 10 values of k, so the client runs out of them within 11 logins.
+// Each run only logs in once, so this only works because the ks used are remembered between runs.
+func TestClientNeverReusesK(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "12", "13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server would refuse a reused commitment too, but the client has to stop before sending it
+	c := newCLI(t, startTCPServer(t, authserver.Config{Params: params}))
+	c.params = nil
+	if code, _ := c.run("register", "-u", "alice@example.com", "-x", "6"); code != 0 {
+		t.Fatalf("could not register, exit code %d", code)
+	}
+
+	var failure struct {
+		Error string `json:"error"`
+	}
+	logins := 0
+	for ; logins <= 10; logins++ {
+		code, stdout := c.run("-output", "json", "login", "-u", "alice@example.com", "-x", "6")
+		if code == 0 {
+			continue
+		}
+		if err := json.Unmarshal(stdout, &failure); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if !strings.Contains(failure.Error, zkutils.ErrNonceReused.Error()) {
+		t.Fatalf("after %d logins the client failed with '%s', expected a reused k", logins, failure.Error)
+	}
+	if logins == 0 {
+		t.Error("the first login was refused")
+	}
+
+	info, err := os.Stat(filepath.Join(c.dir, "nonces.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("the used nonces can be read by others, the mode is %v", perm)
+	}
+}
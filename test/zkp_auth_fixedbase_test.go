@@ -0,0 +1,112 @@
+package utils_test
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func TestFixedBaseExp(t *testing.T) {
+	params, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modp, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the Schnorr group gets 8 bit windows, modp2048 with its 2047 bit q gets 4 bit ones
+	for _, params := range []*zkutils.Params{params, modp} {
+		pre := zkutils.Precompute(params)
+		for _, e := range []*big.Int{
+			big.NewInt(0),
+			big.NewInt(1),
+			new(big.Int).Sub(params.Q, big.NewInt(1)),
+			params.Q,
+			// these are not covered by the table
+			new(big.Int).Lsh(params.Q, 1),
+			big.NewInt(-5),
+		} {
+			for _, fb := range []*zkutils.FixedBase{pre.G, pre.H} {
+				expected := new(big.Int).Exp(fb.Base(), e, params.P)
+				if got := fb.Exp(e); got.Cmp(expected) != 0 {
+					t.Errorf("FixedBase(%d).Exp(%d) = %d, expected %d", fb.Base(), e, got, expected)
+				}
+			}
+		}
+
+		for i := 0; i < 10; i++ {
+			e, _ := rand.Int(rand.Reader, params.Q)
+			if got, expected := pre.G.Exp(e), new(big.Int).Exp(params.G, e, params.P); got.Cmp(expected) != 0 {
+				t.Errorf("FixedBase(g).Exp(%d) = %d, expected %d", e, got, expected)
+			}
+		}
+	}
+}
+
+// The benchmarks compare ways of computing g^s . y^c mod p, one side of the verification equation,
+// in a 2048 bit Schnorr group with a 256 bit q, and in modp2048 where q has 2047 bits
+// go test ./test -run XXX -bench GSYC
+
+type gsycInput struct {
+	params *zkutils.Params
+	pre    *zkutils.Precomputed
+	s, y   *big.Int
+	c      *big.Int
+}
+
+func benchmarkGroups(b *testing.B) map[string]gsycInput {
+	schnorr, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	modp, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	inputs := map[string]gsycInput{}
+	for name, params := range map[string]*zkutils.Params{"schnorr2048": schnorr, "modp2048": modp} {
+		proof := makeProof(b, params)
+		inputs[name] = gsycInput{params: params, pre: zkutils.Precompute(params), s: proof.S, y: proof.Y1, c: proof.C}
+	}
+	return inputs
+}
+
+func BenchmarkGSYC(b *testing.B) {
+	for _, name := range []string{"schnorr2048", "modp2048"} {
+		in := benchmarkGroups(b)[name]
+		p := in.params.P
+
+		// What VerifyProof does
+		b.Run(name+"/Exp", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lhs := new(big.Int).Exp(in.params.G, in.s, p)
+				rhs := new(big.Int).Exp(in.y, in.c, p)
+				lhs.Mul(lhs, rhs).Mod(lhs, p)
+			}
+		})
+		// What Precomputed.VerifyChaumPedersen does for each equation
+		b.Run(name+"/FixedBase", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lhs := in.pre.G.Exp(in.s)
+				rhs := new(big.Int).Exp(in.y, in.c, p)
+				lhs.Mul(lhs, rhs).Mod(lhs, p)
+			}
+		})
+	}
+}
+
+func BenchmarkPrecompute(b *testing.B) {
+	for _, name := range []string{"schnorr2048", "modp2048"} {
+		in := benchmarkGroups(b)[name]
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				zkutils.Precompute(in.params)
+			}
+		})
+	}
+}
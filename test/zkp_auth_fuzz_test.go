@@ -0,0 +1,281 @@
+package utils_test
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/mischat/zkp_auth/authserver"
+	pb "github.com/mischat/zkp_auth/pb"
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// The seed corpus comes from the parameter sets in the README: the small group the server and
+// client default to, the 256 bit p of "Running the client and the server", and a 2048 bit group.
+// go test runs the seeds, go test -fuzz FuzzName ./test/ keeps going from them.
+
+const (
+	readmeP = "115792089237316195423570985008687907852837564279074904382605163141518161494337"
+	readmeQ = "341948486974166000522343609283189"
+	readmeG = "74446558554923317135296388588396736831887322850186029432124219757485062736903"
+	readmeH = "79726485623116979445189935890227226532411986477410367519098002861237945910855"
+)
+
+func fuzzGroups(f *testing.F) []*zkutils.Params {
+	schnorr, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	return []*zkutils.Params{
+		{P: big.NewInt(23), Q: big.NewInt(11), G: big.NewInt(12), H: big.NewInt(13)},
+		{P: bigFromString(readmeP), Q: bigFromString(readmeQ), G: bigFromString(readmeG), H: bigFromString(readmeH)},
+		schnorr,
+	}
+}
+
+// This turns fuzzed bytes into a number in [1, q)
+func fuzzScalar(b []byte, q *big.Int) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	n.Mod(n, new(big.Int).Sub(q, big.NewInt(1)))
+	return n.Add(n, big.NewInt(1))
+}
+
+// An honest proof verifies, whatever x, k and c are
+func FuzzVerifyProofHonest(f *testing.F) {
+	groups := fuzzGroups(f)
+	for i := range groups {
+		f.Add(uint8(i), []byte{6}, []byte{3}, []byte{5})
+		f.Add(uint8(i), []byte{0xff, 0xff, 0xff, 0xff}, []byte{0}, []byte{0x80, 0x01})
+	}
+
+	f.Fuzz(func(t *testing.T, group uint8, xb, kb, cb []byte) {
+		params := groups[int(group)%len(groups)]
+		x, k, c := fuzzScalar(xb, params.Q), fuzzScalar(kb, params.Q), fuzzScalar(cb, params.Q)
+
+		y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+		r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+		s := zkutils.CalculateS(k, c, x, params.Q)
+
+		if ok, err := zkutils.VerifyProof(r1, params.G, s, y1, c, params.P); !ok {
+			t.Errorf("an honest r1 did not verify: %v", err)
+		}
+		if ok, err := zkutils.VerifyProof(r2, params.H, s, y2, c, params.P); !ok {
+			t.Errorf("an honest r2 did not verify: %v", err)
+		}
+		if err := zkutils.VerifyChaumPedersen(params, y1, y2, r1, r2, c, s); err != nil {
+			t.Errorf("an honest proof did not verify: %v", err)
+		}
+	})
+}
+
+// Any other s fails: g has order q, so g^s' = g^s only when s' = s mod q,
+// and VerifyChaumPedersen refuses an s' that isn't reduced
+func FuzzVerifyProofRandomS(f *testing.F) {
+	groups := fuzzGroups(f)
+	for i := range groups {
+		f.Add(uint8(i), []byte{6}, []byte{3}, []byte{5}, "0")
+		f.Add(uint8(i), []byte{6}, []byte{3}, []byte{5}, "-1")
+		f.Add(uint8(i), []byte{6}, []byte{3}, []byte{5}, groups[i].Q.String())
+	}
+
+	f.Fuzz(func(t *testing.T, group uint8, xb, kb, cb []byte, forged string) {
+		params := groups[int(group)%len(groups)]
+		sForged, ok := new(big.Int).SetString(forged, 10)
+		if !ok || sForged.BitLen() > 4096 {
+			return
+		}
+		x, k, c := fuzzScalar(xb, params.Q), fuzzScalar(kb, params.Q), fuzzScalar(cb, params.Q)
+
+		y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+		r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+		s := zkutils.CalculateS(k, c, x, params.Q)
+
+		err := zkutils.VerifyChaumPedersen(params, y1, y2, r1, r2, c, sForged)
+		if sForged.Cmp(s) == 0 {
+			if err != nil {
+				t.Errorf("the honest s did not verify: %v", err)
+			}
+			return
+		}
+		if err == nil {
+			t.Errorf("s:'%d' verified, the honest s is '%d'", sForged, s)
+		}
+
+		// VerifyProof only checks the equation, so it accepts s + q, but nothing else
+		same := new(big.Int).Mod(sForged, params.Q).Cmp(s) == 0
+		if ok, _ := zkutils.VerifyProof(r1, params.G, sForged, y1, c, params.P); ok != same {
+			t.Errorf("VerifyProof with s:'%d' returned %v, the honest s is '%d'", sForged, ok, s)
+		}
+	})
+}
+
+// VerifyProof returns an answer for any numbers at all, rather than panicking
+func FuzzVerifyProof(f *testing.F) {
+	f.Add("4", "12", "5", "2", "3", "23")
+	f.Add("1", "0", "-1", "0", "-1", "24")
+	f.Add("1", "2", "3", "4", "5", "0")
+	f.Add("1", "2", "3", "4", "5", "-23")
+	f.Add(readmeG, readmeG, readmeQ, readmeH, readmeQ, readmeP)
+
+	f.Fuzz(func(t *testing.T, rs, ghs, ss, ys, cs, ps string) {
+		var nums []*big.Int
+		for _, v := range []string{rs, ghs, ss, ys, cs, ps} {
+			n, ok := new(big.Int).SetString(v, 10)
+			// keep the exponentiations quick
+			if !ok || n.BitLen() > 1024 {
+				return
+			}
+			nums = append(nums, n)
+		}
+		zkutils.VerifyProof(nums[0], nums[1], nums[2], nums[3], nums[4], nums[5])
+	})
+}
+
+// s = k - c.x mod q, always reduced, and the constant-time backend agrees
+func FuzzCalculateS(f *testing.F) {
+	groups := fuzzGroups(f)
+	for i := range groups {
+		f.Add(uint8(i), "7", "3", "6")
+		f.Add(uint8(i), "0", "-5", groups[i].Q.String())
+		f.Add(uint8(i), "-1", "1", "1")
+	}
+
+	f.Fuzz(func(t *testing.T, group uint8, ks, cs, xs string) {
+		params := groups[int(group)%len(groups)]
+		var nums []*big.Int
+		for _, v := range []string{ks, cs, xs} {
+			n, ok := new(big.Int).SetString(v, 10)
+			if !ok || n.BitLen() > 4096 {
+				return
+			}
+			nums = append(nums, n)
+		}
+		k, c, x := nums[0], nums[1], nums[2]
+
+		s := zkutils.CalculateS(k, c, x, params.Q)
+		if s.Sign() < 0 || s.Cmp(params.Q) >= 0 {
+			t.Fatalf("s:'%d' is not in [0, q)", s)
+		}
+		// s + c.x - k = 0 mod q
+		check := new(big.Int).Mul(c, x)
+		check.Add(check, s).Sub(check, k).Mod(check, params.Q)
+		if check.Sign() != 0 {
+			t.Errorf("s:'%d' is not k - c.x mod q, for k:'%d' c:'%d' x:'%d'", s, k, c, x)
+		}
+
+		ct, err := zkutils.NewArithmeticByName(params, zkutils.ArithmeticConstantTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ct.CalculateS(k, c, x); got.Cmp(s) != 0 {
+			t.Errorf("the constant-time backend returned s:'%d', math/big '%d'", got, s)
+		}
+	})
+}
+
+// Whatever ValidatePublicVariables accepts really is a group with g and h of order q
+func FuzzValidatePublicVariables(f *testing.F) {
+	f.Add("23", "11", "12", "13")
+	f.Add("23", "11", "4", "9")
+	f.Add("23", "11", "1", "9")
+	f.Add("23", "11", "4", "4")
+	f.Add("24", "11", "4", "9")
+	f.Add("0", "0", "0", "0")
+	f.Add("-23", "-11", "-4", "-9")
+	f.Add(readmeP, readmeQ, readmeG, readmeH)
+
+	f.Fuzz(func(t *testing.T, ps, qs, gs, hs string) {
+		var nums []*big.Int
+		for _, v := range []string{ps, qs, gs, hs} {
+			n, ok := new(big.Int).SetString(v, 10)
+			// primality tests of big numbers are slow, and the README groups fit
+			if !ok || n.BitLen() > 512 {
+				return
+			}
+			nums = append(nums, n)
+		}
+		p, q, g, h := nums[0], nums[1], nums[2], nums[3]
+
+		ok, err := zkutils.ValidatePublicVariables(p, q, g, h)
+		if ok != (err == nil) {
+			t.Fatalf("ValidatePublicVariables returned %v and '%v'", ok, err)
+		}
+		if !ok {
+			return
+		}
+
+		one := big.NewInt(1)
+		pMinusOne := new(big.Int).Sub(p, one)
+		if new(big.Int).Mod(pMinusOne, q).Sign() != 0 {
+			t.Errorf("q:'%d' does not divide p - 1:'%d'", q, pMinusOne)
+		}
+		for _, x := range []*big.Int{g, h} {
+			if x.Cmp(one) <= 0 || x.Cmp(p) >= 0 {
+				t.Errorf("'%d' is not in (1, p)", x)
+			}
+			if new(big.Int).Exp(x, q, p).Cmp(one) != 0 {
+				t.Errorf("'%d' does not have order q:'%d'", x, q)
+			}
+		}
+		if g.Cmp(h) == 0 {
+			t.Errorf("g and h are both '%d'", g)
+		}
+	})
+}
+
+// The server turns whatever strings it is sent into errors, never into a panic,
+// and a session only ever comes out of a proof that verifies
+func FuzzServerRequests(f *testing.F) {
+	params := &zkutils.Params{P: big.NewInt(23), Q: big.NewInt(11), G: big.NewInt(12), H: big.NewInt(13)}
+
+	// x = 6 and k = 7, y1 = 12^6, y2 = 13^6, r1 = 12^7, r2 = 13^7 mod 23, s is right for one challenge in ten
+	f.Add("alice", "9", "6", "16", "9", "5")
+	f.Add("bob", "", "", "", "", "")
+	f.Add("carol", "-2", "+9", "0x0c", "1e3", "٣")
+	f.Add("", "1", "1", "1", "1", "0")
+	f.Add("dave", "22", "23", "24", "-1", strings.Repeat("9", 100))
+	f.Add("erin", "4", "4", "1", "1", " 1")
+
+	ctx := context.Background()
+	f.Fuzz(func(t *testing.T, user, y1, y2, r1, r2, s string) {
+		// a server of its own, so that what is registered is known
+		srv, err := authserver.New(authserver.Config{Params: params, AuditLog: io.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+
+		_, regErr := srv.Register(ctx, &pb.RegisterRequest{User: user, Y1: y1, Y2: y2})
+		srv.GetKdfParameters(ctx, &pb.KdfParametersRequest{User: user})
+
+		chal, err := srv.CreateAuthenticationChallenge(ctx, &pb.AuthenticationChallengeRequest{User: user, R1: r1, R2: r2})
+		if err != nil {
+			return
+		}
+		if regErr != nil {
+			t.Fatalf("%s got a challenge without being registered", user)
+		}
+		resp, err := srv.VerifyAuthentication(ctx, &pb.AuthenticationAnswerRequest{AuthId: chal.GetAuthId(), S: s})
+		if err != nil {
+			return
+		}
+
+		// the strings all parsed, or there would be no session
+		nums := make([]*big.Int, 6)
+		for i, v := range []string{y1, y2, r1, r2, chal.GetC(), s} {
+			nums[i], _ = new(big.Int).SetString(v, 10)
+		}
+		if err := zkutils.VerifyChaumPedersen(params, nums[0], nums[1], nums[2], nums[3], nums[4], nums[5]); err != nil {
+			t.Fatalf("a session came out of a proof that doesn't verify: %v", err)
+		}
+
+		sessionId := resp.GetSessionId()
+		if who, err := srv.WhoAmI(ctx, &pb.WhoAmIRequest{SessionId: sessionId}); err != nil || who.GetUser() != user {
+			t.Fatalf("the session '%s' is not %s's: %v", sessionId, user, err)
+		}
+		srv.Rotate(ctx, &pb.RotateRequest{SessionId: sessionId, Y1: r1, Y2: r2})
+		srv.Logout(ctx, &pb.LogoutRequest{SessionId: sessionId})
+	})
+}
@@ -0,0 +1,278 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mischat/zkp_auth/authserver"
+	"github.com/mischat/zkp_auth/gateway"
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// These log in over the HTTP gateway, the way a web client would
+
+// This starts a server for the config behind the gateway, and returns its URL
+func startGateway(t testing.TB, cfg authserver.Config) string {
+	if cfg.AuditLog == nil {
+		cfg.AuditLog = io.Discard
+	}
+	srv, err := authserver.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.New(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(gw)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return ts.URL
+}
+
+// gatewayError is the body of every error the gateway answers with
+type gatewayError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// This sends body as JSON, a string is sent as it is, and decodes the answer into out when it is a 200
+// It returns the status, and the error body for any other status
+func call(t testing.TB, method, url string, body interface{}, out interface{}) (int, gatewayError) {
+	var data []byte
+	switch b := body.(type) {
+	case nil:
+	case string:
+		data = []byte(b)
+	default:
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s answered with '%s', expected application/json", method, url, ct)
+	}
+	var gwErr gatewayError
+	if resp.StatusCode != http.StatusOK {
+		out = &gwErr
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s answered with a body that is not JSON: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode, gwErr
+}
+
+func toHex(n *big.Int) string {
+	return fmt.Sprintf("0x%x", n)
+}
+
+// This logs in over the gateway with the numbers in hex, and returns the status of the verify call and the session ID
+func gatewayLogin(t testing.TB, url string, params *zkutils.Params, user string, x *big.Int) (int, gatewayError, string) {
+	k := nonZeroScalar(t, params.Q)
+	var chal struct {
+		AuthId string `json:"auth_id"`
+		C      string `json:"c"`
+	}
+	r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/challenge", map[string]string{"user": user, "r1": toHex(r1), "r2": toHex(r2)}, &chal); status != http.StatusOK {
+		t.Fatalf("could not get a challenge: %d %v", status, gwErr)
+	}
+	c, ok := new(big.Int).SetString(chal.C, 10)
+	if !ok {
+		t.Fatalf("c is not a decimal number: '%s'", chal.C)
+	}
+
+	var verified struct {
+		SessionId string `json:"session_id"`
+	}
+	s := zkutils.CalculateS(k, c, x, params.Q)
+	status, gwErr := call(t, http.MethodPost, url+"/v1/verify", map[string]string{"auth_id": chal.AuthId, "s": s.String()}, &verified)
+	return status, gwErr, verified.SessionId
+}
+
+func TestGatewayRegisterAndLogin(t *testing.T) {
+	params := serverParams(t)
+	url := startGateway(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+
+	var public struct {
+		P, Q, G, H  string
+		Fingerprint string
+	}
+	if status, gwErr := call(t, http.MethodGet, url+"/v1/params", nil, &public); status != http.StatusOK {
+		t.Fatalf("could not fetch the public variables: %d %v", status, gwErr)
+	}
+	if public.P != params.P.String() || public.Q != params.Q.String() || public.G != params.G.String() || public.H != params.H.String() {
+		t.Error("the gateway returned different public variables")
+	}
+	if public.Fingerprint != params.Fingerprint() {
+		t.Errorf("the fingerprint is '%s', expected '%s'", public.Fingerprint, params.Fingerprint())
+	}
+
+	// y1 in hex and y2 in decimal
+	y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/register", map[string]string{"user": "alice@example.com", "y1": toHex(y1), "y2": y2.String()}, nil); status != http.StatusOK {
+		t.Fatalf("could not register: %d %v", status, gwErr)
+	}
+
+	status, gwErr, sessionId := gatewayLogin(t, url, params, "alice@example.com", x)
+	if status != http.StatusOK {
+		t.Fatalf("could not log in: %d %v", status, gwErr)
+	}
+
+	var who struct {
+		User      string `json:"user"`
+		CreatedAt int64  `json:"created_at"`
+	}
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/whoami", map[string]string{"session_id": sessionId}, &who); status != http.StatusOK {
+		t.Fatalf("whoami failed: %d %v", status, gwErr)
+	}
+	if who.User != "alice@example.com" || who.CreatedAt == 0 {
+		t.Errorf("the session is %+v, expected alice@example.com's", who)
+	}
+
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/logout", map[string]string{"session_id": sessionId}, nil); status != http.StatusOK {
+		t.Fatalf("could not log out: %d %v", status, gwErr)
+	}
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/whoami", map[string]string{"session_id": sessionId}, nil); status != http.StatusUnauthorized || gwErr.Error != "unauthorized" {
+		t.Errorf("whoami after the logout answered %d %v, expected 401 unauthorized", status, gwErr)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	params := serverParams(t)
+	url := startGateway(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+	y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/register", map[string]string{"user": "alice@example.com", "y1": y1.String(), "y2": y2.String()}, nil); status != http.StatusOK {
+		t.Fatalf("could not register: %d %v", status, gwErr)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+		code   string
+		// part of the message
+		message string
+	}{
+		{"unknown route", http.MethodPost, "/v1/nothing", "{}", http.StatusNotFound, "not_found", "/v1/nothing"},
+		{"wrong method", http.MethodGet, "/v1/register", nil, http.StatusMethodNotAllowed, "method_not_allowed", "POST"},
+		{"not JSON", http.MethodPost, "/v1/register", "user=alice", http.StatusBadRequest, "bad_request", "not valid JSON"},
+		{"unknown field", http.MethodPost, "/v1/challenge", `{"user": "alice@example.com", "k": "1"}`, http.StatusBadRequest, "bad_request", "unknown field"},
+		{"two values", http.MethodPost, "/v1/verify", `{} {}`, http.StatusBadRequest, "bad_request", "more than one"},
+		{"bad hex", http.MethodPost, "/v1/challenge", map[string]string{"user": "alice@example.com", "r1": "0xg", "r2": "0x1"}, http.StatusBadRequest, "bad_request", "r1 is not a hex number"},
+		{"hex too long", http.MethodPost, "/v1/challenge", map[string]string{"user": "alice@example.com", "r1": "0x1", "r2": "0x" + strings.Repeat("f", 600)}, http.StatusBadRequest, "bad_request", "r2 is too long"},
+		{"decimal too long", http.MethodPost, "/v1/challenge", map[string]string{"user": "alice@example.com", "r1": strings.Repeat("9", 700), "r2": "1"}, http.StatusBadRequest, "bad_request", "r1 is too long"},
+		{"body too large", http.MethodPost, "/v1/register", `{"user": "` + strings.Repeat("a", 100<<10) + `"}`, http.StatusRequestEntityTooLarge, "too_large", "bytes"},
+		{"registered twice", http.MethodPost, "/v1/register", map[string]string{"user": "alice@example.com", "y1": y1.String(), "y2": y2.String()}, http.StatusConflict, "conflict", "already exists"},
+		{"commitment out of range", http.MethodPost, "/v1/challenge", map[string]string{"user": "alice@example.com", "r1": "0", "r2": "1"}, http.StatusBadRequest, "bad_request", zkutils.ErrR1OutOfRange.Error()},
+		{"unknown user", http.MethodPost, "/v1/challenge", map[string]string{"user": "nobody@example.com", "r1": "1", "r2": "1"}, http.StatusNotFound, "not_found", "user doesn't exists"},
+		{"unknown auth ID", http.MethodPost, "/v1/verify", map[string]string{"auth_id": "nothing", "s": "1"}, http.StatusNotFound, "not_found", "authId doesn't exists"},
+		{"unknown session", http.MethodPost, "/v1/whoami", map[string]string{"session_id": "nothing"}, http.StatusUnauthorized, "unauthorized", "session doesn't exists"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, gwErr := call(t, tc.method, url+tc.path, tc.body, nil)
+			if status != tc.status || gwErr.Error != tc.code || !strings.Contains(gwErr.Message, tc.message) {
+				t.Errorf("answered %d %+v, expected %d '%s' with '%s'", status, gwErr, tc.status, tc.code, tc.message)
+			}
+		})
+	}
+
+	t.Run("expired challenge", func(t *testing.T) {
+		clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		url := startGateway(t, authserver.Config{Params: params, ChallengeTTL: time.Minute, Now: clock.Now})
+		if status, gwErr := call(t, http.MethodPost, url+"/v1/register", map[string]string{"user": "alice@example.com", "y1": y1.String(), "y2": y2.String()}, nil); status != http.StatusOK {
+			t.Fatalf("could not register: %d %v", status, gwErr)
+		}
+
+		var chal struct {
+			AuthId string `json:"auth_id"`
+		}
+		r := new(big.Int).Exp(params.G, nonZeroScalar(t, params.Q), params.P)
+		if status, gwErr := call(t, http.MethodPost, url+"/v1/challenge", map[string]string{"user": "alice@example.com", "r1": r.String(), "r2": r.String()}, &chal); status != http.StatusOK {
+			t.Fatalf("could not get a challenge: %d %v", status, gwErr)
+		}
+		clock.advance(2 * time.Minute)
+		status, gwErr := call(t, http.MethodPost, url+"/v1/verify", map[string]string{"auth_id": chal.AuthId, "s": "1"}, nil)
+		if status != http.StatusGone || gwErr.Error != "expired" {
+			t.Errorf("answered %d %+v, expected 410 expired", status, gwErr)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		status, gwErr, _ := gatewayLogin(t, url, params, "alice@example.com", new(big.Int).Add(x, big.NewInt(1)))
+		if status != http.StatusUnauthorized || gwErr.Error != "unauthorized" || !strings.Contains(gwErr.Message, zkutils.ErrR1Mismatch.Error()) {
+			t.Errorf("answered %d %+v, expected 401 unauthorized with a mismatch", status, gwErr)
+		}
+	})
+
+	t.Run("not JSON content type", func(t *testing.T) {
+		resp, err := http.Post(url+"/v1/kdf", "text/plain", strings.NewReader(`{"user": "alice@example.com"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("answered %d, expected 415", resp.StatusCode)
+		}
+	})
+}
+
+// gateway/openapi.json is written by scripts/genopenapi, and has to match the routes
+func TestGatewayOpenAPI(t *testing.T) {
+	doc, err := gateway.OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("../gateway/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, doc) {
+		t.Error("gateway/openapi.json is out of date, write it with: go run ./scripts/genopenapi -out gateway/openapi.json")
+	}
+
+	var served struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	url := startGateway(t, authserver.Config{Params: serverParams(t)})
+	if status, gwErr := call(t, http.MethodGet, url+"/v1/openapi.json", nil, &served); status != http.StatusOK {
+		t.Fatalf("could not fetch the document: %d %v", status, gwErr)
+	}
+	for _, path := range []string{"/v1/register", "/v1/challenge", "/v1/verify", "/v1/params", "/v1/whoami", "/v1/logout", "/v1/rotate", "/v1/kdf"} {
+		if _, ok := served.Paths[path]; !ok {
+			t.Errorf("the document has no '%s'", path)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package utils_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func TestStandardGroups(t *testing.T) {
+	names := zkutils.StandardGroupNames()
+	if strings.Join(names, ",") != "ffdhe2048,ffdhe3072,ffdhe4096,modp2048,modp3072" {
+		t.Errorf("StandardGroupNames() = %v", names)
+	}
+
+	for _, name := range names {
+		params, err := zkutils.StandardGroup(name)
+		if err != nil {
+			t.Fatalf("StandardGroup(%s) returned error: %v", name, err)
+		}
+
+		// p = 2q + 1
+		if new(big.Int).Add(new(big.Int).Lsh(params.Q, 1), big.NewInt(1)).Cmp(params.P) != 0 {
+			t.Errorf("%s: p is not 2q + 1", name)
+		}
+
+		valid, err := zkutils.ValidatePublicVariables(params.P, params.Q, params.G, params.H)
+		if !valid || err != nil {
+			t.Errorf("ValidatePublicVariables(%s) = (%t, %v), expected (true, nil)", name, valid, err)
+		}
+
+		// h comes from the public seed, so log_g h is not known
+		if err := zkutils.VerifyDerivation(params); err != nil {
+			t.Errorf("VerifyDerivation(%s) = %v, expected nil", name, err)
+		}
+	}
+}
+
+func TestStandardGroupsMatchTheRFCs(t *testing.T) {
+	// the leading and trailing 64 bits are all ones, and the next 32 bits are from pi or e
+	for name, prefix := range map[string]string{
+		"modp2048":  "ffffffffffffffffc90fdaa2",
+		"modp3072":  "ffffffffffffffffc90fdaa2",
+		"ffdhe2048": "ffffffffffffffffadf85458",
+		"ffdhe3072": "ffffffffffffffffadf85458",
+		"ffdhe4096": "ffffffffffffffffadf85458",
+	} {
+		params, err := zkutils.StandardGroup(name)
+		if err != nil {
+			t.Fatalf("StandardGroup(%s) returned error: %v", name, err)
+		}
+
+		bits := strings.TrimPrefix(name, "modp")
+		bits = strings.TrimPrefix(bits, "ffdhe")
+		if got := params.P.BitLen(); bits != big.NewInt(int64(got)).String() {
+			t.Errorf("%s: p has %d bits", name, got)
+		}
+
+		hexP := params.P.Text(16)
+		if !strings.HasPrefix(hexP, prefix) || !strings.HasSuffix(hexP, "ffffffffffffffff") {
+			t.Errorf("%s: p = %s..%s, expected %s..ffffffffffffffff", name, hexP[:24], hexP[len(hexP)-16:], prefix)
+		}
+	}
+}
+
+func TestStandardGroupUnknown(t *testing.T) {
+	if _, err := zkutils.StandardGroup("modp1024"); err == nil {
+		t.Errorf("StandardGroup(modp1024) returned no error")
+	}
+}
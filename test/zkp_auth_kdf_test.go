@@ -0,0 +1,101 @@
+package utils_test
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestDeriveSecretPBKDF2(t *testing.T) {
+	q := new(big.Int)
+	q.SetString("341948486974166000522343609283189", 10)
+
+	kdf := &zkutils.KDFParams{Algorithm: zkutils.KDFPBKDF2SHA256, Salt: []byte("0123456789abcdef"), Time: 1000}
+
+	x, err := zkutils.DeriveSecret("correct horse battery staple", kdf, q)
+	if err != nil {
+		t.Fatalf("DeriveSecret() returned error: %v", err)
+	}
+
+	// our PBKDF2 should match the one in x/crypto
+	// q is 109 bits, so we derive 14 + 16 bytes
+	key := pbkdf2.Key([]byte("correct horse battery staple"), kdf.Salt, 1000, 30, sha256.New)
+	expectedX := new(big.Int).Mod(new(big.Int).SetBytes(key), new(big.Int).Sub(q, big.NewInt(1)))
+	expectedX.Add(expectedX, big.NewInt(1))
+
+	if x.Cmp(expectedX) != 0 {
+		t.Errorf("DeriveSecret() = %d, expected %d", x, expectedX)
+	}
+}
+
+func TestDeriveSecretIsDeterministic(t *testing.T) {
+	q := big.NewInt(11)
+
+	for _, algorithm := range []string{zkutils.KDFArgon2id, zkutils.KDFPBKDF2SHA256} {
+		// cheap parameters, so that the test runs quickly
+		kdf := &zkutils.KDFParams{Algorithm: algorithm, Salt: []byte("0123456789abcdef"), Time: 1, Memory: 64, Threads: 1}
+
+		x1, err := zkutils.DeriveSecret("passphrase", kdf, q)
+		if err != nil {
+			t.Fatalf("DeriveSecret(%s) returned error: %v", algorithm, err)
+		}
+		x2, err := zkutils.DeriveSecret("passphrase", kdf, q)
+		if err != nil {
+			t.Fatalf("DeriveSecret(%s) returned error: %v", algorithm, err)
+		}
+
+		if x1.Cmp(x2) != 0 {
+			t.Errorf("DeriveSecret(%s) = %d and %d, expected the same x", algorithm, x1, x2)
+		}
+		if x1.Sign() <= 0 || x1.Cmp(q) >= 0 {
+			t.Errorf("DeriveSecret(%s) = %d, expected x in [1, %d)", algorithm, x1, q)
+		}
+	}
+}
+
+func TestDeriveSecretDependsOnSalt(t *testing.T) {
+	q := new(big.Int)
+	q.SetString("341948486974166000522343609283189", 10)
+
+	kdf1 := &zkutils.KDFParams{Algorithm: zkutils.KDFArgon2id, Salt: []byte("0123456789abcdef"), Time: 1, Memory: 64, Threads: 1}
+	kdf2 := &zkutils.KDFParams{Algorithm: zkutils.KDFArgon2id, Salt: []byte("fedcba9876543210"), Time: 1, Memory: 64, Threads: 1}
+
+	x1, _ := zkutils.DeriveSecret("passphrase", kdf1, q)
+	x2, _ := zkutils.DeriveSecret("passphrase", kdf2, q)
+
+	if x1.Cmp(x2) == 0 {
+		t.Errorf("DeriveSecret() = %d for two different salts, expected different x", x1)
+	}
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	for _, algorithm := range []string{zkutils.KDFArgon2id, zkutils.KDFPBKDF2SHA256} {
+		kdf, err := zkutils.NewKDFParams(algorithm)
+		if err != nil {
+			t.Fatalf("NewKDFParams(%s) returned error: %v", algorithm, err)
+		}
+		if err := kdf.Validate(); err != nil {
+			t.Errorf("NewKDFParams(%s).Validate() = %v, expected nil", algorithm, err)
+		}
+	}
+
+	invalid := []*zkutils.KDFParams{
+		{Algorithm: "scrypt", Salt: salt, Time: 1},
+		{Algorithm: zkutils.KDFPBKDF2SHA256, Salt: []byte("short"), Time: 1},
+		{Algorithm: zkutils.KDFPBKDF2SHA256, Salt: salt, Time: 0},
+		{Algorithm: zkutils.KDFArgon2id, Salt: salt, Time: 1, Memory: 0, Threads: 1},
+		{Algorithm: zkutils.KDFArgon2id, Salt: salt, Time: 1, Memory: 64, Threads: 0},
+		// a server shouldn't be able to make us allocate 4 TiB
+		{Algorithm: zkutils.KDFArgon2id, Salt: salt, Time: 1, Memory: 1<<32 - 1, Threads: 1},
+	}
+	for _, kdf := range invalid {
+		if err := kdf.Validate(); err == nil {
+			t.Errorf("%+v.Validate() = nil, expected error", kdf)
+		}
+	}
+}
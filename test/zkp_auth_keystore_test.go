@@ -0,0 +1,111 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mischat/zkp_auth/keystore"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	alice := keystore.Identity{Server: "localhost:50051", User: "alice@example.com", Params: "toy"}
+	bob := keystore.Identity{Server: "localhost:50051", User: "bob@example.com", Params: "toy"}
+
+	ks, err := keystore.Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	if err := ks.Unlock("passphrase"); err != nil {
+		t.Fatalf("Unlock() returned error: %v", err)
+	}
+	if err := ks.Put(alice, big.NewInt(6)); err != nil {
+		t.Fatalf("Put() returned error: %v", err)
+	}
+	if err := ks.Put(bob, big.NewInt(7)); err != nil {
+		t.Fatalf("Put() returned error: %v", err)
+	}
+	if err := ks.Save(); err != nil {
+		t.Fatalf("Save() returned error: %v", err)
+	}
+
+	// the identities can be listed without the passphrase
+	ks, err = keystore.Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	if ids := ks.List(); len(ids) != 2 || ids[0] != alice || ids[1] != bob {
+		t.Errorf("List() = %v, expected [%v %v]", ids, alice, bob)
+	}
+
+	// but not the secrets
+	if _, err := ks.Get(alice); !errors.Is(err, keystore.ErrLocked) {
+		t.Errorf("Get() on a locked keystore = %v, expected %v", err, keystore.ErrLocked)
+	}
+
+	if err := ks.Unlock("wrong"); !errors.Is(err, keystore.ErrWrongPassphrase) {
+		t.Errorf("Unlock(wrong) = %v, expected %v", err, keystore.ErrWrongPassphrase)
+	}
+
+	if err := ks.Unlock("passphrase"); err != nil {
+		t.Fatalf("Unlock() returned error: %v", err)
+	}
+	x, err := ks.Get(alice)
+	if err != nil || x.Cmp(big.NewInt(6)) != 0 {
+		t.Errorf("Get(%v) = (%v, %v), expected (6, nil)", alice, x, err)
+	}
+
+	// the same user on another server is a different identity
+	other := keystore.Identity{Server: "example.com:50051", User: "alice@example.com", Params: "toy"}
+	if _, err := ks.Get(other); !errors.Is(err, keystore.ErrNotFound) {
+		t.Errorf("Get(%v) = %v, expected %v", other, err, keystore.ErrNotFound)
+	}
+
+	if !ks.Delete(bob) || ks.Has(bob) {
+		t.Errorf("Delete(%v) did not remove the identity", bob)
+	}
+}
+
+func TestKeystoreDetectsSwappedSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	alice := keystore.Identity{Server: "localhost:50051", User: "alice@example.com", Params: "toy"}
+	mallory := keystore.Identity{Server: "localhost:50051", User: "mallory@example.com", Params: "toy"}
+
+	ks, _ := keystore.Open(path)
+	if err := ks.Unlock("passphrase"); err != nil {
+		t.Fatalf("Unlock() returned error: %v", err)
+	}
+	ks.Put(alice, big.NewInt(6))
+	ks.Put(mallory, big.NewInt(7))
+	if err := ks.Save(); err != nil {
+		t.Fatalf("Save() returned error: %v", err)
+	}
+
+	// Swap the users around in the file, each ciphertext is bound to its identity
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	ids := raw["identities"].([]interface{})
+	ids[0].(map[string]interface{})["user"], ids[1].(map[string]interface{})["user"] = mallory.User, alice.User
+	data, _ = json.Marshal(raw)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, _ = keystore.Open(path)
+	if err := ks.Unlock("passphrase"); err != nil {
+		t.Fatalf("Unlock() returned error: %v", err)
+	}
+	if x, err := ks.Get(alice); err == nil {
+		t.Errorf("Get(%v) = %v after swapping ciphertexts, expected error", alice, x)
+	}
+}
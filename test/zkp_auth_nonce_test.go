@@ -0,0 +1,147 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func TestDeterministicKMatchesRFC6979(t *testing.T) {
+	// RFC 6979 A.2.1, DSA 1024 bits with SHA-256
+	q, _ := new(big.Int).SetString("996F967F6C8E388D9E28D01E205FBA957A5698B1", 16)
+	x, _ := new(big.Int).SetString("411602CB19A6CCC34494D79D98EF1E7ED5AF25F7", 16)
+
+	for msg, expected := range map[string]string{
+		"sample": "519BA0546D0C39202A7D34D7DFA5E760B318BCFB",
+		"test":   "5A67592E8128E03A417B0484410FB72C0B630E1A",
+	} {
+		expectedK, _ := new(big.Int).SetString(expected, 16)
+
+		k, err := zkutils.DeterministicK(x, q, []byte(msg), nil)
+		if err != nil {
+			t.Fatalf("DeterministicK(%s) returned error: %v", msg, err)
+		}
+		if k.Cmp(expectedK) != 0 {
+			t.Errorf("DeterministicK(%s) = %X, expected %X", msg, k, expectedK)
+		}
+	}
+}
+
+func TestHedgedKNeverRepeats(t *testing.T) {
+	q := new(big.Int)
+	q.SetString("341948486974166000522343609283189", 10)
+	x := big.NewInt(6)
+	transcript := []byte("the same login every time")
+
+	tracker := zkutils.NewNonceTracker()
+	for i := 0; i < 1000; i++ {
+		k, err := zkutils.DeterministicK(x, q, transcript, rand.Reader)
+		if err != nil {
+			t.Fatalf("DeterministicK() returned error: %v", err)
+		}
+		if k.Sign() <= 0 || k.Cmp(q) >= 0 {
+			t.Fatalf("DeterministicK() = %d, expected k in [1, %d)", k, q)
+		}
+		if err := tracker.Use(k); err != nil {
+			t.Fatalf("login %d reused k: %d", i, k)
+		}
+	}
+}
+
+// A tracker loaded from what another one exported refuses the ks the first one saw
+func TestNonceTrackerExport(t *testing.T) {
+	first := zkutils.NewNonceTracker()
+	for _, k := range []int64{3, 5, 7} {
+		if err := first.Use(big.NewInt(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	digests := first.Export()
+	if len(digests) != 3 {
+		t.Fatalf("exported %d hashes, expected 3", len(digests))
+	}
+
+	second, err := zkutils.LoadNonceTracker(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Use(big.NewInt(5)); !errors.Is(err, zkutils.ErrNonceReused) {
+		t.Errorf("the loaded tracker took k = 5 again: %v", err)
+	}
+	if err := second.Use(big.NewInt(11)); err != nil {
+		t.Fatal(err)
+	}
+	if exported := second.Export(); len(exported) != 4 || exported[3] == digests[2] {
+		t.Errorf("the loaded tracker exported %v, expected the new k last", exported)
+	}
+
+	for _, bad := range []string{"", "not hex", digests[0][:62]} {
+		if _, err := zkutils.LoadNonceTracker([]string{bad}); err == nil {
+			t.Errorf("loaded '%s' as a hash", bad)
+		}
+	}
+}
+
+func TestHedgedKDependsOnSecretAndRandomness(t *testing.T) {
+	q := new(big.Int)
+	q.SetString("341948486974166000522343609283189", 10)
+	transcript := []byte("login")
+	noise := bytes.Repeat([]byte{0x42}, 32)
+
+	k1, _ := zkutils.DeterministicK(big.NewInt(6), q, transcript, bytes.NewReader(noise))
+	k2, _ := zkutils.DeterministicK(big.NewInt(6), q, transcript, bytes.NewReader(noise))
+	if k1.Cmp(k2) != 0 {
+		t.Errorf("DeterministicK() = %d and %d with the same randomness, expected the same k", k1, k2)
+	}
+
+	// even if the randomness is stuck, a different x gives a different k
+	k3, _ := zkutils.DeterministicK(big.NewInt(7), q, transcript, bytes.NewReader(noise))
+	if k1.Cmp(k3) == 0 {
+		t.Errorf("DeterministicK() = %d for two different secrets, expected different k", k1)
+	}
+
+	// and not enough randomness is an error rather than a weak k
+	if _, err := zkutils.DeterministicK(big.NewInt(6), q, transcript, bytes.NewReader(noise[:8])); err == nil {
+		t.Errorf("DeterministicK() with 8 bytes of randomness returned no error")
+	}
+}
+
+func TestReusedKRevealsSecret(t *testing.T) {
+	// This is why k must never be reused, from the toy example
+	q := big.NewInt(11)
+	x := big.NewInt(6)
+	k := big.NewInt(7)
+	c1 := big.NewInt(4)
+	c2 := big.NewInt(9)
+
+	s1 := zkutils.CalculateS(k, c1, x, q)
+	s2 := zkutils.CalculateS(k, c2, x, q)
+
+	// x = (s1 - s2) / (c2 - c1) mod q
+	diffS := new(big.Int).Sub(s1, s2)
+	diffC := new(big.Int).Sub(c2, c1)
+	recovered := new(big.Int).Mul(diffS, new(big.Int).ModInverse(diffC.Mod(diffC, q), q))
+	recovered.Mod(recovered, q)
+
+	if recovered.Cmp(x) != 0 {
+		t.Errorf("recovered x = %d, expected %d", recovered, x)
+	}
+}
+
+func TestNonceTrackerRefusesReuse(t *testing.T) {
+	tracker := zkutils.NewNonceTracker()
+
+	if err := tracker.Use(big.NewInt(7)); err != nil {
+		t.Errorf("Use(7) = %v, expected nil", err)
+	}
+	if err := tracker.Use(big.NewInt(8)); err != nil {
+		t.Errorf("Use(8) = %v, expected nil", err)
+	}
+	if err := tracker.Use(big.NewInt(7)); !errors.Is(err, zkutils.ErrNonceReused) {
+		t.Errorf("Use(7) again = %v, expected %v", err, zkutils.ErrNonceReused)
+	}
+}
@@ -0,0 +1,599 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mischat/zkp_auth/authserver"
+	"github.com/mischat/zkp_auth/gateway"
+	"github.com/mischat/zkp_auth/oidc"
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// These log alice in over the gateway, and hand her login on to relying parties the OpenID way
+
+var oidcClients = []oidc.Client{
+	{ID: "app", Secret: "app secret", RedirectURIs: []string{"https://app.example.com/callback?from=zkp"}},
+	{ID: "spa", RedirectURIs: []string{"https://spa.example.com/"}},
+}
+
+type oidcServer struct {
+	// The issuer, which the provider's paths are under
+	url string
+	// The gateway, which is always at the root
+	gateway string
+	clock   *testClock
+	params  *zkutils.Params
+}
+
+// This starts the gateway and the provider on one HTTP server, the way server/ runs them
+func startProvider(t testing.TB) *oidcServer {
+	return startProviderAt(t, "")
+}
+
+// This starts the provider with an issuer that has path, the gateway stays at the root
+func startProviderAt(t testing.TB, path string) *oidcServer {
+	params := serverParams(t)
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	srv, err := authserver.New(authserver.Config{Params: params, AuditLog: io.Discard, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.New(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", gw)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+
+	key, err := oidc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := oidc.New(oidc.Config{Issuer: ts.URL + path, Key: key, Clients: oidcClients, Auth: srv, Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Register(mux)
+	return &oidcServer{url: ts.URL + path, gateway: ts.URL, clock: clock, params: params}
+}
+
+// This registers user and logs them in over the gateway, and returns the session ID
+func (o *oidcServer) login(t testing.TB, user string) string {
+	x := nonZeroScalar(t, o.params.Q)
+	y1, y2 := new(big.Int).Exp(o.params.G, x, o.params.P), new(big.Int).Exp(o.params.H, x, o.params.P)
+	if status, gwErr := call(t, http.MethodPost, o.gateway+"/v1/register", map[string]string{"user": user, "y1": y1.String(), "y2": y2.String()}, nil); status != http.StatusOK {
+		t.Fatalf("could not register: %d %v", status, gwErr)
+	}
+	status, gwErr, sessionId := gatewayLogin(t, o.gateway, o.params, user, x)
+	if status != http.StatusOK {
+		t.Fatalf("could not log in: %d %v", status, gwErr)
+	}
+	return sessionId
+}
+
+// oauthError is the body of the provider's errors
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+type authorizeResponse struct {
+	RedirectTo string `json:"redirect_to"`
+	Code       string `json:"code"`
+	IDToken    string `json:"id_token"`
+}
+
+func decodeAnswer(t testing.TB, resp *http.Response, out interface{}) (int, oauthError) {
+	defer resp.Body.Close()
+	var oauthErr oauthError
+	if resp.StatusCode != http.StatusOK {
+		out = &oauthErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("the answer is not JSON: %v", err)
+	}
+	return resp.StatusCode, oauthErr
+}
+
+func (o *oidcServer) authorize(t testing.TB, req map[string]string) (int, authorizeResponse, oauthError) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(o.url+oidc.AuthorizePath, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out authorizeResponse
+	status, oauthErr := decodeAnswer(t, resp, &out)
+	return status, out, oauthErr
+}
+
+// This redeems a code, with the client's credentials in basic auth when basic is set
+func (o *oidcServer) token(t testing.TB, form url.Values, basic *oidc.Client) (int, oidc.TokenResponse, oauthError) {
+	req, err := http.NewRequest(http.MethodPost, o.url+oidc.TokenPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basic != nil {
+		req.SetBasicAuth(url.QueryEscape(basic.ID), url.QueryEscape(basic.Secret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out oidc.TokenResponse
+	status, oauthErr := decodeAnswer(t, resp, &out)
+	return status, out, oauthErr
+}
+
+// This fetches the keys the way a relying party does, through the discovery document
+func (o *oidcServer) keys(t testing.TB) []oidc.JWK {
+	var discovery oidc.Discovery
+	resp, err := http.Get(o.url + oidc.DiscoveryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, oauthErr := decodeAnswer(t, resp, &discovery); status != http.StatusOK {
+		t.Fatalf("could not fetch the discovery document: %d %v", status, oauthErr)
+	}
+	if discovery.Issuer != o.url || discovery.TokenEndpoint != o.url+"/token" || discovery.AuthorizationEndpoint != o.url+"/v1/authorize" {
+		t.Errorf("the discovery document is for the wrong URLs: %+v", discovery)
+	}
+
+	var jwks struct {
+		Keys []oidc.JWK `json:"keys"`
+	}
+	resp, err = http.Get(discovery.JWKSURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, oauthErr := decodeAnswer(t, resp, &jwks); status != http.StatusOK {
+		t.Fatalf("could not fetch the keys: %d %v", status, oauthErr)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != "EdDSA" || jwks.Keys[0].Crv != "Ed25519" {
+		t.Fatalf("the keys are %+v, expected one Ed25519 key", jwks.Keys)
+	}
+	return jwks.Keys
+}
+
+// This checks the ID token the way a relying party does
+func (o *oidcServer) checkIDToken(t testing.TB, token string, client string, nonce string) oidc.IDTokenClaims {
+	var claims oidc.IDTokenClaims
+	if err := oidc.VerifyJWT(token, o.keys(t), &claims); err != nil {
+		t.Fatalf("the ID token does not verify: %v", err)
+	}
+	now := o.clock.Now().Unix()
+	if claims.Issuer != o.url || claims.Audience != client || claims.Nonce != nonce || claims.IssuedAt != now || claims.ExpiresAt <= now {
+		t.Errorf("the ID token has the wrong claims: %+v", claims)
+	}
+	if len(claims.AMR) != 1 || claims.AMR[0] != "zkp" || claims.AuthTime != now {
+		t.Errorf("the ID token says the wrong things about the login: %+v", claims)
+	}
+	return claims
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	o := startProvider(t)
+	sessionId := o.login(t, "alice@example.com")
+	app := oidcClients[0]
+
+	status, authz, oauthErr := o.authorize(t, map[string]string{
+		"session_id":    sessionId,
+		"client_id":     app.ID,
+		"redirect_uri":  app.RedirectURIs[0],
+		"response_type": "code",
+		"scope":         "openid",
+		"state":         "xyz",
+		"nonce":         "n-0S6",
+	})
+	if status != http.StatusOK {
+		t.Fatalf("could not authorize: %d %v", status, oauthErr)
+	}
+	redirect, err := url.Parse(authz.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Host != "app.example.com" || redirect.Query().Get("code") != authz.Code || redirect.Query().Get("state") != "xyz" || redirect.Query().Get("from") != "zkp" {
+		t.Errorf("redirected to '%s', expected the callback with the code, the state and its own query", authz.RedirectTo)
+	}
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {authz.Code}, "redirect_uri": {app.RedirectURIs[0]}}
+	status, tokens, oauthErr := o.token(t, form, &app)
+	if status != http.StatusOK {
+		t.Fatalf("could not redeem the code: %d %v", status, oauthErr)
+	}
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn != int64(oidc.DefaultTokenTTL/time.Second) || tokens.Scope != "openid" {
+		t.Errorf("the token response is %+v", tokens)
+	}
+	if claims := o.checkIDToken(t, tokens.IDToken, app.ID, "n-0S6"); claims.Subject != "alice@example.com" {
+		t.Errorf("the ID token is for '%s', expected alice@example.com", claims.Subject)
+	}
+
+	var access oidc.AccessTokenClaims
+	if err := oidc.VerifyJWT(tokens.AccessToken, o.keys(t), &access); err != nil {
+		t.Fatalf("the access token does not verify: %v", err)
+	}
+	if access.Subject != "alice@example.com" || access.ClientID != app.ID || access.ID == "" {
+		t.Errorf("the access token has the wrong claims: %+v", access)
+	}
+
+	// a code can only be redeemed once
+	if status, _, oauthErr := o.token(t, form, &app); status != http.StatusBadRequest || oauthErr.Error != "invalid_grant" {
+		t.Errorf("redeeming the code again answered %d %v, expected invalid_grant", status, oauthErr)
+	}
+}
+
+// An issuer with a path has the provider's paths under it, so that it can share a host with other things
+func TestOIDCIssuerPath(t *testing.T) {
+	o := startProviderAt(t, "/auth")
+	sessionId := o.login(t, "alice@example.com")
+	app := oidcClients[0]
+
+	// keys fetches the discovery document from under /auth, and checks the endpoints it advertises are there too
+	keys := o.keys(t)
+	if len(keys) != 1 {
+		t.Fatalf("expected one key, got %d", len(keys))
+	}
+	for _, path := range []string{oidc.DiscoveryPath, oidc.JWKSPath} {
+		resp, err := http.Get(o.gateway + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s is served at the root too, with %d", path, resp.StatusCode)
+		}
+	}
+
+	status, authz, oauthErr := o.authorize(t, map[string]string{
+		"session_id":    sessionId,
+		"client_id":     app.ID,
+		"redirect_uri":  app.RedirectURIs[0],
+		"response_type": "code",
+		"scope":         "openid",
+		"nonce":         "n-0S6",
+	})
+	if status != http.StatusOK {
+		t.Fatalf("could not authorize at %s: %d %v", o.url+oidc.AuthorizePath, status, oauthErr)
+	}
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {authz.Code}, "redirect_uri": {app.RedirectURIs[0]}}
+	status, tokens, oauthErr := o.token(t, form, &app)
+	if status != http.StatusOK {
+		t.Fatalf("could not redeem the code at %s: %d %v", o.url+oidc.TokenPath, status, oauthErr)
+	}
+	if claims := o.checkIDToken(t, tokens.IDToken, app.ID, "n-0S6"); claims.Subject != "alice@example.com" {
+		t.Errorf("the ID token is for '%s', expected alice@example.com", claims.Subject)
+	}
+}
+
+func TestOIDCImplicitFlow(t *testing.T) {
+	o := startProvider(t)
+	sessionId := o.login(t, "alice@example.com")
+	spa := oidcClients[1]
+
+	req := map[string]string{
+		"session_id":    sessionId,
+		"client_id":     spa.ID,
+		"redirect_uri":  spa.RedirectURIs[0],
+		"response_type": "id_token",
+		"scope":         "openid",
+		"state":         "abc",
+	}
+	if status, _, oauthErr := o.authorize(t, req); status != http.StatusBadRequest || oauthErr.Error != "invalid_request" {
+		t.Errorf("an id_token without a nonce answered %d %v, expected invalid_request", status, oauthErr)
+	}
+
+	req["nonce"] = "n-1"
+	status, authz, oauthErr := o.authorize(t, req)
+	if status != http.StatusOK {
+		t.Fatalf("could not authorize: %d %v", status, oauthErr)
+	}
+	redirect, err := url.Parse(authz.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := url.ParseQuery(redirect.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.RawQuery != "" || fragment.Get("id_token") != authz.IDToken || fragment.Get("state") != "abc" {
+		t.Errorf("redirected to '%s', expected the ID token and the state in the fragment", authz.RedirectTo)
+	}
+	o.checkIDToken(t, authz.IDToken, spa.ID, "n-1")
+
+	// any change to the token breaks the signature
+	parts := strings.Split(authz.IDToken, ".")
+	forged, _ := json.Marshal(oidc.IDTokenClaims{Issuer: o.url, Subject: "mallory@example.com", Audience: spa.ID})
+	parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+	var claims oidc.IDTokenClaims
+	if err := oidc.VerifyJWT(strings.Join(parts, "."), o.keys(t), &claims); err == nil {
+		t.Error("a token with other claims verified")
+	}
+}
+
+func TestOIDCPKCE(t *testing.T) {
+	o := startProvider(t)
+	sessionId := o.login(t, "alice@example.com")
+	spa := oidcClients[1]
+
+	req := map[string]string{
+		"session_id":    sessionId,
+		"client_id":     spa.ID,
+		"redirect_uri":  spa.RedirectURIs[0],
+		"response_type": "code",
+		"scope":         "openid profile",
+	}
+	if status, _, oauthErr := o.authorize(t, req); status != http.StatusBadRequest || oauthErr.Error != "invalid_request" {
+		t.Errorf("a public client without PKCE answered %d %v, expected invalid_request", status, oauthErr)
+	}
+	req["code_challenge"] = "whatever"
+	req["code_challenge_method"] = "plain"
+	if status, _, oauthErr := o.authorize(t, req); status != http.StatusBadRequest || oauthErr.Error != "invalid_request" {
+		t.Errorf("a plain code_challenge answered %d %v, expected invalid_request", status, oauthErr)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := sha256.Sum256([]byte(verifier))
+	req["code_challenge"] = base64.RawURLEncoding.EncodeToString(challenge[:])
+	req["code_challenge_method"] = "S256"
+
+	for _, tc := range []struct {
+		name     string
+		verifier string
+		status   int
+	}{
+		{"wrong verifier", "not the verifier", http.StatusBadRequest},
+		{"no verifier", "", http.StatusBadRequest},
+		{"right verifier", verifier, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, authz, oauthErr := o.authorize(t, req)
+			if status != http.StatusOK {
+				t.Fatalf("could not authorize: %d %v", status, oauthErr)
+			}
+			form := url.Values{"grant_type": {"authorization_code"}, "code": {authz.Code}, "redirect_uri": {spa.RedirectURIs[0]}, "client_id": {spa.ID}, "code_verifier": {tc.verifier}}
+			if status, _, oauthErr := o.token(t, form, nil); status != tc.status {
+				t.Errorf("answered %d %v, expected %d", status, oauthErr, tc.status)
+			}
+		})
+	}
+}
+
+// A relying party that only speaks OpenID Connect sends the user to the authorization endpoint
+// of the discovery document, the login page logs them in over the WebSocket, and the code the
+// user comes back with is redeemed at the token endpoint
+func TestOIDCLoginPage(t *testing.T) {
+	o := startProviderAt(t, "/auth")
+	x := registerOverGateway(t, o.gateway, o.params, "alice@example.com")
+	spa := oidcClients[1]
+	// the browser, which the test follows the redirects for
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	var discovery oidc.Discovery
+	resp, err := http.Get(o.url + oidc.DiscoveryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, oauthErr := decodeAnswer(t, resp, &discovery); status != http.StatusOK {
+		t.Fatalf("could not fetch the discovery document: %d %v", status, oauthErr)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := sha256.Sum256([]byte(verifier))
+	authorizeURL := func(change func(query url.Values)) string {
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {spa.ID},
+			"redirect_uri":          {spa.RedirectURIs[0]},
+			"scope":                 {"openid"},
+			"state":                 {"xyz"},
+			"nonce":                 {"n-0S6"},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		change(query)
+		return discovery.AuthorizationEndpoint + "?" + query.Encode()
+	}
+
+	// the login page, with the request it was opened for
+	resp, err = browser.Get(authorizeURL(func(url.Values) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("the authorization endpoint answered %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	action := regexp.MustCompile(`<form id="done" method="post" action="([^"]+)">`).FindSubmatch(page)
+	requestId := regexp.MustCompile(`name="request_id" value="([^"]+)"`).FindSubmatch(page)
+	if action == nil || requestId == nil || !bytes.Contains(page, []byte("/v1/login/ws")) {
+		t.Fatalf("the login page has no form to finish the login with:\n%s", page)
+	}
+	finishURL, err := resp.Request.URL.Parse(string(action[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what the page's script does: log in over the WebSocket, and post the session
+	reply := socketLogin(t, dialLogin(t, o.gateway), o.params, "alice@example.com", x)
+	if reply.Type != "session" {
+		t.Fatalf("the login was answered with %+v", reply)
+	}
+	finish := url.Values{"request_id": {string(requestId[1])}, "session_id": {reply.SessionId}}
+	resp, err = browser.PostForm(finishURL.String(), finish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	redirect, err := resp.Location()
+	if resp.StatusCode != http.StatusSeeOther || err != nil {
+		t.Fatalf("finishing the login answered %d, expected a redirect", resp.StatusCode)
+	}
+	if redirect.Host != "spa.example.com" || redirect.Query().Get("state") != "xyz" || redirect.Query().Get("code") == "" {
+		t.Fatalf("redirected to '%s', expected the callback with a code and the state", redirect)
+	}
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {redirect.Query().Get("code")}, "redirect_uri": {spa.RedirectURIs[0]}, "client_id": {spa.ID}, "code_verifier": {verifier}}
+	status, tokens, oauthErr := o.token(t, form, nil)
+	if status != http.StatusOK {
+		t.Fatalf("could not redeem the code: %d %v", status, oauthErr)
+	}
+	if claims := o.checkIDToken(t, tokens.IDToken, spa.ID, "n-0S6"); claims.Subject != "alice@example.com" {
+		t.Errorf("the ID token is for '%s', expected alice@example.com", claims.Subject)
+	}
+
+	// a login page can only be finished once
+	resp, err = browser.PostForm(finishURL.String(), finish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, oauthErr := decodeAnswer(t, resp, nil); status != http.StatusBadRequest || oauthErr.Error != "invalid_request" {
+		t.Errorf("finishing the login again answered %d %v, expected invalid_request", status, oauthErr)
+	}
+
+	// once the redirect URI is checked, errors go back to the relying party
+	resp, err = browser.Get(authorizeURL(func(query url.Values) { query.Set("scope", "profile") }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	redirect, err = resp.Location()
+	if resp.StatusCode != http.StatusFound || err != nil || redirect.Query().Get("error") != "invalid_scope" || redirect.Query().Get("state") != "xyz" {
+		t.Errorf("a request without the openid scope answered %d '%v', expected a redirect with invalid_scope", resp.StatusCode, redirect)
+	}
+	// and before, they can't
+	resp, err = browser.Get(authorizeURL(func(query url.Values) { query.Set("redirect_uri", "https://evil.example.com/") }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, oauthErr := decodeAnswer(t, resp, nil); status != http.StatusBadRequest || oauthErr.Error != "invalid_request" {
+		t.Errorf("an unregistered redirect URI answered %d %v, expected invalid_request", status, oauthErr)
+	}
+
+	// a login page that was left open for too long can't be finished
+	resp, err = browser.Get(authorizeURL(func(url.Values) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestId = regexp.MustCompile(`name="request_id" value="([^"]+)"`).FindSubmatch(page)
+	if requestId == nil {
+		t.Fatal("the login page has no request_id")
+	}
+	o.clock.advance(oidc.DefaultLoginTTL)
+	resp, err = browser.PostForm(finishURL.String(), url.Values{"request_id": {string(requestId[1])}, "session_id": {reply.SessionId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, oauthErr := decodeAnswer(t, resp, nil); status != http.StatusBadRequest || !strings.Contains(oauthErr.Description, "expired") {
+		t.Errorf("finishing an expired login answered %d %v, expected it to have expired", status, oauthErr)
+	}
+}
+
+func TestOIDCErrors(t *testing.T) {
+	o := startProvider(t)
+	sessionId := o.login(t, "alice@example.com")
+	app, spa := oidcClients[0], oidcClients[1]
+
+	authorizeReq := func(change func(req map[string]string)) map[string]string {
+		req := map[string]string{
+			"session_id":    sessionId,
+			"client_id":     app.ID,
+			"redirect_uri":  app.RedirectURIs[0],
+			"response_type": "code",
+			"scope":         "openid",
+		}
+		change(req)
+		return req
+	}
+	for _, tc := range []struct {
+		name   string
+		req    map[string]string
+		status int
+		code   string
+	}{
+		{"unknown client", authorizeReq(func(req map[string]string) { req["client_id"] = "nobody" }), http.StatusBadRequest, "unauthorized_client"},
+		{"unregistered redirect", authorizeReq(func(req map[string]string) { req["redirect_uri"] = "https://evil.example.com/callback" }), http.StatusBadRequest, "invalid_request"},
+		{"redirect of another client", authorizeReq(func(req map[string]string) { req["redirect_uri"] = spa.RedirectURIs[0] }), http.StatusBadRequest, "invalid_request"},
+		{"no openid scope", authorizeReq(func(req map[string]string) { req["scope"] = "profile" }), http.StatusBadRequest, "invalid_scope"},
+		{"token response type", authorizeReq(func(req map[string]string) { req["response_type"] = "token" }), http.StatusBadRequest, "unsupported_response_type"},
+		{"unknown session", authorizeReq(func(req map[string]string) { req["session_id"] = "nothing" }), http.StatusUnauthorized, "login_required"},
+	} {
+		t.Run("authorize "+tc.name, func(t *testing.T) {
+			if status, _, oauthErr := o.authorize(t, tc.req); status != tc.status || oauthErr.Error != tc.code {
+				t.Errorf("answered %d %v, expected %d %s", status, oauthErr, tc.status, tc.code)
+			}
+		})
+	}
+
+	code := func(t *testing.T) string {
+		status, authz, oauthErr := o.authorize(t, authorizeReq(func(map[string]string) {}))
+		if status != http.StatusOK {
+			t.Fatalf("could not authorize: %d %v", status, oauthErr)
+		}
+		return authz.Code
+	}
+	wrongSecret := oidc.Client{ID: app.ID, Secret: "guess"}
+	for _, tc := range []struct {
+		name string
+		// this changes the form and the client of a good token request
+		change func(t *testing.T, form url.Values) *oidc.Client
+		status int
+		code   string
+	}{
+		{"wrong secret", func(t *testing.T, form url.Values) *oidc.Client { return &wrongSecret }, http.StatusUnauthorized, "invalid_client"},
+		{"no secret", func(t *testing.T, form url.Values) *oidc.Client { form.Set("client_id", app.ID); return nil }, http.StatusUnauthorized, "invalid_client"},
+		{"secret in the form", func(t *testing.T, form url.Values) *oidc.Client {
+			form.Set("client_id", app.ID)
+			form.Set("client_secret", app.Secret)
+			return nil
+		}, http.StatusOK, ""},
+		{"wrong redirect", func(t *testing.T, form url.Values) *oidc.Client {
+			form.Set("redirect_uri", "https://app.example.com/callback")
+			return &app
+		}, http.StatusBadRequest, "invalid_grant"},
+		{"another client's code", func(t *testing.T, form url.Values) *oidc.Client { form.Set("client_id", spa.ID); return nil }, http.StatusBadRequest, "invalid_grant"},
+		{"unknown code", func(t *testing.T, form url.Values) *oidc.Client { form.Set("code", "nothing"); return &app }, http.StatusBadRequest, "invalid_grant"},
+		{"expired code", func(t *testing.T, form url.Values) *oidc.Client { o.clock.advance(oidc.DefaultCodeTTL); return &app }, http.StatusBadRequest, "invalid_grant"},
+		{"refresh token grant", func(t *testing.T, form url.Values) *oidc.Client { form.Set("grant_type", "refresh_token"); return &app }, http.StatusBadRequest, "unsupported_grant_type"},
+	} {
+		t.Run("token "+tc.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {"authorization_code"}, "code": {code(t)}, "redirect_uri": {app.RedirectURIs[0]}}
+			client := tc.change(t, form)
+			if status, _, oauthErr := o.token(t, form, client); status != tc.status || oauthErr.Error != tc.code {
+				t.Errorf("answered %d %v, expected %d %s", status, oauthErr, tc.status, tc.code)
+			}
+		})
+	}
+
+	if _, err := oidc.New(oidc.Config{Issuer: "not a URL", Key: make([]byte, 64), Auth: nil}); err == nil {
+		t.Error("a provider was set up without an issuer URL")
+	}
+}
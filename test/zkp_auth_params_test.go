@@ -0,0 +1,283 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func TestGenerateParams(t *testing.T) {
+	// small sizes so that the test runs quickly, scripts/gennumbers defaults to 2048 and 256
+	params, err := zkutils.GenerateParams(rand.Reader, 512, 160, nil)
+	if err != nil {
+		t.Fatalf("GenerateParams() returned error: %v", err)
+	}
+
+	if params.P.BitLen() != 512 || params.Q.BitLen() != 160 {
+		t.Errorf("GenerateParams() gave p of %d bits and q of %d bits, expected 512 and 160", params.P.BitLen(), params.Q.BitLen())
+	}
+
+	// q divides p - 1
+	pMinusOne := new(big.Int).Sub(params.P, big.NewInt(1))
+	if new(big.Int).Mod(pMinusOne, params.Q).Sign() != 0 {
+		t.Errorf("q:'%d' does not divide p-1 where p:'%d'", params.Q, params.P)
+	}
+
+	// g and h have order q, and are neither 1 nor each other
+	for name, e := range map[string]*big.Int{"g": params.G, "h": params.H} {
+		if e.Cmp(big.NewInt(1)) == 0 {
+			t.Errorf("%s is 1", name)
+		}
+		if new(big.Int).Exp(e, params.Q, params.P).Cmp(big.NewInt(1)) != 0 {
+			t.Errorf("%s:'%d' does not have order q", name, e)
+		}
+	}
+	if params.G.Cmp(params.H) == 0 {
+		t.Errorf("g and h are both '%d'", params.G)
+	}
+
+	valid, err := zkutils.ValidatePublicVariables(params.P, params.Q, params.G, params.H)
+	if !valid || err != nil {
+		t.Errorf("ValidatePublicVariables() = (%t, %v) for generated parameters, expected (true, nil)", valid, err)
+	}
+
+	// g and h come from a random seed, which is recorded
+	if err := zkutils.VerifyDerivation(params); err != nil {
+		t.Errorf("VerifyDerivation() = %v for generated parameters, expected nil", err)
+	}
+}
+
+func TestGenerateParamsRejectsBadSizes(t *testing.T) {
+	if _, err := zkutils.GenerateParams(rand.Reader, 256, 256, nil); err == nil {
+		t.Errorf("GenerateParams(256, 256) returned no error")
+	}
+}
+
+func TestParamsFileRoundTrip(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatalf("ParseParams() returned error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "params.json")
+	if err := zkutils.WriteParamsFile(path, params); err != nil {
+		t.Fatalf("WriteParamsFile() returned error: %v", err)
+	}
+
+	read, err := zkutils.ReadParamsFile(path)
+	if err != nil {
+		t.Fatalf("ReadParamsFile() returned error: %v", err)
+	}
+	if !read.Equal(params) {
+		t.Errorf("ReadParamsFile() = %+v, expected %+v", read, params)
+	}
+
+	// numbers are decimal strings, so that big numbers survive other JSON parsers
+	var bad zkutils.Params
+	if err := json.Unmarshal([]byte(`{"p": "23", "q": "eleven", "g": "4", "h": "9"}`), &bad); err == nil {
+		t.Errorf("json.Unmarshal() of a non-number q returned no error")
+	}
+}
+
+func TestDeriveParamsIsVerifiable(t *testing.T) {
+	p := new(big.Int)
+	p.SetString("115792089237316195423570985008687907852837564279074904382605163141518161494337", 10)
+	q := new(big.Int)
+	q.SetString("341948486974166000522343609283189", 10)
+	seed := []byte("zkp_auth nothing up my sleeve")
+
+	params, err := zkutils.DeriveParams(p, q, seed)
+	if err != nil {
+		t.Fatalf("DeriveParams() returned error: %v", err)
+	}
+
+	// the same seed always gives the same g and h
+	again, _ := zkutils.DeriveParams(p, q, seed)
+	if !params.Equal(again) {
+		t.Errorf("DeriveParams() = %+v and %+v for the same seed, expected the same", params, again)
+	}
+
+	valid, err := zkutils.ValidatePublicVariables(params.P, params.Q, params.G, params.H)
+	if !valid || err != nil {
+		t.Errorf("ValidatePublicVariables() = (%t, %v) for derived parameters, expected (true, nil)", valid, err)
+	}
+
+	if err := zkutils.VerifyDerivation(params); err != nil {
+		t.Errorf("VerifyDerivation() = %v, expected nil", err)
+	}
+
+	// h = g^2 is in the group, but whoever set it knows log_g h
+	trapdoor := *params
+	trapdoor.H = new(big.Int).Exp(params.G, big.NewInt(2), params.P)
+	if err := zkutils.VerifyDerivation(&trapdoor); err == nil {
+		t.Errorf("VerifyDerivation() = nil for h = g^2, expected error")
+	}
+
+	// a different seed doesn't match either
+	otherSeed := *params
+	otherSeed.Derivation = &zkutils.Derivation{Seed: []byte("another seed"), GCounter: 1, HCounter: 1}
+	if err := zkutils.VerifyDerivation(&otherSeed); err == nil {
+		t.Errorf("VerifyDerivation() = nil for the wrong seed, expected error")
+	}
+
+	// and there is nothing to verify without a seed
+	noSeed := *params
+	noSeed.Derivation = nil
+	if err := zkutils.VerifyDerivation(&noSeed); err == nil {
+		t.Errorf("VerifyDerivation() = nil without a seed, expected error")
+	}
+}
+
+func TestDerivedParamsFileRoundTrip(t *testing.T) {
+	p := new(big.Int)
+	p.SetString("115792089237316195423570985008687907852837564279074904382605163141518161494337", 10)
+	q := new(big.Int)
+	q.SetString("341948486974166000522343609283189", 10)
+
+	params, err := zkutils.DeriveParams(p, q, []byte("zkp_auth nothing up my sleeve"))
+	if err != nil {
+		t.Fatalf("DeriveParams() returned error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "params.json")
+	if err := zkutils.WriteParamsFile(path, params); err != nil {
+		t.Fatalf("WriteParamsFile() returned error: %v", err)
+	}
+	read, err := zkutils.ReadParamsFile(path)
+	if err != nil {
+		t.Fatalf("ReadParamsFile() returned error: %v", err)
+	}
+
+	// the seed and counters survive, so the file can be verified on its own
+	if err := zkutils.VerifyDerivation(read); err != nil {
+		t.Errorf("VerifyDerivation() = %v after reading the file back, expected nil", err)
+	}
+}
+
+func TestParamsFingerprint(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatalf("ParseParams() returned error: %v", err)
+	}
+
+	// the fingerprint is the SHA-256 of the canonical form of p, q, g and h
+	sum := sha256.Sum256([]byte(`{"p":"23","q":"11","g":"4","h":"9"}`))
+	if params.Fingerprint() != hex.EncodeToString(sum[:]) {
+		t.Errorf("Fingerprint() = %s, expected %x", params.Fingerprint(), sum)
+	}
+	if params.ShortFingerprint() != params.Fingerprint()[:16] {
+		t.Errorf("ShortFingerprint() = %s, expected the start of %s", params.ShortFingerprint(), params.Fingerprint())
+	}
+
+	// the description of the parameter set is not part of the fingerprint
+	named := *params
+	named.Name = "toy"
+	named.Type = zkutils.GroupTypeSchnorr
+	named.Created = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	named.Derivation = &zkutils.Derivation{Seed: []byte("seed"), GCounter: 1, HCounter: 2}
+	if named.Fingerprint() != params.Fingerprint() {
+		t.Errorf("Fingerprint() = %s for a named copy, expected %s", named.Fingerprint(), params.Fingerprint())
+	}
+
+	// but the group is
+	other, _ := zkutils.ParseParams("23", "11", "9", "4")
+	if other.Fingerprint() == params.Fingerprint() {
+		t.Errorf("Fingerprint() is the same with g and h swapped")
+	}
+
+	for _, pin := range []string{params.Fingerprint(), params.ShortFingerprint(), strings.ToUpper(params.Fingerprint())} {
+		if !params.MatchesFingerprint(pin) {
+			t.Errorf("MatchesFingerprint(%s) = false, expected true", pin)
+		}
+	}
+	for _, pin := range []string{"", params.Fingerprint()[:4], other.Fingerprint()} {
+		if params.MatchesFingerprint(pin) {
+			t.Errorf("MatchesFingerprint(%s) = true, expected false", pin)
+		}
+	}
+}
+
+func TestParamsFormats(t *testing.T) {
+	params, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatalf("StandardGroup() returned error: %v", err)
+	}
+	params.Created = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, format := range []string{zkutils.ParamsFormatJSON, zkutils.ParamsFormatPEM} {
+		data, err := zkutils.MarshalParams(params, format)
+		if err != nil {
+			t.Fatalf("MarshalParams(%s) returned error: %v", format, err)
+		}
+
+		read, err := zkutils.UnmarshalParams(data)
+		if err != nil {
+			t.Fatalf("UnmarshalParams(%s) returned error: %v", format, err)
+		}
+		if !read.Equal(params) || read.Name != "modp2048" || read.Type != zkutils.GroupTypeSafePrime || !read.Created.Equal(params.Created) {
+			t.Errorf("UnmarshalParams(%s) = %+v, expected %+v", format, read, params)
+		}
+		if err := zkutils.VerifyDerivation(read); err != nil {
+			t.Errorf("VerifyDerivation() = %v after a %s round trip, expected nil", err, format)
+		}
+	}
+
+	if _, err := zkutils.MarshalParams(params, "xml"); err == nil {
+		t.Errorf("MarshalParams(xml) returned no error")
+	}
+
+	// the canonical form doesn't change between runs
+	canonical, _ := params.MarshalJSON()
+	again, _ := params.MarshalJSON()
+	if !bytes.Equal(canonical, again) || bytes.ContainsAny(canonical, " \n") {
+		t.Errorf("MarshalJSON() = %s, expected the same compact form every time", canonical)
+	}
+}
+
+func TestArmoredParamsMustMatchTheirHeaders(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatalf("ParseParams() returned error: %v", err)
+	}
+	params.Name = "toy"
+
+	data, err := zkutils.MarshalParams(params, zkutils.ParamsFormatPEM)
+	if err != nil {
+		t.Fatalf("MarshalParams() returned error: %v", err)
+	}
+
+	other, _ := zkutils.ParseParams("23", "11", "9", "4")
+	for name, tampered := range map[string][]byte{
+		"fingerprint": bytes.Replace(data, []byte(params.Fingerprint()), []byte(other.Fingerprint()), 1),
+		"name":        bytes.Replace(data, []byte("Name: toy"), []byte("Name: real"), 1),
+		"block type":  bytes.ReplaceAll(data, []byte("ZKP AUTH PARAMETERS"), []byte("CERTIFICATE")),
+	} {
+		if _, err := zkutils.UnmarshalParams(tampered); err == nil {
+			t.Errorf("UnmarshalParams() with a changed %s returned no error", name)
+		}
+	}
+
+	// files ending in .pem are armored
+	path := filepath.Join(t.TempDir(), "params.pem")
+	if err := zkutils.WriteParamsFile(path, params); err != nil {
+		t.Fatalf("WriteParamsFile() returned error: %v", err)
+	}
+	written, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(written, []byte("-----BEGIN ZKP AUTH PARAMETERS-----")) {
+		t.Errorf("WriteParamsFile(%s) wrote %s, expected an armored block", path, written)
+	}
+	read, err := zkutils.ReadParamsFile(path)
+	if err != nil || !read.Equal(params) {
+		t.Errorf("ReadParamsFile(%s) = (%+v, %v), expected %+v", path, read, err, params)
+	}
+}
@@ -0,0 +1,653 @@
+package utils_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mischat/zkp_auth/authserver"
+	pb "github.com/mischat/zkp_auth/pb"
+	zkutils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// These run the server in process, over an in-memory connection, and log in the way the client does
+
+// testClock is a clock the tests move on by hand
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func serverParams(t testing.TB) *zkutils.Params {
+	params, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+// This starts a server for the config over bufconn, and returns a client for it
+// Everything is stopped when the test ends
+func startServer(t testing.TB, cfg authserver.Config) pb.AuthClient {
+	if cfg.AuditLog == nil {
+		cfg.AuditLog = io.Discard
+	}
+	srv, err := authserver.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serve(t, srv)
+}
+
+// This serves srv over bufconn, and returns a client for it
+func serve(t testing.TB, srv *authserver.Server) pb.AuthClient {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterAuthServer(s, srv)
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+		srv.Close()
+	})
+	return pb.NewAuthClient(conn)
+}
+
+func register(t testing.TB, c pb.AuthClient, params *zkutils.Params, user string, x *big.Int) {
+	y1 := new(big.Int).Exp(params.G, x, params.P)
+	y2 := new(big.Int).Exp(params.H, x, params.P)
+	if _, err := c.Register(context.Background(), &pb.RegisterRequest{User: user, Y1: y1.String(), Y2: y2.String()}); err != nil {
+		t.Fatalf("could not register '%s': %v", user, err)
+	}
+}
+
+// This asks for a challenge, and returns the auth ID, the challenge and k
+func challenge(c pb.AuthClient, params *zkutils.Params, user string) (string, *big.Int, *big.Int, error) {
+	k, err := zkutils.RandomScalar(params.Q, rand.Reader)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	r1 := new(big.Int).Exp(params.G, k, params.P)
+	r2 := new(big.Int).Exp(params.H, k, params.P)
+
+	resp, err := c.CreateAuthenticationChallenge(context.Background(), &pb.AuthenticationChallengeRequest{User: user, R1: r1.String(), R2: r2.String()})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	chal, ok := new(big.Int).SetString(resp.GetC(), 10)
+	if !ok {
+		return "", nil, nil, fmt.Errorf("c is not a number: '%s'", resp.GetC())
+	}
+	return resp.GetAuthId(), chal, k, nil
+}
+
+func answer(c pb.AuthClient, authId string, s *big.Int) (string, error) {
+	resp, err := c.VerifyAuthentication(context.Background(), &pb.AuthenticationAnswerRequest{AuthId: authId, S: s.String()})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetSessionId(), nil
+}
+
+// login runs the whole of the login, and returns the session ID
+func login(c pb.AuthClient, params *zkutils.Params, user string, x *big.Int) (string, error) {
+	authId, chal, k, err := challenge(c, params, user)
+	if err != nil {
+		return "", err
+	}
+	return answer(c, authId, zkutils.CalculateS(k, chal, x, params.Q))
+}
+
+func TestServerRegisterAndLogin(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+
+	register(t, c, params, "alice@example.com", x)
+
+	sessionId, err := login(c, params, "alice@example.com", x)
+	if err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+
+	who, err := c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: sessionId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if who.GetUser() != "alice@example.com" {
+		t.Errorf("the session belongs to '%s', expected 'alice@example.com'", who.GetUser())
+	}
+
+	public, err := c.GetPublicParameters(context.Background(), &pb.PublicParametersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.GetP() != params.P.String() || public.GetQ() != params.Q.String() || public.GetG() != params.G.String() || public.GetH() != params.H.String() {
+		t.Error("the server returned different public variables")
+	}
+
+	// the same user can't register twice
+	y := new(big.Int).Exp(params.G, x, params.P).String()
+	if _, err := c.Register(context.Background(), &pb.RegisterRequest{User: "alice@example.com", Y1: y, Y2: y}); err == nil {
+		t.Error("alice@example.com was registered twice")
+	}
+
+	if _, err := c.Logout(context.Background(), &pb.LogoutRequest{SessionId: sessionId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: sessionId}); err == nil {
+		t.Error("the session outlived the logout")
+	}
+}
+
+func TestServerWrongSecret(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+
+	register(t, c, params, "alice@example.com", x)
+
+	wrong := new(big.Int).Add(x, big.NewInt(1))
+	if _, err := login(c, params, "alice@example.com", wrong); err == nil {
+		t.Fatal("logged in with the wrong secret")
+	} else if !strings.Contains(status.Convert(err).Message(), zkutils.ErrR1Mismatch.Error()) {
+		t.Errorf("the wrong secret failed with '%v', expected a mismatch", err)
+	}
+
+	// and the right one still works afterwards
+	if _, err := login(c, params, "alice@example.com", x); err != nil {
+		t.Errorf("could not log in with the right secret: %v", err)
+	}
+}
+
+func TestServerUnknownUser(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+
+	if _, _, _, err := challenge(c, params, "nobody@example.com"); err == nil {
+		t.Error("an unknown user got a challenge")
+	}
+	if _, err := answer(c, "not an auth ID", big.NewInt(1)); err == nil {
+		t.Error("an unknown auth ID was answered")
+	}
+}
+
+func TestServerReplayedAuthId(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+
+	register(t, c, params, "alice@example.com", x)
+
+	authId, chal, k, err := challenge(c, params, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := zkutils.CalculateS(k, chal, x, params.Q)
+	if _, err := answer(c, authId, s); err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+	// an eavesdropper sends the same answer again
+	if _, err := answer(c, authId, s); err == nil {
+		t.Error("the same auth ID was answered twice")
+	}
+
+	// a wrong answer uses up the challenge too, so it can't be retried with another s
+	authId, chal, k, err = challenge(c, params, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := answer(c, authId, big.NewInt(1)); err == nil {
+		t.Fatal("a wrong answer was accepted")
+	}
+	if _, err := answer(c, authId, zkutils.CalculateS(k, chal, x, params.Q)); err == nil {
+		t.Error("a challenge was answered after a wrong answer")
+	}
+}
+
+func TestServerExpiredChallenge(t *testing.T) {
+	params := serverParams(t)
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := startServer(t, authserver.Config{Params: params, ChallengeTTL: time.Minute, Now: clock.Now})
+	x := nonZeroScalar(t, params.Q)
+
+	register(t, c, params, "alice@example.com", x)
+
+	// just in time
+	authId, chal, k, err := challenge(c, params, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	if _, err := answer(c, authId, zkutils.CalculateS(k, chal, x, params.Q)); err != nil {
+		t.Errorf("a challenge answered within its time was refused: %v", err)
+	}
+
+	// too late
+	authId, chal, k, err = challenge(c, params, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute + time.Second)
+	_, err = answer(c, authId, zkutils.CalculateS(k, chal, x, params.Q))
+	if err == nil {
+		t.Fatal("an expired challenge was answered")
+	}
+	if status.Code(err) != codes.DeadlineExceeded || !strings.Contains(status.Convert(err).Message(), "expired") {
+		t.Errorf("the expired challenge failed with '%v'", err)
+	}
+
+	// challenges nobody answers are dropped when the next one is made
+	authId, chal, k, err = challenge(c, params, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(2 * time.Minute)
+	if _, _, _, err := challenge(c, params, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = answer(c, authId, zkutils.CalculateS(k, chal, x, params.Q))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("a pruned challenge failed with '%v', expected an unknown auth ID", err)
+	}
+}
+
+func TestServerConcurrentLogins(t *testing.T) {
+	// batching only pays off in a safe-prime group, the Schnorr group of serverParams would verify one by one
+	safePrime, err := zkutils.StandardGroup("modp2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     authserver.Config
+		batched bool
+	}{
+		{"one at a time", authserver.Config{Params: serverParams(t)}, false},
+		{"batched", authserver.Config{Params: safePrime, BatchWindow: 20 * time.Millisecond, BatchSize: 8}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := tc.cfg.Params
+			tc.cfg.AuditLog = io.Discard
+			srv, err := authserver.New(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := serve(t, srv)
+
+			const users = 16
+			secrets := make([]*big.Int, users)
+			for i := range secrets {
+				secrets[i] = nonZeroScalar(t, params.Q)
+				register(t, c, params, fmt.Sprintf("user%d@example.com", i), secrets[i])
+			}
+
+			// every user logs in three times at once, and every fourth user gets their secret wrong
+			// wrong answers make their batch fall back to one by one, so the last round is honest
+			for _, wrongUsers := range []bool{true, false} {
+				var wg sync.WaitGroup
+				errs := make([]error, users*3)
+				for i := range errs {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						u := i % users
+						x := secrets[u]
+						if wrongUsers && u%4 == 0 {
+							x = new(big.Int).Add(x, big.NewInt(1))
+						}
+						_, errs[i] = login(c, params, fmt.Sprintf("user%d@example.com", u), x)
+					}(i)
+				}
+				wg.Wait()
+
+				for i, err := range errs {
+					u := i % users
+					if wrongUsers && u%4 == 0 && err == nil {
+						t.Errorf("user%d logged in with the wrong secret", u)
+					}
+					if (!wrongUsers || u%4 != 0) && err != nil {
+						t.Errorf("user%d could not log in: %v", u, err)
+					}
+				}
+			}
+
+			if batched := srv.BatchedProofs(); tc.batched && batched == 0 {
+				t.Error("no proof was accepted by a combined batch check")
+			} else if !tc.batched && batched != 0 {
+				t.Errorf("%d proofs were batched without a batch window", batched)
+			}
+		})
+	}
+}
+
+// A batch window is ignored where BatchVerify can't combine the proofs
+func TestServerBatchNeedsSafePrime(t *testing.T) {
+	params := serverParams(t)
+	srv, err := authserver.New(authserver.Config{Params: params, BatchWindow: time.Second, AuditLog: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := serve(t, srv)
+	x := nonZeroScalar(t, params.Q)
+	register(t, c, params, "alice@example.com", x)
+
+	// with a batcher the login would wait for the whole window
+	start := time.Now()
+	if _, err := login(c, params, "alice@example.com", x); err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("the login took %v, it waited for the batch window", elapsed)
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	params := serverParams(t)
+	for _, cfg := range []authserver.Config{
+		{},
+		{Params: params, ChallengeTTL: -time.Second},
+		{Params: params, BatchSize: -1},
+		{Params: params, ChallengeBits: -1},
+	} {
+		if srv, err := authserver.New(cfg); err == nil {
+			srv.Close()
+			t.Errorf("the config %+v was accepted", cfg)
+		}
+	}
+}
+
+// A challenge held outside of the server, as the WebSocket login does, can only be answered once too
+func TestServerChallengeAnsweredOnce(t *testing.T) {
+	params := serverParams(t)
+	srv, err := authserver.New(authserver.Config{Params: params, AuditLog: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	x := nonZeroScalar(t, params.Q)
+	y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+	if _, err := srv.Register(context.Background(), &pb.RegisterRequest{User: "alice@example.com", Y1: y1.String(), Y2: y2.String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	k := nonZeroScalar(t, params.Q)
+	r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+	auth, err := srv.Challenge("alice@example.com", r1.String(), r2.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := zkutils.CalculateS(k, auth.C(), x, params.Q).String()
+	if _, err := srv.Answer(context.Background(), auth, s); err != nil {
+		t.Fatalf("could not answer the challenge: %v", err)
+	}
+	if _, err := srv.Answer(context.Background(), auth, s); err == nil || !strings.Contains(err.Error(), "answered already") {
+		t.Errorf("answering the challenge again failed with '%v', expected it to be answered already", err)
+	}
+}
+
+// lockedBuffer is an audit log the test can read while the server writes to it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServerCommitmentHistory(t *testing.T) {
+	params := serverParams(t)
+	x := nonZeroScalar(t, params.Q)
+	// commitments that are valid, the tests only care whether a challenge is handed out
+	commitment := func() (*big.Int, *big.Int) {
+		k := nonZeroScalar(t, params.Q)
+		return new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+	}
+
+	t.Run("retention", func(t *testing.T) {
+		clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		c := startServer(t, authserver.Config{Params: params, CommitmentRetention: time.Hour, Now: clock.Now})
+		register(t, c, params, "alice@example.com", x)
+
+		r1, r2 := commitment()
+		if _, _, err := commit(c, "alice@example.com", r1, r2); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Hour)
+		if _, _, err := commit(c, "alice@example.com", r1, r2); err == nil {
+			t.Error("a commitment was accepted again within the retention period")
+		}
+		clock.advance(time.Second)
+		if _, _, err := commit(c, "alice@example.com", r1, r2); err != nil {
+			t.Errorf("a commitment older than the retention period was refused: %v", err)
+		}
+	})
+
+	t.Run("history size", func(t *testing.T) {
+		c := startServer(t, authserver.Config{Params: params, CommitmentHistory: 2})
+		register(t, c, params, "alice@example.com", x)
+		register(t, c, params, "bob@example.com", x)
+
+		var sent [3][2]*big.Int
+		for i := range sent {
+			sent[i][0], sent[i][1] = commitment()
+			if _, _, err := commit(c, "alice@example.com", sent[i][0], sent[i][1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the history is per user
+		if _, _, err := commit(c, "bob@example.com", sent[2][0], sent[2][1]); err != nil {
+			t.Errorf("bob was refused alice's commitment: %v", err)
+		}
+		if _, _, err := commit(c, "alice@example.com", sent[2][0], sent[2][1]); err == nil {
+			t.Error("the newest commitment was accepted again")
+		}
+		// only the last two are remembered
+		if _, _, err := commit(c, "alice@example.com", sent[0][0], sent[0][1]); err != nil {
+			t.Errorf("the oldest commitment is still remembered: %v", err)
+		}
+	})
+
+	t.Run("audit event", func(t *testing.T) {
+		audit := &lockedBuffer{}
+		c := startServer(t, authserver.Config{Params: params, AuditLog: audit})
+		register(t, c, params, "alice@example.com", x)
+
+		r1, r2 := commitment()
+		if _, _, err := commit(c, "alice@example.com", r1, r2); err != nil {
+			t.Fatal(err)
+		}
+		if audit.String() != "" {
+			t.Errorf("a fresh commitment was audited: %s", audit.String())
+		}
+		if _, _, err := commit(c, "alice@example.com", r1, r2); err == nil {
+			t.Fatal("a reused commitment was accepted")
+		}
+
+		var event struct {
+			Time   time.Time `json:"time"`
+			Event  string    `json:"event"`
+			User   string    `json:"user"`
+			Detail string    `json:"detail"`
+		}
+		lines := strings.Split(strings.TrimSuffix(audit.String(), "\n"), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("expected one audit line, got %q", lines)
+		}
+		if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+			t.Fatalf("the audit line is not JSON: %v", err)
+		}
+		if event.Event != "commitment_reused" || event.User != "alice@example.com" || event.Time.IsZero() || !strings.Contains(event.Detail, r1.String()) {
+			t.Errorf("the audit event is %+v", event)
+		}
+	})
+}
+
+// rotate replaces the secret of the session's user with newX, proving the old secret with x
+func rotate(c pb.AuthClient, params *zkutils.Params, sessionId string, x *big.Int, newX *big.Int) error {
+	authId, chal, k, err := challenge(c, params, "alice@example.com")
+	if err != nil {
+		return err
+	}
+	y1, y2 := new(big.Int).Exp(params.G, newX, params.P), new(big.Int).Exp(params.H, newX, params.P)
+	s := zkutils.CalculateS(k, chal, x, params.Q)
+	_, err = c.Rotate(context.Background(), &pb.RotateRequest{SessionId: sessionId, Y1: y1.String(), Y2: y2.String(), AuthId: authId, S: s.String()})
+	return err
+}
+
+func TestServerRotate(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+	oldX, newX := nonZeroScalar(t, params.Q), nonZeroScalar(t, params.Q)
+	register(t, c, params, "alice@example.com", oldX)
+
+	if err := rotate(c, params, "not a session", oldX, newX); err == nil {
+		t.Fatal("rotated without a session")
+	}
+
+	sessionId, err := login(c, params, "alice@example.com", oldX)
+	if err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+	other, err := login(c, params, "alice@example.com", oldX)
+	if err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+	if err := rotate(c, params, sessionId, newX, newX); err == nil {
+		t.Fatal("rotated with a proof for the new secret rather than the old one")
+	}
+	if err := rotate(c, params, sessionId, oldX, newX); err != nil {
+		t.Fatalf("could not rotate: %v", err)
+	}
+	if _, err := login(c, params, "alice@example.com", oldX); err == nil {
+		t.Error("logged in with the secret from before the rotation")
+	}
+	if _, err := login(c, params, "alice@example.com", newX); err != nil {
+		t.Errorf("could not log in with the rotated secret: %v", err)
+	}
+
+	// the other session ends with the rotation, the one that rotated stays
+	if _, err := c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: other}); err == nil {
+		t.Error("another session outlived the rotation")
+	}
+	if _, err := c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: sessionId}); err != nil {
+		t.Errorf("the session that rotated has ended: %v", err)
+	}
+
+	// a session that has logged out can't rotate any more
+	if _, err := c.Logout(context.Background(), &pb.LogoutRequest{SessionId: sessionId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: sessionId}); err == nil {
+		t.Error("the session outlived the logout")
+	}
+	if err := rotate(c, params, sessionId, newX, oldX); err == nil {
+		t.Error("rotated with a session that has logged out")
+	}
+}
+
+// GetKdfParameters can't be used to find out which users exist, or which of them use a passphrase
+func TestServerKdfParameters(t *testing.T) {
+	params := serverParams(t)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	c := startServer(t, authserver.Config{Params: params, KdfSecret: secret})
+
+	registered, err := zkutils.NewKDFParams(zkutils.KDFArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := nonZeroScalar(t, params.Q)
+	y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+	kdf := &pb.KdfParameters{Algorithm: registered.Algorithm, Salt: registered.Salt, Time: registered.Time, Memory: registered.Memory, Threads: uint32(registered.Threads)}
+	if _, err := c.Register(context.Background(), &pb.RegisterRequest{User: "alice@example.com", Y1: y1.String(), Y2: y2.String(), Kdf: kdf}); err != nil {
+		t.Fatal(err)
+	}
+	register(t, c, params, "bob@example.com", x)
+
+	get := func(c pb.AuthClient, user string) *pb.KdfParameters {
+		t.Helper()
+		resp, err := c.GetKdfParameters(context.Background(), &pb.KdfParametersRequest{User: user})
+		if err != nil {
+			t.Fatalf("the KDF parameters of '%s' failed with '%v'", user, err)
+		}
+		return resp.GetKdf()
+	}
+
+	if got := get(c, "alice@example.com"); !bytes.Equal(got.GetSalt(), registered.Salt) {
+		t.Errorf("alice got the salt %x, expected the one she registered with", got.GetSalt())
+	}
+
+	// bob registered without a passphrase, and nobody didn't register at all
+	bob, nobody := get(c, "bob@example.com"), get(c, "nobody@example.com")
+	for _, fake := range []*pb.KdfParameters{bob, nobody} {
+		if fake.GetAlgorithm() != kdf.GetAlgorithm() || len(fake.GetSalt()) != len(kdf.GetSalt()) || fake.GetTime() != kdf.GetTime() || fake.GetMemory() != kdf.GetMemory() || fake.GetThreads() != kdf.GetThreads() {
+			t.Errorf("the made up parameters %v don't look like the real ones %v", fake, kdf)
+		}
+	}
+	if bytes.Equal(bob.GetSalt(), nobody.GetSalt()) {
+		t.Error("two users got the same made up salt")
+	}
+	if again := get(c, "nobody@example.com"); !bytes.Equal(again.GetSalt(), nobody.GetSalt()) {
+		t.Error("asking twice got two different salts")
+	}
+
+	// with the same secret, a restarted server makes up the same parameters
+	restarted := startServer(t, authserver.Config{Params: params, KdfSecret: secret})
+	if got := get(restarted, "nobody@example.com"); !bytes.Equal(got.GetSalt(), nobody.GetSalt()) {
+		t.Error("the same secret made up a different salt")
+	}
+	other := startServer(t, authserver.Config{Params: params})
+	if got := get(other, "nobody@example.com"); bytes.Equal(got.GetSalt(), nobody.GetSalt()) {
+		t.Error("a random secret made up the same salt")
+	}
+
+	if srv, err := authserver.New(authserver.Config{Params: params, KdfSecret: []byte("short")}); err == nil {
+		srv.Close()
+		t.Error("a kdf secret of 5 bytes was accepted")
+	}
+}
@@ -0,0 +1,156 @@
+package utils_test
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mischat/zkp_auth/authserver"
+	pb "github.com/mischat/zkp_auth/pb"
+	zkutils "github.com/mischat/zkp_auth/utils"
+	"google.golang.org/grpc/status"
+)
+
+// These log in with the streaming Authenticate call, where the challenge lives on the stream
+
+func commitStep(user string, r1, r2 *big.Int) *pb.AuthenticateRequest {
+	return &pb.AuthenticateRequest{Step: &pb.AuthenticateRequest_Commit{Commit: &pb.AuthenticationChallengeRequest{User: user, R1: r1.String(), R2: r2.String()}}}
+}
+
+func answerStep(s *big.Int) *pb.AuthenticateRequest {
+	return &pb.AuthenticateRequest{Step: &pb.AuthenticateRequest_Answer{Answer: &pb.AuthenticateAnswer{S: s.String()}}}
+}
+
+// This opens a stream and commits to a fresh k, and returns the stream, k and the challenge
+func streamChallenge(t testing.TB, c pb.AuthClient, params *zkutils.Params, user string) (pb.Auth_AuthenticateClient, *big.Int, *big.Int, error) {
+	stream, err := c.Authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := nonZeroScalar(t, params.Q)
+	if err := stream.Send(commitStep(user, new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P))); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	chal, ok := new(big.Int).SetString(resp.GetChallenge().GetC(), 10)
+	if !ok {
+		t.Fatalf("the first reply is not a challenge: %v", resp)
+	}
+	return stream, k, chal, nil
+}
+
+// streamLogin runs the whole of the login on one stream, and returns the session ID
+func streamLogin(t testing.TB, c pb.AuthClient, params *zkutils.Params, user string, x *big.Int) (string, error) {
+	stream, k, chal, err := streamChallenge(t, c, params, user)
+	if err != nil {
+		return "", err
+	}
+	if err := stream.Send(answerStep(zkutils.CalculateS(k, chal, x, params.Q))); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return "", err
+	}
+	return resp.GetSession().GetSessionId(), nil
+}
+
+func TestStreamLogin(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+	register(t, c, params, "alice@example.com", x)
+
+	sessionId, err := streamLogin(t, c, params, "alice@example.com", x)
+	if err != nil {
+		t.Fatalf("could not log in: %v", err)
+	}
+	who, err := c.WhoAmI(context.Background(), &pb.WhoAmIRequest{SessionId: sessionId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if who.GetUser() != "alice@example.com" {
+		t.Errorf("the session belongs to '%s', expected 'alice@example.com'", who.GetUser())
+	}
+
+	if _, err := streamLogin(t, c, params, "alice@example.com", new(big.Int).Add(x, big.NewInt(1))); err == nil {
+		t.Error("logged in with the wrong secret")
+	} else if !strings.Contains(status.Convert(err).Message(), zkutils.ErrR1Mismatch.Error()) {
+		t.Errorf("the wrong secret failed with '%v', expected a mismatch", err)
+	}
+
+	// the two calls and the stream share the replay checks
+	k := nonZeroScalar(t, params.Q)
+	r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+	if _, err := c.CreateAuthenticationChallenge(context.Background(), &pb.AuthenticationChallengeRequest{User: "alice@example.com", R1: r1.String(), R2: r2.String()}); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.Authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(commitStep("alice@example.com", r1, r2))
+	if _, err := stream.Recv(); err == nil || !strings.Contains(status.Convert(err).Message(), "commitment has been used before") {
+		t.Errorf("a reused commitment failed with '%v'", err)
+	}
+}
+
+func TestStreamOutOfOrder(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params})
+	x := nonZeroScalar(t, params.Q)
+	register(t, c, params, "alice@example.com", x)
+
+	for _, tc := range []struct {
+		name     string
+		steps    []*pb.AuthenticateRequest
+		expected string
+	}{
+		{"answer first", []*pb.AuthenticateRequest{answerStep(big.NewInt(1))}, "the first message must be the commitment"},
+		{"empty message", []*pb.AuthenticateRequest{{}}, "the first message must be the commitment"},
+		{"commit twice", []*pb.AuthenticateRequest{commitStep("alice@example.com", params.G, params.H), commitStep("alice@example.com", params.H, params.G)}, "the second message must be the answer"},
+		{"unknown user", []*pb.AuthenticateRequest{commitStep("nobody@example.com", params.G, params.H)}, "user doesn't exists"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stream, err := c.Authenticate(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range tc.steps {
+				stream.Send(step)
+			}
+			// the challenge of a commitment, if there is one, then the error
+			for {
+				if _, err = stream.Recv(); err != nil {
+					break
+				}
+			}
+			if !strings.Contains(status.Convert(err).Message(), tc.expected) {
+				t.Errorf("the stream ended with '%v', expected '%s'", err, tc.expected)
+			}
+		})
+	}
+}
+
+// A client that commits and goes quiet loses its stream, and its challenge with it
+func TestStreamTimesOut(t *testing.T) {
+	params := serverParams(t)
+	c := startServer(t, authserver.Config{Params: params, ChallengeTTL: 100 * time.Millisecond})
+	x := nonZeroScalar(t, params.Q)
+	register(t, c, params, "alice@example.com", x)
+
+	stream, k, chal, err := streamChallenge(t, c, params, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	stream.Send(answerStep(zkutils.CalculateS(k, chal, x, params.Q)))
+	if _, err := stream.Recv(); err == nil || !strings.Contains(status.Convert(err).Message(), "no message within") {
+		t.Errorf("the late answer got '%v', expected the stream to have timed out", err)
+	}
+}
@@ -1,7 +1,10 @@
 package utils_test
 
 import (
+	"bytes"
+	"crypto/rand"
 	"math/big"
+	mrand "math/rand"
 	"testing"
 
 	zkutils "github.com/mischat/zkp_auth/utils"
@@ -63,7 +66,7 @@ func TestValidatePublicVariables(t *testing.T) {
 	// Test case 1: Valid public variables
 	p := big.NewInt(23)
 	q := big.NewInt(11)
-	g := big.NewInt(1)
+	g := big.NewInt(4)
 	h := big.NewInt(3)
 	valid, err := zkutils.ValidatePublicVariables(p, q, g, h)
 	if !valid || err != nil {
@@ -141,6 +144,16 @@ func TestValidatePublicVariables(t *testing.T) {
 	if valid || err == nil {
 		t.Errorf("ValidatePublicVariables(%d, %d, %d, %d) = (%t, %v), expected (false, error)", p, q, g, h, valid, err)
 	}
+
+	// Test case 7: g of 1 has order q, but it generates nothing
+	p = big.NewInt(23)
+	q = big.NewInt(11)
+	g = big.NewInt(1) // not valid
+	h = big.NewInt(3)
+	valid, err = zkutils.ValidatePublicVariables(p, q, g, h)
+	if valid || err == nil {
+		t.Errorf("ValidatePublicVariables(%d, %d, %d, %d) = (%t, %v), expected (false, error)", p, q, g, h, valid, err)
+	}
 }
 
 func TestCalculateS(t *testing.T) {
@@ -170,3 +183,76 @@ func TestCalculateS(t *testing.T) {
 		t.Errorf("CalculateS(%d, %d, %d, %d) = %d; expected %d", k, c, x, q, s, expectedS)
 	}
 }
+
+func TestRandomScalar(t *testing.T) {
+	for _, q := range []*big.Int{big.NewInt(2), big.NewInt(11), bigFromString("115792089237316195423570985008687907852837564279074904382605163141518161494337")} {
+		for i := 0; i < 200; i++ {
+			k, err := zkutils.RandomScalar(q, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Sign() <= 0 || k.Cmp(q) >= 0 {
+				t.Fatalf("RandomScalar(%d) = %d, which is not in [1, q)", q, k)
+			}
+		}
+	}
+
+	// the same randomness gives the same scalar
+	q := big.NewInt(1000003)
+	noise := bytes.Repeat([]byte{0x5a}, 64)
+	k1, err := zkutils.RandomScalar(q, bytes.NewReader(noise))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := zkutils.RandomScalar(q, bytes.NewReader(noise))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1.Cmp(k2) != 0 {
+		t.Errorf("the same randomness gave %d and %d", k1, k2)
+	}
+
+	// an error, rather than a panic, without randomness or without any numbers to pick from
+	if _, err := zkutils.RandomScalar(q, failingReader{}); err == nil {
+		t.Error("a scalar was picked without any randomness")
+	}
+	if _, err := zkutils.RandomScalar(q, bytes.NewReader(nil)); err == nil {
+		t.Error("a scalar was picked from an empty reader")
+	}
+	for _, q := range []int64{1, 0, -11} {
+		if _, err := zkutils.RandomScalar(big.NewInt(q), rand.Reader); err == nil {
+			t.Errorf("a scalar was picked for q:'%d'", q)
+		}
+	}
+}
+
+// With q = 11 every k in [1, 11) should come up as often, see TestChallengeUniform
+// RandomBigInt is kept for callers from before RandomScalar
+func TestRandomBigInt(t *testing.T) {
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+	for i := 0; i < 100; i++ {
+		if n := zkutils.RandomBigInt(); n.Sign() <= 0 || n.Cmp(max) >= 0 {
+			t.Fatalf("RandomBigInt() = %d, which is not in [1, 2^256)", n)
+		}
+	}
+}
+
+func TestRandomScalarUniform(t *testing.T) {
+	const samples = 20000
+	q := big.NewInt(11)
+	random := mrand.New(mrand.NewSource(41))
+
+	counts := make([]int, 10)
+	for i := 0; i < samples; i++ {
+		k, err := zkutils.RandomScalar(q, random)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[k.Int64()-1]++
+	}
+
+	// the 0.1% critical value with 9 degrees of freedom
+	if stat := chiSquare(counts, samples/10); stat > 27.88 {
+		t.Errorf("k is not uniform, chi-square %.2f is over 27.88, counts %v", stat, counts)
+	}
+}
@@ -0,0 +1,121 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+// A parameter set that must fail validation, and the check that must catch it
+type badParams struct {
+	Name   string `json:"name"`
+	P      string `json:"p"`
+	Q      string `json:"q"`
+	G      string `json:"g"`
+	H      string `json:"h"`
+	Group  string `json:"group"`
+	Policy string `json:"policy"`
+	Fails  string `json:"fails"`
+}
+
+func TestValidateParamsRejectsBadParams(t *testing.T) {
+	data, err := os.ReadFile("testdata/bad_params.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corpus []badParams
+	if err := json.Unmarshal(data, &corpus); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range corpus {
+		t.Run(tc.Name, func(t *testing.T) {
+			var params *zkutils.Params
+			if tc.Group != "" {
+				params, err = zkutils.StandardGroup(tc.Group)
+			} else {
+				params, err = zkutils.ParseParams(tc.P, tc.Q, tc.G, tc.H)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			policy := zkutils.PolicyToy
+			if tc.Policy != "" {
+				policy, err = zkutils.PolicyByName(tc.Policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report := zkutils.ValidateParams(params, policy)
+			if report.Valid() || report.Err() == nil {
+				t.Fatalf("ValidateParams() passed, expected '%s' to fail\n%v", tc.Fails, report)
+			}
+
+			found := false
+			for _, check := range report.Failed() {
+				if check.Name == tc.Fails {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("ValidateParams() did not fail '%s'\n%v", tc.Fails, report)
+			}
+		})
+	}
+}
+
+func TestValidateParamsReportsEveryCheck(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := zkutils.ValidateParams(params, zkutils.PolicyToy)
+	if !report.Valid() || report.Err() != nil {
+		t.Fatalf("ValidateParams() = %v, expected valid", report)
+	}
+
+	names := []string{
+		zkutils.CheckPPrime, zkutils.CheckQPrime, zkutils.CheckPBits, zkutils.CheckQBits, zkutils.CheckQDividesP,
+		zkutils.CheckGRange, zkutils.CheckGOrder, zkutils.CheckHRange, zkutils.CheckHOrder, zkutils.CheckGDifferentH,
+	}
+	if len(report.Checks) != len(names) {
+		t.Fatalf("ValidateParams() ran %d checks, expected %d", len(report.Checks), len(names))
+	}
+	for i, check := range report.Checks {
+		if check.Name != names[i] || !check.Passed {
+			t.Errorf("check %d = %+v, expected '%s' to pass", i, check, names[i])
+		}
+	}
+
+	// A group that can't exist still gets every check reported
+	params, err = zkutils.ParseParams("1", "0", "4", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = zkutils.ValidateParams(params, zkutils.PolicyToy)
+	if len(report.Checks) != len(names) || report.Valid() {
+		t.Errorf("ValidateParams() = %v, expected every check to be reported and fail", report)
+	}
+}
+
+func TestStandardGroupsMeetThePolicies(t *testing.T) {
+	for _, name := range zkutils.StandardGroupNames() {
+		params, err := zkutils.StandardGroup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := zkutils.ValidateParams(params, zkutils.PolicyDefault).Err(); err != nil {
+			t.Errorf("ValidateParams(%s, default) = %v, expected nil", name, err)
+		}
+	}
+
+	if _, err := zkutils.PolicyByName("lax"); err == nil {
+		t.Errorf("PolicyByName(lax) returned no error")
+	}
+}
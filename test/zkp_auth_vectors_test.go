@@ -0,0 +1,82 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func readVectors(t *testing.T) *zkutils.Vectors {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vs zkutils.Vectors
+	if err := json.Unmarshal(data, &vs); err != nil {
+		t.Fatal(err)
+	}
+	return &vs
+}
+
+func TestVectors(t *testing.T) {
+	vs := readVectors(t)
+	if err := zkutils.CheckVectors(vs); err != nil {
+		t.Fatal(err)
+	}
+
+	// every group has honest vectors that verify, and tampered ones that don't
+	honest, refused := map[string]int{}, map[string]int{}
+	for _, v := range vs.Vectors {
+		if v.Tampered == "" && v.VerifyProof && v.VerifyError == "" {
+			honest[v.Group]++
+		}
+		if v.VerifyError != "" {
+			refused[v.Group]++
+		}
+	}
+	for _, g := range vs.Groups {
+		if honest[g.Params.Name] == 0 || refused[g.Params.Name] == 0 {
+			t.Errorf("group '%s' has %d honest and %d refused vectors", g.Params.Name, honest[g.Params.Name], refused[g.Params.Name])
+		}
+	}
+}
+
+// The published file has to be what scripts/genvectors writes
+func TestVectorsUpToDate(t *testing.T) {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := exec.Command("go", "run", "../scripts/genvectors").Output()
+	if err != nil {
+		t.Fatalf("could not run scripts/genvectors: %v", err)
+	}
+	if !bytes.Equal(data, generated) {
+		t.Error("testdata/vectors.json is out of date, regenerate it with go run ./scripts/genvectors -out test/testdata/vectors.json")
+	}
+}
+
+func TestVectorsCatchDifferences(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(vs *zkutils.Vectors)
+	}{
+		{"wrong s", func(vs *zkutils.Vectors) { vs.Vectors[0].S = "1" }},
+		{"wrong y1", func(vs *zkutils.Vectors) { vs.Vectors[11].Y1 = vs.Vectors[11].Y2 }},
+		{"wrong result", func(vs *zkutils.Vectors) { vs.Vectors[4].VerifyProof = true }},
+		{"wrong error", func(vs *zkutils.Vectors) { vs.Vectors[7].VerifyError = "" }},
+		{"unknown group", func(vs *zkutils.Vectors) { vs.Vectors[0].Group = "ec256" }},
+		{"not a number", func(vs *zkutils.Vectors) { vs.Vectors[0].K = "0x10" }},
+		{"another mode", func(vs *zkutils.Vectors) { vs.Groups[0].Mode = "ec" }},
+	} {
+		vs := readVectors(t)
+		tc.change(vs)
+		if err := zkutils.CheckVectors(vs); err == nil {
+			t.Errorf("%s: the vectors were accepted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package utils_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	zkutils "github.com/mischat/zkp_auth/utils"
+)
+
+func TestVerifyChaumPedersen(t *testing.T) {
+	params, err := zkutils.ReadParamsFile("testdata/schnorr2048.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre := zkutils.Precompute(params)
+
+	one := big.NewInt(1)
+	plusOne := func(n *big.Int) *big.Int { return new(big.Int).Add(n, one) }
+	// p - 1 is in range, but has order 2
+	pMinusOne := new(big.Int).Sub(params.P, one)
+
+	for _, tc := range []struct {
+		name     string
+		tamper   func(proof *zkutils.BatchProof)
+		expected error
+	}{
+		{"valid", func(proof *zkutils.BatchProof) {}, nil},
+		{"missing s", func(proof *zkutils.BatchProof) { proof.S = nil }, zkutils.ErrMissingValue},
+		{"y1 is 1", func(proof *zkutils.BatchProof) { proof.Y1 = big.NewInt(1) }, zkutils.ErrY1OutOfRange},
+		{"y1 is p", func(proof *zkutils.BatchProof) { proof.Y1 = params.P }, zkutils.ErrY1OutOfRange},
+		{"y2 is 0", func(proof *zkutils.BatchProof) { proof.Y2 = big.NewInt(0) }, zkutils.ErrY2OutOfRange},
+		{"y1 has order 2", func(proof *zkutils.BatchProof) { proof.Y1 = pMinusOne }, zkutils.ErrY1NotInSubgroup},
+		{"y2 has order 2", func(proof *zkutils.BatchProof) { proof.Y2 = pMinusOne }, zkutils.ErrY2NotInSubgroup},
+		{"r1 is 0", func(proof *zkutils.BatchProof) { proof.R1 = big.NewInt(0) }, zkutils.ErrR1OutOfRange},
+		{"r2 is not reduced", func(proof *zkutils.BatchProof) { proof.R2 = new(big.Int).Add(proof.R2, params.P) }, zkutils.ErrR2OutOfRange},
+		{"c is 0", func(proof *zkutils.BatchProof) { proof.C = big.NewInt(0) }, zkutils.ErrChallengeOutOfRange},
+		{"c is q", func(proof *zkutils.BatchProof) { proof.C = params.Q }, zkutils.ErrChallengeOutOfRange},
+		{"s is negative", func(proof *zkutils.BatchProof) { proof.S = big.NewInt(-1) }, zkutils.ErrResponseOutOfRange},
+		{"s is not reduced", func(proof *zkutils.BatchProof) { proof.S = new(big.Int).Add(proof.S, params.Q) }, zkutils.ErrResponseOutOfRange},
+		{"wrong r1", func(proof *zkutils.BatchProof) { proof.R1 = new(big.Int).Exp(params.G, big.NewInt(5), params.P) }, zkutils.ErrR1Mismatch},
+		// r1 outside of the subgroup is caught by the equation
+		{"r1 has order 2", func(proof *zkutils.BatchProof) { proof.R1 = pMinusOne }, zkutils.ErrR1Mismatch},
+		{"wrong r2", func(proof *zkutils.BatchProof) { proof.R2 = proof.R1 }, zkutils.ErrR2Mismatch},
+		// y1 goes with g and y2 with h, not the other way round
+		{"y1 and y2 swapped", func(proof *zkutils.BatchProof) { proof.Y1, proof.Y2 = proof.Y2, proof.Y1 }, zkutils.ErrR1Mismatch},
+		{"wrong s", func(proof *zkutils.BatchProof) { proof.S = new(big.Int).Mod(plusOne(proof.S), params.Q) }, zkutils.ErrR1Mismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proof := makeProof(t, params)
+			tc.tamper(&proof)
+
+			err := zkutils.VerifyChaumPedersen(params, proof.Y1, proof.Y2, proof.R1, proof.R2, proof.C, proof.S)
+			if err != tc.expected {
+				t.Errorf("VerifyChaumPedersen() = %v, expected %v", err, tc.expected)
+			}
+			if tc.expected != nil && !errors.Is(err, tc.expected) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tc.expected)
+			}
+
+			err = pre.VerifyChaumPedersen(proof.Y1, proof.Y2, proof.R1, proof.R2, proof.C, proof.S)
+			if err != tc.expected {
+				t.Errorf("Precomputed.VerifyChaumPedersen() = %v, expected %v", err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestValidateStatement(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		y1, y2   int64
+		expected error
+	}{
+		{4, 9, nil},
+		{1, 9, zkutils.ErrY1OutOfRange},
+		{4, 23, zkutils.ErrY2OutOfRange},
+		{5, 9, zkutils.ErrY1NotInSubgroup},
+		{4, 22, zkutils.ErrY2NotInSubgroup},
+	} {
+		if err := zkutils.ValidateStatement(params, big.NewInt(tc.y1), big.NewInt(tc.y2)); err != tc.expected {
+			t.Errorf("ValidateStatement(%d, %d) = %v, expected %v", tc.y1, tc.y2, err, tc.expected)
+		}
+	}
+
+	if err := zkutils.ValidateStatement(params, nil, big.NewInt(9)); err != zkutils.ErrMissingValue {
+		t.Errorf("ValidateStatement(nil, 9) = %v, expected %v", err, zkutils.ErrMissingValue)
+	}
+}
+
+func TestVerifyProofMismatch(t *testing.T) {
+	params, err := zkutils.ParseParams("23", "11", "4", "9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// x = 6, k = 3, c = 5, s = (3 - 30) mod 11 = 6
+	y1, r1, c, s := big.NewInt(2), big.NewInt(18), big.NewInt(5), big.NewInt(6)
+
+	if ok, err := zkutils.VerifyProof(r1, params.G, s, y1, c, params.P); !ok || err != nil {
+		t.Fatalf("VerifyProof() = %v, %v, expected true", ok, err)
+	}
+	ok, err := zkutils.VerifyProof(r1, params.G, big.NewInt(7), y1, c, params.P)
+	if ok || !errors.Is(err, zkutils.ErrRMismatch) {
+		t.Errorf("VerifyProof() with the wrong s = %v, %v, expected %v", ok, err, zkutils.ErrRMismatch)
+	}
+}
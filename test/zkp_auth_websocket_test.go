@@ -0,0 +1,172 @@
+package utils_test
+
+import (
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mischat/zkp_auth/authserver"
+	zkutils "github.com/mischat/zkp_auth/utils"
+	"golang.org/x/net/websocket"
+)
+
+// These log in over the WebSocket of the gateway, the way the browser app does
+
+type socketMessage struct {
+	Type      string `json:"type"`
+	User      string `json:"user,omitempty"`
+	R1        string `json:"r1,omitempty"`
+	R2        string `json:"r2,omitempty"`
+	C         string `json:"c,omitempty"`
+	S         string `json:"s,omitempty"`
+	SessionId string `json:"session_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+func dialLogin(t testing.TB, url string) *websocket.Conn {
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(url, "http")+"/v1/login/ws", "", "http://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// This sends msg, and returns the reply
+func exchange(t testing.TB, ws *websocket.Conn, msg interface{}) socketMessage {
+	if err := websocket.JSON.Send(ws, msg); err != nil {
+		t.Fatal(err)
+	}
+	var reply socketMessage
+	if err := websocket.JSON.Receive(ws, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// This commits to a fresh k, and returns it with the reply
+func socketCommit(t testing.TB, ws *websocket.Conn, params *zkutils.Params, user string) (*big.Int, socketMessage) {
+	k := nonZeroScalar(t, params.Q)
+	r1, r2 := new(big.Int).Exp(params.G, k, params.P), new(big.Int).Exp(params.H, k, params.P)
+	return k, exchange(t, ws, socketMessage{Type: "commit", User: user, R1: toHex(r1), R2: r2.String()})
+}
+
+// A whole login on the connection, it returns the reply to the answer
+func socketLogin(t testing.TB, ws *websocket.Conn, params *zkutils.Params, user string, x *big.Int) socketMessage {
+	k, chal := socketCommit(t, ws, params, user)
+	if chal.Type != "challenge" {
+		t.Fatalf("the commitment was answered with %+v", chal)
+	}
+	c, ok := new(big.Int).SetString(chal.C, 10)
+	if !ok {
+		t.Fatalf("c is not a decimal number: '%s'", chal.C)
+	}
+	return exchange(t, ws, socketMessage{Type: "answer", S: zkutils.CalculateS(k, c, x, params.Q).String()})
+}
+
+func registerOverGateway(t testing.TB, url string, params *zkutils.Params, user string) *big.Int {
+	x := nonZeroScalar(t, params.Q)
+	y1, y2 := new(big.Int).Exp(params.G, x, params.P), new(big.Int).Exp(params.H, x, params.P)
+	if status, gwErr := call(t, http.MethodPost, url+"/v1/register", map[string]string{"user": user, "y1": y1.String(), "y2": y2.String()}, nil); status != http.StatusOK {
+		t.Fatalf("could not register: %d %v", status, gwErr)
+	}
+	return x
+}
+
+func TestWebSocketLogin(t *testing.T) {
+	params := serverParams(t)
+	url := startGateway(t, authserver.Config{Params: params})
+	x := registerOverGateway(t, url, params, "alice@example.com")
+	ws := dialLogin(t, url)
+
+	// two logins on the same connection
+	for i := 0; i < 2; i++ {
+		reply := socketLogin(t, ws, params, "alice@example.com", x)
+		if reply.Type != "session" || reply.SessionId == "" {
+			t.Fatalf("login %d was answered with %+v", i, reply)
+		}
+		var who struct {
+			User string `json:"user"`
+		}
+		if status, gwErr := call(t, http.MethodPost, url+"/v1/whoami", map[string]string{"session_id": reply.SessionId}, &who); status != http.StatusOK || who.User != "alice@example.com" {
+			t.Errorf("the session of login %d is %d %v %+v", i, status, gwErr, who)
+		}
+	}
+
+	// a wrong answer drops the challenge, but not the connection
+	reply := socketLogin(t, ws, params, "alice@example.com", new(big.Int).Add(x, big.NewInt(1)))
+	if reply.Type != "error" || reply.Error != "unauthorized" || !strings.Contains(reply.Message, zkutils.ErrR1Mismatch.Error()) {
+		t.Errorf("the wrong secret was answered with %+v", reply)
+	}
+	if reply := exchange(t, ws, socketMessage{Type: "answer", S: "1"}); reply.Type != "error" || reply.Error != "bad_request" {
+		t.Errorf("answering the dropped challenge was answered with %+v", reply)
+	}
+	if reply := socketLogin(t, ws, params, "alice@example.com", x); reply.Type != "session" {
+		t.Errorf("the login after the wrong secret was answered with %+v", reply)
+	}
+}
+
+func TestWebSocketErrors(t *testing.T) {
+	params := serverParams(t)
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	url := startGateway(t, authserver.Config{Params: params, Now: clock.Now})
+	x := registerOverGateway(t, url, params, "alice@example.com")
+	ws := dialLogin(t, url)
+
+	for _, tc := range []struct {
+		name string
+		msg  interface{}
+		code string
+		// part of the message
+		message string
+	}{
+		{"answer first", socketMessage{Type: "answer", S: "1"}, "bad_request", "no challenge"},
+		{"unknown type", socketMessage{Type: "login"}, "bad_request", "'login'"},
+		{"not JSON", "hello", "bad_request", "not valid JSON"},
+		{"bad hex", socketMessage{Type: "commit", User: "alice@example.com", R1: "0xg", R2: "1"}, "bad_request", "r1 is not a hex number"},
+		{"unknown user", socketMessage{Type: "commit", User: "nobody@example.com", R1: "1", R2: "1"}, "not_found", "user doesn't exists"},
+		{"commitment out of range", socketMessage{Type: "commit", User: "alice@example.com", R1: params.P.String(), R2: "1"}, "bad_request", zkutils.ErrR1OutOfRange.Error()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var reply socketMessage
+			if s, ok := tc.msg.(string); ok {
+				if err := websocket.Message.Send(ws, s); err != nil {
+					t.Fatal(err)
+				}
+				if err := websocket.JSON.Receive(ws, &reply); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				reply = exchange(t, ws, tc.msg)
+			}
+			if reply.Type != "error" || reply.Error != tc.code || !strings.Contains(reply.Message, tc.message) {
+				t.Errorf("answered %+v, expected %s with '%s'", reply, tc.code, tc.message)
+			}
+		})
+	}
+
+	t.Run("expired challenge", func(t *testing.T) {
+		k, chal := socketCommit(t, ws, params, "alice@example.com")
+		clock.advance(authserver.DefaultChallengeTTL + time.Second)
+		c, _ := new(big.Int).SetString(chal.C, 10)
+		reply := exchange(t, ws, socketMessage{Type: "answer", S: zkutils.CalculateS(k, c, x, params.Q).String()})
+		if reply.Type != "error" || !strings.Contains(reply.Message, "expired") {
+			t.Errorf("the late answer was answered with %+v", reply)
+		}
+	})
+
+	t.Run("challenge goes with the connection", func(t *testing.T) {
+		other := dialLogin(t, url)
+		k, chal := socketCommit(t, other, params, "alice@example.com")
+		other.Close()
+
+		// the answer is right, but only the closed connection could have sent it
+		c, _ := new(big.Int).SetString(chal.C, 10)
+		if reply := exchange(t, ws, socketMessage{Type: "answer", S: zkutils.CalculateS(k, c, x, params.Q).String()}); reply.Type != "error" {
+			t.Errorf("another connection answered the challenge: %+v", reply)
+		}
+	})
+}
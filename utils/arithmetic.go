@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The backends for arithmetic that involves the secrets x and k
+const (
+	// math/big, which is fast but not constant-time
+	ArithmeticBig = "big"
+	// fixed-width limbs and Montgomery multiplication, see consttime.go
+	ArithmeticConstantTime = "constant-time"
+)
+
+// Arithmetic does the prover's computations on secrets: y = g^x, r = g^k and s = (k - c.x) mod q
+// Secret exponents are reduced mod q first, g and h have order q so the results are the same
+type Arithmetic interface {
+	// Exp returns base^e mod p, for a public base and a secret e
+	Exp(base *big.Int, e *big.Int) *big.Int
+	// CalculateS returns (k - c.x) mod q
+	CalculateS(k *big.Int, c *big.Int, x *big.Int) *big.Int
+}
+
+// NewArithmetic returns the backend named by the parameter set, math/big when it doesn't name one
+func NewArithmetic(params *Params) (Arithmetic, error) {
+	return NewArithmeticByName(params, params.Arithmetic)
+}
+
+// NewArithmeticByName returns the named backend for a parameter set
+func NewArithmeticByName(params *Params, name string) (Arithmetic, error) {
+	switch name {
+	case "", ArithmeticBig:
+		return &bigArithmetic{params: params}, nil
+	case ArithmeticConstantTime:
+		return newConstantTimeArithmetic(params)
+	default:
+		return nil, fmt.Errorf("unknown arithmetic: '%s', the backends are %s and %s", name, ArithmeticBig, ArithmeticConstantTime)
+	}
+}
+
+type bigArithmetic struct {
+	params *Params
+}
+
+func (a *bigArithmetic) Exp(base *big.Int, e *big.Int) *big.Int {
+	return new(big.Int).Exp(base, new(big.Int).Mod(e, a.params.Q), a.params.P)
+}
+
+func (a *bigArithmetic) CalculateS(k *big.Int, c *big.Int, x *big.Int) *big.Int {
+	return CalculateS(k, c, x, a.params.Q)
+}
+
+type constantTimeArithmetic struct {
+	params *Params
+	p      *montModulus
+	q      *montModulus
+	qBits  int
+}
+
+func newConstantTimeArithmetic(params *Params) (*constantTimeArithmetic, error) {
+	p, err := newMontModulus(params.P)
+	if err != nil {
+		return nil, err
+	}
+	q, err := newMontModulus(params.Q)
+	if err != nil {
+		return nil, err
+	}
+	return &constantTimeArithmetic{params: params, p: p, q: q, qBits: params.Q.BitLen()}, nil
+}
+
+// The secrets are reduced mod q with math/big first, which takes as long as they are wide.
+// x and k from the KDF and DeterministicK are already below q, so that only leaks for an -x that isn't.
+func (a *constantTimeArithmetic) scalar(e *big.Int) []uint64 {
+	if e.Sign() < 0 || e.Cmp(a.params.Q) >= 0 {
+		e = new(big.Int).Mod(e, a.params.Q)
+	}
+	return toLimbs(e, a.q.n)
+}
+
+func (a *constantTimeArithmetic) Exp(base *big.Int, e *big.Int) *big.Int {
+	// the base is public, so it can be reduced with math/big
+	b := toLimbs(new(big.Int).Mod(base, a.params.P), a.p.n)
+	return fromLimbs(a.p.exp(b, a.scalar(e), a.qBits))
+}
+
+func (a *constantTimeArithmetic) CalculateS(k *big.Int, c *big.Int, x *big.Int) *big.Int {
+	// c . x mod q, c is in Montgomery form so the R^-1 of the multiplication cancels out
+	cMont := a.q.toMont(a.scalar(c))
+	cx := a.q.mul(cMont, a.scalar(x))
+	return fromLimbs(a.q.subMod(a.scalar(k), cx))
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+)
+
+// Checking n proofs one by one costs 4n exponentiations. BatchVerify instead checks
+// one random linear combination of all of the equations, with the small exponents test
+// of Bellare, Garay and Rabin:
+// prod r1_i^a_i . r2_i^b_i = g^(sum a_i.s_i) . h^(sum b_i.s_i) . prod y1_i^(a_i.c_i) . y2_i^(b_i.c_i) mod p
+// for random a_i, b_i. If any of the proofs is wrong, the two sides only match
+// with a probability of about 2^-batchWeightBits.
+// This is only sound when every r and y is in the subgroup of order q, otherwise
+// a wrong proof could be off by an element of small order that the weights cancel out.
+
+// The size of the random weights, and so the security level of the batch check
+const batchWeightBits = 128
+
+// BatchProof is a Chaum-Pedersen proof, and the statement y1 = g^x, y2 = h^x it is about
+type BatchProof struct {
+	Y1 *big.Int
+	Y2 *big.Int
+	R1 *big.Int
+	R2 *big.Int
+	C  *big.Int
+	S  *big.Int
+}
+
+// BatchVerify checks many proofs at once, the weights are read from random, crypto/rand when nil
+// It returns an error for each proof, nil when it verified, and whether the proofs were
+// accepted by the combined check rather than one by one
+// When the batch doesn't verify, or can't be batched, the proofs are checked one by one
+// to find the bad ones, so the results are always the same as VerifyChaumPedersen's
+func BatchVerify(params *Params, proofs []BatchProof, random io.Reader) ([]error, bool) {
+	if random == nil {
+		random = rand.Reader
+	}
+	errs := make([]error, len(proofs))
+
+	if len(proofs) < 2 || !CanBatch(params) {
+		for i := range proofs {
+			errs[i] = verifyOne(params, proofs[i])
+		}
+		return errs, false
+	}
+
+	// Proofs with elements outside of the subgroup are checked on their own
+	var batch []int
+	for i, proof := range proofs {
+		if err := checkProofRanges(params, proof.Y1, proof.Y2, proof.R1, proof.R2, proof.C, proof.S); err != nil {
+			errs[i] = err
+		} else if InSubgroup(params, proof.Y1) && InSubgroup(params, proof.Y2) &&
+			InSubgroup(params, proof.R1) && InSubgroup(params, proof.R2) {
+			batch = append(batch, i)
+		} else {
+			errs[i] = verifyOne(params, proof)
+		}
+	}
+
+	if len(batch) == 0 {
+		return errs, false
+	}
+	if !verifyCombination(params, proofs, batch, random) {
+		for _, i := range batch {
+			errs[i] = verifyOne(params, proofs[i])
+		}
+		return errs, false
+	}
+	return errs, true
+}
+
+// CanBatch reports whether BatchVerify can check proofs in the group together
+// Subgroup membership is only cheap to check in safe-prime groups,
+// elsewhere it costs as much as checking the proof.
+// The weights only matter mod q, so a small q would let a wrong proof through far too often
+func CanBatch(params *Params) bool {
+	return isSafePrimeGroup(params) && params.Q.BitLen() > batchWeightBits
+}
+
+// This checks the random linear combination of the proofs in batch
+// It returns false when they don't verify, or when we can't tell
+func verifyCombination(params *Params, proofs []BatchProof, batch []int, random io.Reader) bool {
+	maxWeight := new(big.Int).Lsh(big.NewInt(1), batchWeightBits)
+
+	// The left hand side has small exponents, so it gets a multi-exponentiation of its own
+	lhsBases := make([]*big.Int, 0, 2*len(batch))
+	lhsExps := make([]*big.Int, 0, 2*len(batch))
+	rhsBases := []*big.Int{params.G, params.H}
+	rhsExps := []*big.Int{new(big.Int), new(big.Int)}
+
+	for _, i := range batch {
+		proof := proofs[i]
+
+		a, err := randInt(random, maxWeight)
+		if err != nil {
+			return false
+		}
+		b, err := randInt(random, maxWeight)
+		if err != nil {
+			return false
+		}
+
+		s, c := proof.S, proof.C
+
+		lhsBases = append(lhsBases, proof.R1, proof.R2)
+		lhsExps = append(lhsExps, a, b)
+
+		rhsExps[0].Add(rhsExps[0], new(big.Int).Mul(a, s))
+		rhsExps[1].Add(rhsExps[1], new(big.Int).Mul(b, s))
+
+		// a.c is left unreduced, c is often much smaller than q, so this keeps the exponent short
+		rhsBases = append(rhsBases, proof.Y1, proof.Y2)
+		rhsExps = append(rhsExps, new(big.Int).Mul(a, c), new(big.Int).Mul(b, c))
+	}
+	rhsExps[0].Mod(rhsExps[0], params.Q)
+	rhsExps[1].Mod(rhsExps[1], params.Q)
+
+	lhs, err := MultiExp(lhsBases, lhsExps, params.P)
+	if err != nil {
+		return false
+	}
+	rhs, err := MultiExp(rhsBases, rhsExps, params.P)
+	if err != nil {
+		return false
+	}
+	return lhs.Cmp(rhs) == 0
+}
+
+// This checks a single proof, just like the server does
+func verifyOne(params *Params, proof BatchProof) error {
+	return VerifyChaumPedersen(params, proof.Y1, proof.Y2, proof.R1, proof.R2, proof.C, proof.S)
+}
+
+// InSubgroup reports whether x is in the subgroup of order q
+// In a safe-prime group that is the quadratic residues, which the Jacobi symbol finds
+// without an exponentiation, otherwise x^q mod p = 1 is checked
+func InSubgroup(params *Params, x *big.Int) bool {
+	if x.Sign() <= 0 || x.Cmp(params.P) >= 0 {
+		return false
+	}
+	if isSafePrimeGroup(params) {
+		return big.Jacobi(x, params.P) == 1
+	}
+	return new(big.Int).Exp(x, params.Q, params.P).Cmp(big.NewInt(1)) == 0
+}
+
+// p = 2q + 1
+func isSafePrimeGroup(params *Params) bool {
+	twoQ := new(big.Int).Lsh(params.Q, 1)
+	return twoQ.Add(twoQ, big.NewInt(1)).Cmp(params.P) == 0
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// A prover who doesn't know x can still answer one challenge, if it guessed it before committing,
+// so a cheat gets through with probability 1 / (the number of challenges).
+// Challenges have to be below q, as s = k - c.x is mod q, and must not be 0,
+// as with c = 0 the proof doesn't depend on x at all.
+
+// ChallengeSpace is the set of challenges a verifier picks from, [1, Bound)
+type ChallengeSpace struct {
+	// Bound is min(2^bits, q)
+	Bound *big.Int
+}
+
+// NewChallengeSpace returns the challenges of up to bits bits for a parameter set,
+// bits of 0 (or at least as many as q has) is the whole of [1, q)
+func NewChallengeSpace(params *Params, bits int) (*ChallengeSpace, error) {
+	if bits < 0 {
+		return nil, fmt.Errorf("the challenge bits:'%d' must not be negative", bits)
+	}
+
+	bound := new(big.Int).Set(params.Q)
+	if bits > 0 && bits < params.Q.BitLen() {
+		bound.Lsh(big.NewInt(1), uint(bits))
+	}
+
+	// [1, 2) would leave a single challenge, which a cheat can always answer
+	if bound.Cmp(big.NewInt(2)) <= 0 {
+		return nil, fmt.Errorf("the challenge space [1, '%d') is too small, q:'%d' bits:'%d'", bound, params.Q, bits)
+	}
+	return &ChallengeSpace{Bound: bound}, nil
+}
+
+// Size returns the number of challenges in the space
+func (cs *ChallengeSpace) Size() *big.Int {
+	return new(big.Int).Sub(cs.Bound, big.NewInt(1))
+}
+
+// Contains reports whether c is a challenge from the space
+func (cs *ChallengeSpace) Contains(c *big.Int) bool {
+	return c != nil && c.Sign() > 0 && c.Cmp(cs.Bound) < 0
+}
+
+// Sample picks a challenge uniformly from the space
+func (cs *ChallengeSpace) Sample(random io.Reader) (*big.Int, error) {
+	c, err := RandomScalar(cs.Bound, random)
+	if err != nil {
+		return nil, fmt.Errorf("could not pick a challenge: %v", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// math/big is not constant-time: how long Exp and Mul take depends on the values, which leaks
+// timing about x and k. This is a small constant-time backend for the few operations that involve them.
+// Numbers are fixed-width little-endian slices of 64 bit limbs, as wide as the modulus,
+// multiplication is Montgomery multiplication (CIOS), and every branch and memory access
+// only depends on the sizes of the numbers, never on their values.
+// Turning secrets into limbs goes through big.Int.FillBytes, which is as fast as the modulus is wide.
+
+// montModulus is an odd modulus m, with everything Montgomery multiplication mod m needs
+type montModulus struct {
+	m []uint64
+	n int
+	// -m^-1 mod 2^64
+	m0inv uint64
+	// R^2 mod m, where R = 2^(64n), to move numbers into Montgomery form
+	rr []uint64
+	// R mod m, which is 1 in Montgomery form
+	one []uint64
+}
+
+func newMontModulus(m *big.Int) (*montModulus, error) {
+	if m.Sign() <= 0 || m.Bit(0) == 0 || m.Cmp(big.NewInt(1)) == 0 {
+		return nil, fmt.Errorf("m:'%d' needs to be odd and more than 1 for Montgomery multiplication", m)
+	}
+
+	n := (m.BitLen() + 63) / 64
+	mm := &montModulus{m: toLimbs(m, n), n: n}
+
+	// Newton's iteration doubles the correct bits of m0^-1 mod 2^64 every time, m0 . m0 = 1 mod 8 to start with
+	inv := mm.m[0]
+	for i := 0; i < 5; i++ {
+		inv *= 2 - mm.m[0]*inv
+	}
+	mm.m0inv = -inv
+
+	// m is public, so math/big is fine here
+	r := new(big.Int).Lsh(big.NewInt(1), uint(64*n))
+	mm.one = toLimbs(new(big.Int).Mod(r, m), n)
+	mm.rr = toLimbs(new(big.Int).Mod(new(big.Int).Mul(r, r), m), n)
+	return mm, nil
+}
+
+// toLimbs writes x, which must fit into n limbs, as n little-endian limbs
+func toLimbs(x *big.Int, n int) []uint64 {
+	buf := make([]byte, 8*n)
+	x.FillBytes(buf)
+
+	limbs := make([]uint64, n)
+	for i := range limbs {
+		off := len(buf) - 8*(i+1)
+		for j := 0; j < 8; j++ {
+			limbs[i] = limbs[i]<<8 | uint64(buf[off+j])
+		}
+	}
+	return limbs
+}
+
+func fromLimbs(limbs []uint64) *big.Int {
+	buf := make([]byte, 8*len(limbs))
+	for i, limb := range limbs {
+		off := len(buf) - 8*(i+1)
+		for j := 7; j >= 0; j-- {
+			buf[off+j] = byte(limb)
+			limb >>= 8
+		}
+	}
+	return new(big.Int).SetBytes(buf)
+}
+
+// mul returns a . b . R^-1 mod m, for a, b < m
+func (mm *montModulus) mul(a []uint64, b []uint64) []uint64 {
+	n := mm.n
+	t := make([]uint64, n+2)
+
+	for i := 0; i < n; i++ {
+		// t += a[i] . b
+		var c uint64
+		for j := 0; j < n; j++ {
+			hi, lo := bits.Mul64(a[i], b[j])
+			var carry uint64
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[j], c = lo, hi
+		}
+		var carry uint64
+		t[n], carry = bits.Add64(t[n], c, 0)
+		t[n+1] = carry
+
+		// t = (t + u . m) / 2^64, where u makes the lowest limb 0
+		u := t[0] * mm.m0inv
+		hi, lo := bits.Mul64(u, mm.m[0])
+		_, carry = bits.Add64(lo, t[0], 0)
+		c = hi + carry
+		for j := 1; j < n; j++ {
+			hi, lo = bits.Mul64(u, mm.m[j])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[j-1], c = lo, hi
+		}
+		t[n-1], carry = bits.Add64(t[n], c, 0)
+		t[n] = t[n+1] + carry
+	}
+
+	// t < 2m, so at most one subtraction of m is needed, and we always do it
+	return mm.reduceOnce(t[:n], t[n])
+}
+
+// This returns t - m when t >= m and t otherwise, where t has an extra top limb
+func (mm *montModulus) reduceOnce(t []uint64, top uint64) []uint64 {
+	sub := make([]uint64, mm.n)
+	var borrow uint64
+	for j := 0; j < mm.n; j++ {
+		sub[j], borrow = bits.Sub64(t[j], mm.m[j], borrow)
+	}
+	_, borrow = bits.Sub64(top, 0, borrow)
+
+	// borrow is 1 when t < m, then mask is 0 and t is kept
+	mask := borrow - 1
+	out := make([]uint64, mm.n)
+	for j := range out {
+		out[j] = sub[j]&mask | t[j]&^mask
+	}
+	return out
+}
+
+// toMont returns a . R mod m
+func (mm *montModulus) toMont(a []uint64) []uint64 {
+	return mm.mul(a, mm.rr)
+}
+
+// fromMont returns a . R^-1 mod m
+func (mm *montModulus) fromMont(a []uint64) []uint64 {
+	one := make([]uint64, mm.n)
+	one[0] = 1
+	return mm.mul(a, one)
+}
+
+// The window size of exp
+const ctExpWindow = 4
+
+// exp returns base^e mod m, with base < m and e of at most eBits bits
+// Every window gets the same squarings, table scan and multiplication, whatever its digit is
+func (mm *montModulus) exp(base []uint64, e []uint64, eBits int) []uint64 {
+	table := make([][]uint64, 1<<ctExpWindow)
+	table[0] = mm.one
+	table[1] = mm.toMont(base)
+	for d := 2; d < len(table); d++ {
+		table[d] = mm.mul(table[d-1], table[1])
+	}
+
+	acc := mm.one
+	windows := (eBits + ctExpWindow - 1) / ctExpWindow
+	for w := windows - 1; w >= 0; w-- {
+		for i := 0; i < ctExpWindow; i++ {
+			acc = mm.mul(acc, acc)
+		}
+		acc = mm.mul(acc, ctLookup(table, limbWindow(e, w)))
+	}
+	return mm.fromMont(acc)
+}
+
+// This returns the w-th window of ctExpWindow bits of e
+func limbWindow(e []uint64, w int) uint64 {
+	var d uint64
+	for i := ctExpWindow - 1; i >= 0; i-- {
+		bit := w*ctExpWindow + i
+		if bit/64 < len(e) {
+			d = d<<1 | (e[bit/64]>>(bit%64))&1
+		} else {
+			d <<= 1
+		}
+	}
+	return d
+}
+
+// ctLookup returns table[d], reading every entry so that d can't be told from the memory accesses
+func ctLookup(table [][]uint64, d uint64) []uint64 {
+	out := make([]uint64, len(table[0]))
+	for i, entry := range table {
+		mask := ctEq(uint64(i), d)
+		for j := range out {
+			out[j] |= entry[j] & mask
+		}
+	}
+	return out
+}
+
+// ctEq returns all ones when a == b, and 0 otherwise
+func ctEq(a uint64, b uint64) uint64 {
+	x := a ^ b
+	// the top bit of x | -x is set unless x is 0
+	return ((x | -x) >> 63) - 1
+}
+
+// subMod returns a - b mod m, for a, b < m
+func (mm *montModulus) subMod(a []uint64, b []uint64) []uint64 {
+	out := make([]uint64, mm.n)
+	var borrow uint64
+	for j := range out {
+		out[j], borrow = bits.Sub64(a[j], b[j], borrow)
+	}
+
+	// add m back when a < b
+	mask := -borrow
+	var carry uint64
+	for j := range out {
+		out[j], carry = bits.Add64(out[j], mm.m[j]&mask, carry)
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// For Chaum-Pedersen to be sound nobody may know log_g h, or they could prove anything.
+// A random g and h can't show that, as whoever picked them might have set h = g^a.
+// Deriving both from a public seed with a hash, as in FIPS 186-4 A.2.3, lets anyone check
+// that g and h came out of SHA-256, which nobody can steer towards a known logarithm.
+
+// The index of A.2.3 keeps g and h apart, even though they come from the same seed
+const (
+	GeneratorIndexG uint8 = 1
+	GeneratorIndexH uint8 = 2
+)
+
+// Derivation records how g and h were derived from a public seed, so that anyone can re-derive them
+type Derivation struct {
+	Seed []byte
+	// The counters are the values of count that A.2.3 stopped at
+	// A counter of 0 means that the generator was not derived from the seed,
+	// like the fixed g = 2 of the standard groups
+	GCounter uint16
+	HCounter uint16
+}
+
+// DeriveGenerator is the verifiable canonical generation of FIPS 186-4 A.2.3 with SHA-256
+// for count = 1, 2, ...:
+// W = SHA-256(seed || "ggen" || index || count), g = W^((p-1)/q) mod p, until g >= 2
+// It returns the generator and the count it was found at
+func DeriveGenerator(p *big.Int, q *big.Int, seed []byte, index uint8) (*big.Int, uint16, error) {
+	if len(seed) == 0 {
+		return nil, 0, fmt.Errorf("the seed must not be empty")
+	}
+
+	pMinusOne := new(big.Int).Sub(p, big.NewInt(1))
+	e, rem := new(big.Int).QuoRem(pMinusOne, q, new(big.Int))
+	if rem.Sign() != 0 {
+		return nil, 0, fmt.Errorf("q:'%d' needs to divide evenly to p-1 where p:'%d'", q, p)
+	}
+
+	u := make([]byte, 0, len(seed)+len("ggen")+3)
+	u = append(u, seed...)
+	u = append(u, "ggen"...)
+	u = append(u, index)
+	u = append(u, 0, 0)
+
+	for count := uint16(1); count != 0; count++ {
+		binary.BigEndian.PutUint16(u[len(u)-2:], count)
+		w := sha256.Sum256(u)
+
+		g := new(big.Int).Exp(new(big.Int).SetBytes(w[:]), e, p)
+		if g.Cmp(big.NewInt(2)) >= 0 {
+			return g, count, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no generator found for index %d", index)
+}
+
+// VerifyDerivation re-derives g and h from the recorded seed and checks that they match,
+// so that auditors can confirm there is no trapdoor
+// h must always be derived, g may instead be the fixed g = 2 of the standard groups,
+// a hash-derived h is as far from 2 as it is from a derived g
+func VerifyDerivation(params *Params) error {
+	if params.Derivation == nil {
+		return fmt.Errorf("the parameters don't record how g and h were derived")
+	}
+	d := params.Derivation
+
+	for _, v := range []struct {
+		name    string
+		index   uint8
+		value   *big.Int
+		counter uint16
+	}{
+		{"g", GeneratorIndexG, params.G, d.GCounter},
+		{"h", GeneratorIndexH, params.H, d.HCounter},
+	} {
+		if v.counter == 0 {
+			if v.index == GeneratorIndexG && v.value.Cmp(big.NewInt(2)) == 0 {
+				continue
+			}
+			return fmt.Errorf("%s:'%d' was not derived from the seed", v.name, v.value)
+		}
+
+		derived, counter, err := DeriveGenerator(params.P, params.Q, d.Seed, v.index)
+		if err != nil {
+			return err
+		}
+		if derived.Cmp(v.value) != 0 {
+			return fmt.Errorf("%s:'%d' does not match '%d' derived from the seed", v.name, v.value, derived)
+		}
+		if counter != v.counter {
+			return fmt.Errorf("%s was derived at count %d, but the parameters say %d", v.name, counter, v.counter)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"math/big"
+)
+
+// g and h never change, so their powers can be worked out once when the server starts.
+// With a table of base^(d . 2^(w.j)) for every w bit digit d and window j,
+// base^e is just one multiplication per window of e, with no squarings at all.
+// For a 256 bit q and w = 8 that is 32 multiplications instead of the 256 squarings
+// and 64 multiplications of big.Int.Exp.
+
+// FixedBase holds the powers of a base that is raised to many exponents mod m
+type FixedBase struct {
+	base *big.Int
+	m    *big.Int
+	// The window size, and the longest exponent the table covers
+	window uint
+	bits   int
+	// table[j][d] = base^(d . 2^(window . j)) mod m
+	table [][]*big.Int
+}
+
+// NewFixedBase builds the table for exponents of up to bits bits
+// Wide windows need fewer multiplications but 2^window entries per window,
+// so big exponents get narrower windows to keep the table to a few MB
+func NewFixedBase(base *big.Int, m *big.Int, bits int) *FixedBase {
+	w := uint(8)
+	if bits > 512 {
+		w = 4
+	}
+
+	fb := &FixedBase{base: base, m: m, window: w, bits: bits}
+	windows := (bits + int(w) - 1) / int(w)
+	fb.table = make([][]*big.Int, windows)
+
+	// cur = base^(2^(w.j))
+	cur := new(big.Int).Mod(base, m)
+	for j := range fb.table {
+		row := make([]*big.Int, 1<<w)
+		row[0] = new(big.Int).Mod(big.NewInt(1), m)
+		for d := 1; d < len(row); d++ {
+			row[d] = mulMod(row[d-1], cur, m)
+		}
+		fb.table[j] = row
+		cur = mulMod(row[len(row)-1], cur, m)
+	}
+	return fb
+}
+
+// Exp returns base^e mod m
+// Exponents the table doesn't cover, negative or too long, are handed to big.Int.Exp
+func (fb *FixedBase) Exp(e *big.Int) *big.Int {
+	if e.Sign() < 0 || e.BitLen() > fb.bits {
+		return new(big.Int).Exp(fb.base, e, fb.m)
+	}
+
+	acc := new(big.Int).Mod(big.NewInt(1), fb.m)
+	for j, row := range fb.table {
+		if d := windowBits(e, j, fb.window); d != 0 {
+			acc = mulMod(acc, row[d], fb.m)
+		}
+	}
+	return acc
+}
+
+// Base returns the base the table was built for
+func (fb *FixedBase) Base() *big.Int {
+	return fb.base
+}
+
+// Precomputed holds the tables for g and h of a parameter set
+type Precomputed struct {
+	Params *Params
+	G      *FixedBase
+	H      *FixedBase
+}
+
+// Precompute builds the tables for g and h, covering exponents up to q
+func Precompute(params *Params) *Precomputed {
+	bits := params.Q.BitLen()
+	return &Precomputed{
+		Params: params,
+		G:      NewFixedBase(params.G, params.P, bits),
+		H:      NewFixedBase(params.H, params.P, bits),
+	}
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// The KDFs that can be used to turn a passphrase into the secret x, or into a key
+const (
+	KDFArgon2id     = "argon2id"
+	KDFPBKDF2SHA256 = "pbkdf2-sha256"
+)
+
+// These bound the KDF parameters we accept from the server,
+// a misbehaving server shouldn't be able to make a client burn all its memory
+const (
+	minSaltLen          = 16
+	maxArgon2Memory     = 1024 * 1024 // in KiB, i.e 1 GiB
+	maxArgon2Time       = 16
+	maxPBKDF2Iterations = 10_000_000
+)
+
+// KDFParams describes how a passphrase is stretched
+// The server stores these at registration, so the client only needs the passphrase to log in
+type KDFParams struct {
+	Algorithm string
+	Salt      []byte
+	// Time is the number of passes for argon2id and the number of iterations for PBKDF2
+	Time uint32
+	// Memory is in KiB and Threads is the parallelism, both are only used by argon2id
+	Memory  uint32
+	Threads uint8
+}
+
+// NewKDFParams returns the recommended parameters for a KDF with a fresh random salt
+// argon2id uses the second recommendation of RFC 9106, PBKDF2 the OWASP iteration count
+func NewKDFParams(algorithm string) (*KDFParams, error) {
+	salt := make([]byte, minSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %v", err)
+	}
+
+	switch algorithm {
+	case KDFArgon2id:
+		return &KDFParams{Algorithm: algorithm, Salt: salt, Time: 3, Memory: 64 * 1024, Threads: 4}, nil
+	case KDFPBKDF2SHA256:
+		return &KDFParams{Algorithm: algorithm, Salt: salt, Time: 600_000}, nil
+	default:
+		return nil, fmt.Errorf("unknown kdf: '%s'", algorithm)
+	}
+}
+
+// Validate checks that the parameters are usable and not unreasonably expensive
+func (kdf *KDFParams) Validate() error {
+	if len(kdf.Salt) < minSaltLen {
+		return fmt.Errorf("salt must be at least %d bytes, got %d", minSaltLen, len(kdf.Salt))
+	}
+	if kdf.Time == 0 {
+		return fmt.Errorf("kdf time must not be 0")
+	}
+
+	switch kdf.Algorithm {
+	case KDFArgon2id:
+		if kdf.Time > maxArgon2Time {
+			return fmt.Errorf("argon2id time:'%d' is over the maximum of %d", kdf.Time, maxArgon2Time)
+		}
+		if kdf.Memory == 0 || kdf.Memory > maxArgon2Memory {
+			return fmt.Errorf("argon2id memory:'%d' must be between 1 and %d KiB", kdf.Memory, maxArgon2Memory)
+		}
+		if kdf.Threads == 0 {
+			return fmt.Errorf("argon2id threads must not be 0")
+		}
+	case KDFPBKDF2SHA256:
+		if kdf.Time > maxPBKDF2Iterations {
+			return fmt.Errorf("pbkdf2 iterations:'%d' is over the maximum of %d", kdf.Time, maxPBKDF2Iterations)
+		}
+	default:
+		return fmt.Errorf("unknown kdf: '%s'", kdf.Algorithm)
+	}
+	return nil
+}
+
+// DeriveKey stretches a passphrase into keyLen bytes
+func DeriveKey(passphrase string, kdf *KDFParams, keyLen uint32) ([]byte, error) {
+	if err := kdf.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch kdf.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(passphrase), kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, keyLen), nil
+	default:
+		return pbkdf2SHA256([]byte(passphrase), kdf.Salt, int(kdf.Time), int(keyLen)), nil
+	}
+}
+
+// DeriveSecret deterministically turns a passphrase into a secret x in [1, q)
+// We derive 128 bits more than q needs, so that reducing mod q-1 has negligible bias
+func DeriveSecret(passphrase string, kdf *KDFParams, q *big.Int) (*big.Int, error) {
+	if q.Cmp(big.NewInt(2)) <= 0 {
+		return nil, fmt.Errorf("q:'%d' is too small", q)
+	}
+
+	key, err := DeriveKey(passphrase, kdf, uint32((q.BitLen()+7)/8+16))
+	if err != nil {
+		return nil, err
+	}
+
+	// x = key mod (q - 1) + 1
+	qMinusOne := new(big.Int).Sub(q, big.NewInt(1))
+	x := new(big.Int).Mod(new(big.Int).SetBytes(key), qMinusOne)
+	return x.Add(x, big.NewInt(1)), nil
+}
+
+// pbkdf2SHA256 is PBKDF2 from RFC 8018 using HMAC-SHA256,
+// built from the standard library so that it works without argon2
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLen+sha256.Size)
+	block := make([]byte, 4)
+	u := make([]byte, sha256.Size)
+
+	for i := uint32(1); len(key) < keyLen; i++ {
+		// U_1 = PRF(password, salt || INT(i))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(block, i)
+		prf.Write(block)
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, len(u))
+		copy(t, u)
+
+		// U_j = PRF(password, U_{j-1}), T_i = U_1 ^ ... ^ U_c
+		for j := 1; j < iterations; j++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for n := range t {
+				t[n] ^= u[n]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The window size of MultiExp, each base gets a table of 2^multiExpWindow powers
+const multiExpWindow = 4
+
+// MultiExp computes bases[0]^exps[0] . bases[1]^exps[1] ... mod m
+// This is Straus' interleaved method: the squarings are shared between all of the bases,
+// so n exponentiations cost about as many squarings as one, plus a multiplication per base and window
+// The exponents must not be negative
+func MultiExp(bases []*big.Int, exps []*big.Int, m *big.Int) (*big.Int, error) {
+	if len(bases) != len(exps) {
+		return nil, fmt.Errorf("there are %d bases but %d exponents", len(bases), len(exps))
+	}
+
+	maxBits := 0
+	for i, e := range exps {
+		if e.Sign() < 0 {
+			return nil, fmt.Errorf("exponent %d:'%d' is negative", i, e)
+		}
+		if e.BitLen() > maxBits {
+			maxBits = e.BitLen()
+		}
+	}
+
+	// tables[i][d] = bases[i]^d mod m
+	tables := make([][]*big.Int, len(bases))
+	for i, b := range bases {
+		table := make([]*big.Int, 1<<multiExpWindow)
+		table[0] = big.NewInt(1)
+		table[1] = new(big.Int).Mod(b, m)
+		for d := 2; d < len(table); d++ {
+			table[d] = mulMod(table[d-1], table[1], m)
+		}
+		tables[i] = table
+	}
+
+	acc := new(big.Int).Mod(big.NewInt(1), m)
+	windows := (maxBits + multiExpWindow - 1) / multiExpWindow
+	for w := windows - 1; w >= 0; w-- {
+		if w != windows-1 {
+			for j := 0; j < multiExpWindow; j++ {
+				acc = mulMod(acc, acc, m)
+			}
+		}
+		for i, e := range exps {
+			if d := windowBits(e, w, multiExpWindow); d != 0 {
+				acc = mulMod(acc, tables[i][d], m)
+			}
+		}
+	}
+	return acc, nil
+}
+
+// This returns the j-th window of w bits of e, counting from the least significant
+func windowBits(e *big.Int, j int, w uint) uint {
+	var d uint
+	for i := int(w) - 1; i >= 0; i-- {
+		d = d<<1 | e.Bit(j*int(w)+i)
+	}
+	return d
+}
+
+func mulMod(a *big.Int, b *big.Int, m *big.Int) *big.Int {
+	z := new(big.Int).Mul(a, b)
+	return z.Mod(z, m)
+}
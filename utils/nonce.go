@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// ErrNonceReused is returned by NonceTracker when a k comes round a second time
+var ErrNonceReused = errors.New("k has been used before")
+
+// Using the same k with two different challenges c1 and c2 gives away the secret:
+// s1 - s2 = (c2 - c1) . x mod q
+// DeterministicK derives k as in RFC 6979 section 3.2, from x and a hash of the transcript,
+// with fresh randomness mixed in as the additional data of section 3.6.
+// The randomness makes k different for every login even when the transcript is the same,
+// and x keeps k unpredictable even when the randomness is broken.
+// A nil random gives the pure RFC 6979 k, which repeats for a repeated transcript,
+// so that is only useful for test vectors.
+func DeterministicK(x *big.Int, q *big.Int, transcript []byte, random io.Reader) (*big.Int, error) {
+	if q.Cmp(big.NewInt(2)) <= 0 {
+		return nil, fmt.Errorf("q:'%d' is too small", q)
+	}
+
+	var noise []byte
+	if random != nil {
+		noise = make([]byte, sha256.Size)
+		if _, err := io.ReadFull(random, noise); err != nil {
+			return nil, fmt.Errorf("could not read randomness for k: %v", err)
+		}
+	}
+
+	qlen := q.BitLen()
+	h1 := sha256.Sum256(transcript)
+
+	// Step b and c
+	v := make([]byte, sha256.Size)
+	for i := range v {
+		v[i] = 0x01
+	}
+	key := make([]byte, sha256.Size)
+
+	// Steps d to g
+	seed := append(int2octets(new(big.Int).Mod(x, q), qlen), bits2octets(h1[:], q)...)
+	seed = append(seed, noise...)
+	for _, b := range []byte{0x00, 0x01} {
+		key = hmacSHA256(key, v, []byte{b}, seed)
+		v = hmacSHA256(key, v)
+	}
+
+	// Step h
+	for {
+		t := make([]byte, 0, (qlen+7)/8+sha256.Size)
+		for len(t)*8 < qlen {
+			v = hmacSHA256(key, v)
+			t = append(t, v...)
+		}
+
+		k := bits2int(t, qlen)
+		if k.Sign() > 0 && k.Cmp(q) < 0 {
+			return k, nil
+		}
+
+		key = hmacSHA256(key, v, []byte{0x00})
+		v = hmacSHA256(key, v)
+	}
+}
+
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// bits2int takes the leftmost qlen bits of b, RFC 6979 section 2.3.2
+func bits2int(b []byte, qlen int) *big.Int {
+	v := new(big.Int).SetBytes(b)
+	if blen := len(b) * 8; blen > qlen {
+		v.Rsh(v, uint(blen-qlen))
+	}
+	return v
+}
+
+// int2octets writes v big-endian in exactly ceil(qlen/8) bytes, RFC 6979 section 2.3.3
+func int2octets(v *big.Int, qlen int) []byte {
+	out := make([]byte, (qlen+7)/8)
+	return v.FillBytes(out)
+}
+
+// bits2octets is bits2int reduced mod q, RFC 6979 section 2.3.4
+func bits2octets(b []byte, q *big.Int) []byte {
+	z := bits2int(b, q.BitLen())
+	return int2octets(z.Mod(z, q), q.BitLen())
+}
+
+// NonceTracker remembers every k it has been shown and refuses to see one twice
+// Only a hash of k is kept, so the tracker doesn't hold anything secret
+type NonceTracker struct {
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]struct{}
+	// The same hashes in the order they were used, for Export
+	order [][sha256.Size]byte
+}
+
+func NewNonceTracker() *NonceTracker {
+	return &NonceTracker{seen: make(map[[sha256.Size]byte]struct{})}
+}
+
+// LoadNonceTracker returns a tracker that has seen the ks of digests, as Export returned them
+// so that a tracker can outlive the process, a client that logs in once per run needs that
+func LoadNonceTracker(digests []string) (*NonceTracker, error) {
+	nt := NewNonceTracker()
+	for _, d := range digests {
+		var sum [sha256.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(d)); err != nil || n != sha256.Size || len(d) != 2*sha256.Size {
+			return nil, fmt.Errorf("'%s' is not the hex SHA-256 hash of a k", d)
+		}
+		if _, exists := nt.seen[sum]; !exists {
+			nt.seen[sum] = struct{}{}
+			nt.order = append(nt.order, sum)
+		}
+	}
+	return nt, nil
+}
+
+// Use records k, it returns ErrNonceReused if k has been used before
+func (nt *NonceTracker) Use(k *big.Int) error {
+	sum := sha256.Sum256(k.Bytes())
+
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	if _, exists := nt.seen[sum]; exists {
+		return ErrNonceReused
+	}
+	nt.seen[sum] = struct{}{}
+	nt.order = append(nt.order, sum)
+	return nil
+}
+
+// Export returns the hashes of the ks used so far in hex, oldest first
+func (nt *NonceTracker) Export() []string {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	digests := make([]string, len(nt.order))
+	for i, sum := range nt.order {
+		digests[i] = hex.EncodeToString(sum[:])
+	}
+	return digests
+}
@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The types of group a parameter set can be
+const (
+	// q is a prime factor of p - 1, much smaller than p, as made by GenerateParams
+	GroupTypeSchnorr = "schnorr"
+	// p = 2q + 1, as in the standard groups
+	GroupTypeSafePrime = "safe-prime"
+)
+
+// Params is a set of public variables for the Chaum-Pedersen protocol
+// q is a prime dividing p - 1, and g and h generate the subgroup of order q
+type Params struct {
+	// Name, Type and Created describe the parameter set, they are not part of its fingerprint
+	Name    string
+	Type    string
+	Created time.Time
+
+	P *big.Int
+	Q *big.Int
+	G *big.Int
+	H *big.Int
+
+	// This is nil when g and h were not derived from a seed
+	Derivation *Derivation
+
+	// The backend for arithmetic on secrets, see NewArithmetic, math/big when empty
+	// It is not part of the fingerprint, the server and each client can pick their own
+	Arithmetic string
+}
+
+// This is how a parameter set looks in a file, numbers are decimal strings
+// The order of the fields is fixed, so json.Marshal of this is the canonical form of a parameter set
+type paramsJSON struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+
+	P string `json:"p"`
+	Q string `json:"q"`
+	G string `json:"g"`
+	H string `json:"h"`
+
+	Seed     string `json:"seed,omitempty"`
+	GCounter uint16 `json:"g_counter,omitempty"`
+	HCounter uint16 `json:"h_counter,omitempty"`
+
+	// RFC 3339 in UTC
+	Created string `json:"created,omitempty"`
+
+	Arithmetic string `json:"arithmetic,omitempty"`
+}
+
+// MarshalJSON returns the canonical form of the parameter set,
+// the fields always come in the same order, with no whitespace and decimal numbers
+func (params *Params) MarshalJSON() ([]byte, error) {
+	raw := paramsJSON{
+		Name: params.Name,
+		Type: params.Type,
+		P:    params.P.String(),
+		Q:    params.Q.String(),
+		G:    params.G.String(),
+		H:    params.H.String(),
+	}
+	if d := params.Derivation; d != nil {
+		raw.Seed = hex.EncodeToString(d.Seed)
+		raw.GCounter = d.GCounter
+		raw.HCounter = d.HCounter
+	}
+	if !params.Created.IsZero() {
+		raw.Created = params.Created.UTC().Format(time.RFC3339)
+	}
+	raw.Arithmetic = params.Arithmetic
+	return json.Marshal(raw)
+}
+
+func (params *Params) UnmarshalJSON(data []byte) error {
+	var raw paramsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	parsed, err := ParseParams(raw.P, raw.Q, raw.G, raw.H)
+	if err != nil {
+		return err
+	}
+	parsed.Name = raw.Name
+	parsed.Type = raw.Type
+	parsed.Arithmetic = raw.Arithmetic
+
+	if raw.Created != "" {
+		parsed.Created, err = time.Parse(time.RFC3339, raw.Created)
+		if err != nil {
+			return fmt.Errorf("created:'%s' is not an RFC 3339 time: %v", raw.Created, err)
+		}
+	}
+
+	if raw.Seed != "" {
+		seed, err := hex.DecodeString(raw.Seed)
+		if err != nil {
+			return fmt.Errorf("seed:'%s' is not hex: %v", raw.Seed, err)
+		}
+		parsed.Derivation = &Derivation{Seed: seed, GCounter: raw.GCounter, HCounter: raw.HCounter}
+	}
+
+	*params = *parsed
+	return nil
+}
+
+// ParseParams reads p, q, g and h from decimal strings
+func ParseParams(p string, q string, g string, h string) (*Params, error) {
+	params := &Params{}
+	for _, v := range []struct {
+		name string
+		in   string
+		out  **big.Int
+	}{
+		{"p", p, &params.P},
+		{"q", q, &params.Q},
+		{"g", g, &params.G},
+		{"h", h, &params.H},
+	} {
+		n, ok := new(big.Int).SetString(v.in, 10)
+		if !ok {
+			return nil, fmt.Errorf("%s:'%s' is not a number", v.name, v.in)
+		}
+		*v.out = n
+	}
+	return params, nil
+}
+
+// ReadParamsFile loads a parameter set written by WriteParamsFile, either as JSON or armored
+func ReadParamsFile(path string) (*Params, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := UnmarshalParams(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read parameters from '%s': %v", path, err)
+	}
+	return params, nil
+}
+
+// WriteParamsFile saves a parameter set as JSON, or armored when the path ends in .pem
+func WriteParamsFile(path string, params *Params) error {
+	format := ParamsFormatJSON
+	if filepath.Ext(path) == ".pem" {
+		format = ParamsFormatPEM
+	}
+
+	data, err := MarshalParams(params, format)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Equal reports whether two parameter sets are the same group, whatever they are called
+func (params *Params) Equal(other *Params) bool {
+	return params.P.Cmp(other.P) == 0 && params.Q.Cmp(other.Q) == 0 &&
+		params.G.Cmp(other.G) == 0 && params.H.Cmp(other.H) == 0
+}
+
+// GenerateParams creates a new Schnorr group https://en.wikipedia.org/wiki/Schnorr_group
+// with a pBits prime p and a qBits prime q dividing p - 1, e.g 2048 and 256.
+// This follows FIPS 186-4 A.1.1.2 in spirit, but with random rather than seeded candidates:
+// 1. pick a random prime q
+// 2. pick random pBits numbers X and set p = X - (X mod 2q) + 1 until p is prime, so that q | p - 1
+// 3. derive g and h from seed with DeriveGenerator, a nil seed is replaced by 32 random bytes
+func GenerateParams(random io.Reader, pBits int, qBits int, seed []byte) (*Params, error) {
+	if qBits < 2 || pBits <= qBits {
+		return nil, fmt.Errorf("p must have more bits than q, got p:%d q:%d", pBits, qBits)
+	}
+
+	q, err := randPrime(random, qBits)
+	if err != nil {
+		return nil, err
+	}
+
+	twoQ := new(big.Int).Lsh(q, 1)
+	p := new(big.Int)
+	for {
+		x, err := randBits(random, pBits)
+		if err != nil {
+			return nil, err
+		}
+
+		// p = X - (X mod 2q) + 1
+		c := new(big.Int).Mod(x, twoQ)
+		p.Sub(x, c).Add(p, big.NewInt(1))
+		if p.BitLen() == pBits && p.ProbablyPrime(64) {
+			break
+		}
+	}
+
+	if seed == nil {
+		seed = make([]byte, 32)
+		if _, err := io.ReadFull(random, seed); err != nil {
+			return nil, fmt.Errorf("could not read randomness: %v", err)
+		}
+	}
+
+	params, err := DeriveParams(p, q, seed)
+	if err != nil {
+		return nil, err
+	}
+	params.Type = GroupTypeSchnorr
+	return params, nil
+}
+
+// DeriveParams derives g and h for the group p, q from a public seed
+func DeriveParams(p *big.Int, q *big.Int, seed []byte) (*Params, error) {
+	g, gCounter, err := DeriveGenerator(p, q, seed, GeneratorIndexG)
+	if err != nil {
+		return nil, err
+	}
+
+	h, hCounter, err := DeriveGenerator(p, q, seed, GeneratorIndexH)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only possible for tiny groups, but then g and h would be useless
+	if g.Cmp(h) == 0 {
+		return nil, fmt.Errorf("g and h are both '%d' for this seed", g)
+	}
+
+	return &Params{
+		P:          p,
+		Q:          q,
+		G:          g,
+		H:          h,
+		Derivation: &Derivation{Seed: seed, GCounter: gCounter, HCounter: hCounter},
+	}, nil
+}
+
+// This returns a random prime of exactly bits bits
+func randPrime(random io.Reader, bits int) (*big.Int, error) {
+	for {
+		n, err := randBits(random, bits)
+		if err != nil {
+			return nil, err
+		}
+		n.SetBit(n, 0, 1)
+		if n.ProbablyPrime(64) {
+			return n, nil
+		}
+	}
+}
+
+// This returns a random number of exactly bits bits, i.e with the top bit set
+func randBits(random io.Reader, bits int) (*big.Int, error) {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	n, err := randInt(random, max)
+	if err != nil {
+		return nil, err
+	}
+	return n.SetBit(n, bits-1, 1), nil
+}
+
+// This returns a uniform random number in [0, max)
+func randInt(random io.Reader, max *big.Int) (*big.Int, error) {
+	n, err := rand.Int(random, max)
+	if err != nil {
+		return nil, fmt.Errorf("could not read randomness: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The formats a parameter set can be written in
+const (
+	ParamsFormatJSON = "json"
+	// A PEM block whose body is the canonical JSON, with the name, type, creation date and fingerprint as headers
+	ParamsFormatPEM = "pem"
+)
+
+const paramsPEMType = "ZKP AUTH PARAMETERS"
+
+// Fingerprint is the SHA-256 of the canonical form of p, q, g and h in hex
+// The name, type, creation date and seed are left out, so a renamed file is still the same group
+// Two parameter sets with the same fingerprint prove and verify the same way
+func (params *Params) Fingerprint() string {
+	group := &Params{P: params.P, Q: params.Q, G: params.G, H: params.H}
+	// This can't fail, MarshalJSON only formats strings
+	canonical, _ := group.MarshalJSON()
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// ShortFingerprint is the first 8 bytes of the fingerprint, enough to tell parameter sets apart by eye
+func (params *Params) ShortFingerprint() string {
+	return params.Fingerprint()[:16]
+}
+
+// MatchesFingerprint reports whether fingerprint is this parameter set's, either in full or short
+// Case and colons are ignored, so that fingerprints can be copied from anywhere
+func (params *Params) MatchesFingerprint(fingerprint string) bool {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	if len(fingerprint) != 16 && len(fingerprint) != 64 {
+		return false
+	}
+	return strings.HasPrefix(params.Fingerprint(), fingerprint)
+}
+
+// MarshalParams writes a parameter set in one of the formats
+// JSON is indented for people, the canonical form is MarshalJSON
+func MarshalParams(params *Params, format string) ([]byte, error) {
+	switch format {
+	case ParamsFormatJSON:
+		data, err := json.MarshalIndent(params, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case ParamsFormatPEM:
+		canonical, err := params.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		headers := map[string]string{"Fingerprint": params.Fingerprint()}
+		if params.Name != "" {
+			headers["Name"] = params.Name
+		}
+		if params.Type != "" {
+			headers["Type"] = params.Type
+		}
+		if !params.Created.IsZero() {
+			headers["Created"] = params.Created.UTC().Format(time.RFC3339)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: paramsPEMType, Headers: headers, Bytes: canonical}), nil
+	default:
+		return nil, fmt.Errorf("unknown parameter format: '%s', the formats are json and pem", format)
+	}
+}
+
+// UnmarshalParams reads a parameter set in either format
+// The headers of the armored form are only a preview of the body, so they must agree with it
+func UnmarshalParams(data []byte) (*Params, error) {
+	params := &Params{}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		if err := json.Unmarshal(data, params); err != nil {
+			return nil, err
+		}
+		return params, nil
+	}
+
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != paramsPEMType {
+		return nil, fmt.Errorf("no %s block found", paramsPEMType)
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, fmt.Errorf("unexpected data after the %s block", paramsPEMType)
+	}
+	if err := json.Unmarshal(block.Bytes, params); err != nil {
+		return nil, err
+	}
+
+	fingerprint, exists := block.Headers["Fingerprint"]
+	if !exists {
+		return nil, fmt.Errorf("the %s block has no fingerprint", paramsPEMType)
+	}
+	if fingerprint != params.Fingerprint() {
+		return nil, fmt.Errorf("fingerprint:'%s' does not match '%s' of the parameters", fingerprint, params.Fingerprint())
+	}
+	for header, value := range map[string]string{"Name": params.Name, "Type": params.Type} {
+		if block.Headers[header] != value {
+			return nil, fmt.Errorf("%s:'%s' does not match '%s' of the parameters", strings.ToLower(header), block.Headers[header], value)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ValidationPolicy sets how demanding ValidateParams is
+type ValidationPolicy struct {
+	Name string
+	// The minimum sizes of p and q in bits, 0 for no minimum
+	MinPBits int
+	MinQBits int
+	// The number of Miller-Rabin rounds, on top of the Baillie-PSW test that ProbablyPrime always does
+	PrimalityRounds int
+}
+
+var (
+	// PolicyToy accepts groups of any size, which is only good for the toy examples
+	PolicyToy = ValidationPolicy{Name: "toy", PrimalityRounds: 64}
+	// PolicyDefault matches NIST SP 800-57 for 112 bits of security, and leaves room for a 256 bit q
+	PolicyDefault = ValidationPolicy{Name: "default", MinPBits: 2048, MinQBits: 224, PrimalityRounds: 64}
+	// PolicyStrict is 128 bits of security
+	PolicyStrict = ValidationPolicy{Name: "strict", MinPBits: 3072, MinQBits: 256, PrimalityRounds: 64}
+)
+
+// PolicyByName returns one of the policies above
+func PolicyByName(name string) (ValidationPolicy, error) {
+	for _, policy := range []ValidationPolicy{PolicyToy, PolicyDefault, PolicyStrict} {
+		if policy.Name == name {
+			return policy, nil
+		}
+	}
+	return ValidationPolicy{}, fmt.Errorf("unknown policy: '%s', the policies are toy, default and strict", name)
+}
+
+// The names of the checks ValidateParams runs, in order
+const (
+	CheckPPrime      = "p is prime"
+	CheckQPrime      = "q is prime"
+	CheckPBits       = "p is big enough"
+	CheckQBits       = "q is big enough"
+	CheckQDividesP   = "q divides p-1"
+	CheckGRange      = "g is in (1, p)"
+	CheckGOrder      = "g has order q"
+	CheckHRange      = "h is in (1, p)"
+	CheckHOrder      = "h has order q"
+	CheckGDifferentH = "g is not h"
+)
+
+// Check is the outcome of one of the checks
+type Check struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+// ValidationReport has every check that was run, not just the first one that failed
+type ValidationReport struct {
+	Policy ValidationPolicy
+	Checks []Check
+}
+
+// Valid reports whether every check passed
+func (report *ValidationReport) Valid() bool {
+	return len(report.Failed()) == 0
+}
+
+// Failed returns the checks that did not pass
+func (report *ValidationReport) Failed() []Check {
+	var failed []Check
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// Err describes every failed check, or is nil when they all passed
+func (report *ValidationReport) Err() error {
+	failed := report.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	details := make([]string, 0, len(failed))
+	for _, check := range failed {
+		details = append(details, check.Detail)
+	}
+	return fmt.Errorf("%s", strings.Join(details, "; "))
+}
+
+func (report *ValidationReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "policy: %s\n", report.Policy.Name)
+	for _, check := range report.Checks {
+		status := "ok  "
+		if !check.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&sb, "  [%s] %s: %s\n", status, check.Name, check.Detail)
+	}
+	return sb.String()
+}
+
+func (report *ValidationReport) add(name string, passed bool, format string, args ...interface{}) {
+	report.Checks = append(report.Checks, Check{Name: name, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+}
+
+// ValidateParams runs every check on the public variables and reports on each of them
+// 1. p and q are prime
+// 2. p and q are at least as big as the policy asks for
+// 3. q divides p - 1, so that there is a subgroup of order q
+// 4. g and h are in (1, p), 1 generates nothing and anything outside isn't reduced mod p
+// 5. g^q mod p = 1 and h^q mod p = 1, as q is prime and g, h are not 1 they have order exactly q
+// 6. g is not h, otherwise log_g h = 1 and the two equations of the proof are the same
+func ValidateParams(params *Params, policy ValidationPolicy) *ValidationReport {
+	report := &ValidationReport{Policy: policy}
+	p, q, g, h := params.P, params.Q, params.G, params.H
+	one := big.NewInt(1)
+
+	pPrime := p.Sign() > 0 && p.ProbablyPrime(policy.PrimalityRounds)
+	report.add(CheckPPrime, pPrime, "p:'%d' is %s", p, primeOrNot(pPrime))
+
+	qPrime := q.Sign() > 0 && q.ProbablyPrime(policy.PrimalityRounds)
+	report.add(CheckQPrime, qPrime, "q:'%d' is %s", q, primeOrNot(qPrime))
+
+	report.add(CheckPBits, p.BitLen() >= policy.MinPBits, "p has %d bits, the %s policy needs at least %d", p.BitLen(), policy.Name, policy.MinPBits)
+	report.add(CheckQBits, q.BitLen() >= policy.MinQBits, "q has %d bits, the %s policy needs at least %d", q.BitLen(), policy.Name, policy.MinQBits)
+
+	// Everything below is arithmetic mod p, which only makes sense for p > 2 and q > 1
+	if p.Cmp(big.NewInt(2)) <= 0 || q.Cmp(one) <= 0 {
+		for _, name := range []string{CheckQDividesP, CheckGRange, CheckGOrder, CheckHRange, CheckHOrder, CheckGDifferentH} {
+			report.add(name, false, "%s: skipped as p:'%d' and q:'%d' can't form a group", name, p, q)
+		}
+		return report
+	}
+
+	pMinusOne := new(big.Int).Sub(p, one)
+	divides := new(big.Int).Mod(pMinusOne, q).Sign() == 0
+	report.add(CheckQDividesP, divides, "q:'%d' %s evenly to p-1 where p:'%d'", q, dividesOrNot(divides), p)
+
+	for _, v := range []struct {
+		name       string
+		value      *big.Int
+		rangeCheck string
+		orderCheck string
+	}{
+		{"g", g, CheckGRange, CheckGOrder},
+		{"h", h, CheckHRange, CheckHOrder},
+	} {
+		inRange := v.value.Cmp(one) > 0 && v.value.Cmp(p) < 0
+		report.add(v.rangeCheck, inRange, "%s:'%d' %s in (1, p) where p:'%d'", v.name, v.value, isOrNot(inRange), p)
+
+		// v^q mod p = 1
+		hasOrder := inRange && new(big.Int).Exp(v.value, q, p).Cmp(one) == 0
+		report.add(v.orderCheck, hasOrder, "%s:'%d' %s order q:'%d'", v.name, v.value, hasOrNot(hasOrder), q)
+	}
+
+	different := g.Cmp(h) != 0
+	if different {
+		report.add(CheckGDifferentH, true, "g:'%d' and h:'%d' are different", g, h)
+	} else {
+		report.add(CheckGDifferentH, false, "g and h are both '%d'", g)
+	}
+
+	return report
+}
+
+func primeOrNot(prime bool) string {
+	if prime {
+		return "prime"
+	}
+	return "not prime"
+}
+
+func dividesOrNot(divides bool) string {
+	if divides {
+		return "divides"
+	}
+	return "needs to divide"
+}
+
+func isOrNot(is bool) string {
+	if is {
+		return "is"
+	}
+	return "is not"
+}
+
+func hasOrNot(has bool) string {
+	if has {
+		return "has"
+	}
+	return "does not have"
+}
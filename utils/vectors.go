@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Test vectors let other implementations of the prover and verifier check that they agree
+// with CalculateS and VerifyProof. Each vector is a whole transcript with the secrets that made it,
+// and the results the Go code gives for it. scripts/genvectors writes them, CheckVectors checks them.
+
+// The modes vectors can be for, there is only modular arithmetic for now
+const VectorModeModP = "mod-p"
+
+// Vectors is the file of test vectors
+type Vectors struct {
+	Version     int           `json:"version"`
+	Description string        `json:"description"`
+	Groups      []VectorGroup `json:"groups"`
+	Vectors     []Vector      `json:"vectors"`
+}
+
+// VectorGroup is a parameter set the vectors refer to by name
+type VectorGroup struct {
+	Mode   string  `json:"mode"`
+	Params *Params `json:"params"`
+}
+
+// Vector is one transcript, numbers are decimal strings as everywhere else
+type Vector struct {
+	Name  string `json:"name"`
+	Group string `json:"group"`
+	// What was changed from an honest transcript, empty for an honest one
+	Tampered string `json:"tampered,omitempty"`
+
+	X string `json:"x"`
+	K string `json:"k"`
+	C string `json:"c"`
+
+	// y1 = g^x, y2 = h^x, r1 = g^k and r2 = h^k mod p, always
+	Y1 string `json:"y1"`
+	Y2 string `json:"y2"`
+	R1 string `json:"r1"`
+	R2 string `json:"r2"`
+	// s = (k - c.x) mod q for an honest transcript
+	S string `json:"s"`
+
+	// Whether VerifyProof accepts both equations, which only checks r = g^s . y^c mod p
+	VerifyProof bool `json:"verify_proof"`
+	// The error of VerifyChaumPedersen, which checks the ranges too, empty when it accepts
+	VerifyError string `json:"verify_error"`
+}
+
+// The version of the vector file
+const VectorsVersion = 1
+
+func verifyVector(params *Params, y1, y2, r1, r2, c, s *big.Int) (bool, string) {
+	ok1, _ := VerifyProof(r1, params.G, s, y1, c, params.P)
+	ok2, _ := VerifyProof(r2, params.H, s, y2, c, params.P)
+
+	verifyError := ""
+	if err := VerifyChaumPedersen(params, y1, y2, r1, r2, c, s); err != nil {
+		verifyError = err.Error()
+	}
+	return ok1 && ok2, verifyError
+}
+
+// CheckVectors checks every vector against this implementation, and returns all of the differences
+func CheckVectors(vs *Vectors) error {
+	if vs.Version != VectorsVersion {
+		return fmt.Errorf("the vectors are version %d, only version %d is supported", vs.Version, VectorsVersion)
+	}
+
+	groups := make(map[string]*Params)
+	for _, g := range vs.Groups {
+		if g.Mode != VectorModeModP {
+			return fmt.Errorf("group '%s' is for mode '%s', only '%s' is supported", g.Params.Name, g.Mode, VectorModeModP)
+		}
+		groups[g.Params.Name] = g.Params
+	}
+
+	var errs []error
+	for _, v := range vs.Vectors {
+		if err := checkVector(groups, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", v.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkVector(groups map[string]*Params, v Vector) error {
+	params, ok := groups[v.Group]
+	if !ok {
+		return fmt.Errorf("unknown group '%s'", v.Group)
+	}
+
+	names := []string{"x", "k", "c", "y1", "y2", "r1", "r2", "s"}
+	nums := make([]*big.Int, len(names))
+	for i, value := range []string{v.X, v.K, v.C, v.Y1, v.Y2, v.R1, v.R2, v.S} {
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return fmt.Errorf("%s is not a number: '%s'", names[i], value)
+		}
+		nums[i] = n
+	}
+	x, k, c, y1, y2, r1, r2, s := nums[0], nums[1], nums[2], nums[3], nums[4], nums[5], nums[6], nums[7]
+
+	for _, check := range []struct {
+		name     string
+		got      *big.Int
+		expected *big.Int
+	}{
+		{"y1", y1, new(big.Int).Exp(params.G, x, params.P)},
+		{"y2", y2, new(big.Int).Exp(params.H, x, params.P)},
+		{"r1", r1, new(big.Int).Exp(params.G, k, params.P)},
+		{"r2", r2, new(big.Int).Exp(params.H, k, params.P)},
+	} {
+		if check.got.Cmp(check.expected) != 0 {
+			return fmt.Errorf("%s:'%d' is not '%d'", check.name, check.got, check.expected)
+		}
+	}
+	if expected := CalculateS(k, c, x, params.Q); v.Tampered == "" && s.Cmp(expected) != 0 {
+		return fmt.Errorf("s:'%d' is not (k - c.x) mod q:'%d'", s, expected)
+	}
+
+	verifyProof, verifyError := verifyVector(params, y1, y2, r1, r2, c, s)
+	if verifyProof != v.VerifyProof {
+		return fmt.Errorf("VerifyProof returned %v, the vector expects %v", verifyProof, v.VerifyProof)
+	}
+	if verifyError != v.VerifyError {
+		return fmt.Errorf("VerifyChaumPedersen returned '%s', the vector expects '%s'", verifyError, v.VerifyError)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"math/big"
+)
+
+// VerifyError says why VerifyChaumPedersen or VerifyProof refused a proof
+// It is comparable, so callers can use errors.Is(err, ErrR1Mismatch)
+type VerifyError int
+
+const (
+	// One of the numbers is missing
+	ErrMissingValue VerifyError = iota + 1
+	// y1 or y2 is not in (1, p), y = 1 would mean x = 0, which anyone can prove
+	ErrY1OutOfRange
+	ErrY2OutOfRange
+	// y1 or y2 is not in the subgroup of order q
+	ErrY1NotInSubgroup
+	ErrY2NotInSubgroup
+	// r1 or r2 is not in [1, p)
+	ErrR1OutOfRange
+	ErrR2OutOfRange
+	// c is not in [1, q), with c = 0 the proof doesn't depend on x at all
+	ErrChallengeOutOfRange
+	// s is not in [0, q)
+	ErrResponseOutOfRange
+	// r1 != g^s . y1^c mod p
+	ErrR1Mismatch
+	// r2 != h^s . y2^c mod p
+	ErrR2Mismatch
+	// r != gh^s . y^c mod p, from VerifyProof, which checks one equation and can't tell r1 from r2
+	ErrRMismatch
+)
+
+func (e VerifyError) Error() string {
+	switch e {
+	case ErrMissingValue:
+		return "a value of the proof is missing"
+	case ErrY1OutOfRange:
+		return "y1 is not in (1, p)"
+	case ErrY2OutOfRange:
+		return "y2 is not in (1, p)"
+	case ErrY1NotInSubgroup:
+		return "y1 is not in the subgroup of order q"
+	case ErrY2NotInSubgroup:
+		return "y2 is not in the subgroup of order q"
+	case ErrR1OutOfRange:
+		return "r1 is not in [1, p)"
+	case ErrR2OutOfRange:
+		return "r2 is not in [1, p)"
+	case ErrChallengeOutOfRange:
+		return "c is not in [1, q)"
+	case ErrResponseOutOfRange:
+		return "s is not in [0, q)"
+	case ErrR1Mismatch:
+		return "r1 does not match g^s . y1^c mod p"
+	case ErrR2Mismatch:
+		return "r2 does not match h^s . y2^c mod p"
+	case ErrRMismatch:
+		return "r does not match gh^s . y^c mod p"
+	default:
+		return "unknown verification error"
+	}
+}
+
+// VerifyChaumPedersen checks a proof that log_g y1 = log_h y2, for the commitment r1, r2,
+// the challenge c and the response s
+// Every input is checked before the equations, and the first problem found is returned as a VerifyError
+// r1 and r2 are not checked for subgroup membership on their own: once y1 is in the subgroup
+// so is g^s . y1^c, so an r1 outside of it can't match
+func VerifyChaumPedersen(params *Params, y1, y2, r1, r2, c, s *big.Int) error {
+	gExp := func(e *big.Int) *big.Int { return new(big.Int).Exp(params.G, e, params.P) }
+	hExp := func(e *big.Int) *big.Int { return new(big.Int).Exp(params.H, e, params.P) }
+	return verifyChaumPedersen(params, gExp, hExp, y1, y2, r1, r2, c, s)
+}
+
+// VerifyChaumPedersen is the same as the function of that name, with g and h from the tables
+func (pre *Precomputed) VerifyChaumPedersen(y1, y2, r1, r2, c, s *big.Int) error {
+	return verifyChaumPedersen(pre.Params, pre.G.Exp, pre.H.Exp, y1, y2, r1, r2, c, s)
+}
+
+// ValidateStatement checks y1 and y2, as a server should when a user registers
+func ValidateStatement(params *Params, y1, y2 *big.Int) error {
+	if y1 == nil || y2 == nil {
+		return ErrMissingValue
+	}
+
+	one := big.NewInt(1)
+	if y1.Cmp(one) <= 0 || y1.Cmp(params.P) >= 0 {
+		return ErrY1OutOfRange
+	}
+	if y2.Cmp(one) <= 0 || y2.Cmp(params.P) >= 0 {
+		return ErrY2OutOfRange
+	}
+	if !InSubgroup(params, y1) {
+		return ErrY1NotInSubgroup
+	}
+	if !InSubgroup(params, y2) {
+		return ErrY2NotInSubgroup
+	}
+	return nil
+}
+
+// ValidateCommitment checks r1 and r2, as a server can before it hands out a challenge
+// It only checks that they are in [1, p): an r outside of the subgroup can't match the equations,
+// and checking membership here would cost as much as the equations
+func ValidateCommitment(params *Params, r1, r2 *big.Int) error {
+	if r1 == nil || r2 == nil {
+		return ErrMissingValue
+	}
+	if r1.Sign() <= 0 || r1.Cmp(params.P) >= 0 {
+		return ErrR1OutOfRange
+	}
+	if r2.Sign() <= 0 || r2.Cmp(params.P) >= 0 {
+		return ErrR2OutOfRange
+	}
+	return nil
+}
+
+// This checks everything but the subgroup membership of y1 and y2, and the equations
+// They are cheap, so BatchVerify runs them on every proof too
+func checkProofRanges(params *Params, y1, y2, r1, r2, c, s *big.Int) error {
+	for _, v := range []*big.Int{y1, y2, r1, r2, c, s} {
+		if v == nil {
+			return ErrMissingValue
+		}
+	}
+
+	one := big.NewInt(1)
+	if y1.Cmp(one) <= 0 || y1.Cmp(params.P) >= 0 {
+		return ErrY1OutOfRange
+	}
+	if y2.Cmp(one) <= 0 || y2.Cmp(params.P) >= 0 {
+		return ErrY2OutOfRange
+	}
+	if err := ValidateCommitment(params, r1, r2); err != nil {
+		return err
+	}
+	if c.Sign() <= 0 || c.Cmp(params.Q) >= 0 {
+		return ErrChallengeOutOfRange
+	}
+	if s.Sign() < 0 || s.Cmp(params.Q) >= 0 {
+		return ErrResponseOutOfRange
+	}
+	return nil
+}
+
+func verifyChaumPedersen(params *Params, gExp, hExp func(*big.Int) *big.Int, y1, y2, r1, r2, c, s *big.Int) error {
+	if err := checkProofRanges(params, y1, y2, r1, r2, c, s); err != nil {
+		return err
+	}
+	if err := ValidateStatement(params, y1, y2); err != nil {
+		return err
+	}
+
+	// r1 = g^s . y1^c mod p
+	if r1.Cmp(mulMod(gExp(s), new(big.Int).Exp(y1, c, params.P), params.P)) != 0 {
+		return ErrR1Mismatch
+	}
+	// r2 = h^s . y2^c mod p
+	if r2.Cmp(mulMod(hExp(s), new(big.Int).Exp(y2, c, params.P), params.P)) != 0 {
+		return ErrR2Mismatch
+	}
+	return nil
+}
@@ -3,43 +3,16 @@ package utils
 import (
 	"crypto/rand"
 	"fmt"
-	"log"
+	"io"
 	"math/big"
 )
 
 // ValidatePublicVariables takes the public variables and validates them
+// It runs every check of ValidateParams under PolicyToy, so it accepts the small example groups,
+// callers that want a minimum size should call ValidateParams with a stricter policy
 func ValidatePublicVariables(p *big.Int, q *big.Int, g *big.Int, h *big.Int) (bool, error) {
-	// p is a prime number
-	if !p.ProbablyPrime(20) {
-		return false, fmt.Errorf("p:'%d' is not prime", p)
-	}
-
-	// q is a prime number
-	if !q.ProbablyPrime(20) {
-		return false, fmt.Errorf("q:'%d' is not prime", q)
-	}
-
-	// This validates that q divides p - 1 evenly
-	pDivQ := new(big.Int).Div(new(big.Int).Sub(p, big.NewInt(1)), q)
-	if pDivQ.Mod(big.NewInt(0), big.NewInt(2)) == big.NewInt(0) {
-		return false, fmt.Errorf("q:'%d' needs to divide evenly to p-1 where p:'%d'", q, p)
-	}
-
-	// TODO: use this to valid the order of g and h
-	// g and h must have the same prime order
-	// g^q mod p = 1
-	gpq := new(big.Int).Exp(g, q, p)
-	if gpq.Cmp(big.NewInt(1)) != 0 {
-		return false, fmt.Errorf("g:'%d' does not have order q:'%d'", g, q)
-	}
-
-	// h^q mod p = 1
-	hpq := new(big.Int).Exp(h, q, p)
-	if hpq.Cmp(big.NewInt(1)) != 0 {
-		return false, fmt.Errorf("h:'%d' does not have order q:'%d'", h, q)
-	}
-
-	return true, nil
+	report := ValidateParams(&Params{P: p, Q: q, G: g, H: h}, PolicyToy)
+	return report.Valid(), report.Err()
 }
 
 // Prover needs to compute S with their random k and the challenger's c
@@ -51,28 +24,52 @@ func CalculateS(k *big.Int, c *big.Int, x *big.Int, q *big.Int) *big.Int {
 // This method is used to verfiy proof
 // r1 = g^s . y1^c mod p
 // r2 = h^s . y2^c mod p
+// It only checks the equation, VerifyChaumPedersen checks the ranges of the numbers as well
+// A mismatch is returned as ErrRMismatch, VerifyChaumPedersen says which of r1 and r2 it was
 func VerifyProof(r *big.Int, gh *big.Int, s *big.Int, y *big.Int, c *big.Int, p *big.Int) (bool, error) {
+	if p.Cmp(big.NewInt(1)) <= 0 {
+		return false, fmt.Errorf("p:'%d' needs to be more than 1", p)
+	}
+	// Exp inverts the base for a negative exponent, and returns nil when it can't
 	lhs := new(big.Int).Exp(gh, s, p)
 	rhs := new(big.Int).Exp(y, c, p)
+	if lhs == nil || rhs == nil {
+		return false, fmt.Errorf("gh:'%d' or y:'%d' has no inverse mod p:'%d' for a negative exponent s:'%d' c:'%d'", gh, y, p, s, c)
+	}
 
 	if r.Cmp(new(big.Int).Mod(new(big.Int).Mul(lhs, rhs), p)) != 0 {
-		log.Println("r1 does not match")
-		return false, fmt.Errorf("r:'%d' does not match gh:'%d' s:'%d' y:'%d' c:'%d' p:'%d'", r, gh, s, y, c, p)
+		return false, fmt.Errorf("%w: r:'%d' gh:'%d' s:'%d' y:'%d' c:'%d' p:'%d'", ErrRMismatch, r, gh, s, y, c, p)
 	}
 
 	return true, nil
 }
 
-// We're using the Rand function from the crypto/rand package
-// This number should be big enough to be unique for this exercise
+// RandomScalar returns a uniform random number in [1, q), for k or x
+// The randomness comes from random, crypto/rand.Reader outside of tests, and an error
+// is returned rather than a panic when it can't be read
+func RandomScalar(q *big.Int, random io.Reader) (*big.Int, error) {
+	if q.Cmp(big.NewInt(2)) < 0 {
+		return nil, fmt.Errorf("q:'%d' has no numbers in [1, q)", q)
+	}
+	// rand.Int rejects samples of [0, q - 1) rather than reducing them, so there is no bias
+	n, err := randInt(random, new(big.Int).Sub(q, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	return n.Add(n, big.NewInt(1)), nil
+}
+
+// RandomBigInt returns a random number in [1, 2^256 - 1)
+//
+// Deprecated: the number has nothing to do with q, so as k or x it is biased mod q for a q
+// below 2^256 and doesn't cover [1, q) for a bigger one. Use RandomScalar(q, rand.Reader).
 func RandomBigInt() *big.Int {
-	// Max random value, a 16-bits integer, i.e 2^16 - 1
-	max := new(big.Int)
-	max.Exp(big.NewInt(2), big.NewInt(256), nil).Sub(max, big.NewInt(1))
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+	max.Sub(max, big.NewInt(1))
 
-	randInt, err := rand.Int(rand.Reader, max)
+	n, err := RandomScalar(max, rand.Reader)
 	if err != nil {
 		panic(err)
 	}
-	return randInt
+	return n
 }